@@ -0,0 +1,222 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+/*
+ Profiles config file (xds-gdb.toml) defines named sets of settings, for
+ example one per target board:
+
+	# profile used when XDS_PROFILE is not set
+	default = "m3ulcb"
+
+	[profiles.m3ulcb]
+	agent_url = "localhost:8800"
+	server_url = "http://xds-server:8000"
+	project_id = "IW7B4EE-DBY4Z74_myProject"
+	sdk_id = "poky-agl_aarch64_4.0.1"
+	rpath = "build"
+	overwrite_preset = "default"
+
+	[profiles.qemu]
+	native_gdb = "/usr/bin/gdb-multiarch"
+
+ Each setting name is the name of a xds-gdb variable without XDS_ prefix
+ (lowercase or not, IOW sdk_id or XDS_SDK_ID set XDS_SDK_ID variable).
+*/
+
+// Name of profiles config file searched when XDS_CONFIG is not a profiles file
+const xdsProfilesFile = "xds-gdb.toml"
+
+// ConfigProfiles - Content of profiles config file
+type ConfigProfiles struct {
+	Default  string                            `toml:"default"`
+	Profiles map[string]map[string]interface{} `toml:"profiles"`
+}
+
+// isProfilesFile returns true when file is a profiles (toml) config file
+func isProfilesFile(file string) bool {
+	return strings.ToLower(filepath.Ext(file)) == ".toml"
+}
+
+// profileVarName returns name of variable set by a profile setting
+// (eg. sdk_id -> XDS_SDK_ID)
+func profileVarName(key string) string {
+	name := strings.ToUpper(strings.TrimSpace(key))
+	if !strings.HasPrefix(name, "XDS_") {
+		name = "XDS_" + name
+	}
+	return name
+}
+
+// loadConfigProfile loads the selected profile (XDS_PROFILE, else default
+// profile) of profiles config file and sets variables that are not already
+// set (by environment or env config file).
+// Returns set variables, profiles file, profile name and variables origin.
+func loadConfigProfile(profFile string, envVars []EnvVar) (map[string]string, string, string, map[string]ConfigOrigin, error) {
+	envMap := make(map[string]string)
+	origins := make(map[string]ConfigOrigin)
+	profile := os.Getenv("XDS_PROFILE")
+
+	if profFile == "" {
+		profFile = searchConfigFile(xdsProfilesFile)
+	}
+	if profFile == "" {
+		if profile != "" {
+			return envMap, "", profile, origins, fmt.Errorf("Profile %s not found: no %s config file found", profile, xdsProfilesFile)
+		}
+		return envMap, "", "", origins, nil
+	}
+
+	var conf ConfigProfiles
+	if _, err := toml.DecodeFile(profFile, &conf); err != nil {
+		return envMap, profFile, profile, origins, fmt.Errorf("Error reading profiles config file %s: %v", profFile, err)
+	}
+	lines, err := profileLines(profFile)
+	if err != nil {
+		return envMap, profFile, profile, origins, fmt.Errorf("Error reading profiles config file %s: %v", profFile, err)
+	}
+
+	if profile == "" {
+		if conf.Default == "" {
+			log.Infof("No profile selected in %s", profFile)
+			return envMap, profFile, "", origins, nil
+		}
+		profile = conf.Default
+		os.Setenv("XDS_PROFILE", profile)
+		origins["XDS_PROFILE"] = ConfigOrigin{File: profFile, Line: lines["default"]}
+	}
+
+	settings, exist := conf.Profiles[profile]
+	if !exist {
+		names := []string{}
+		for n := range conf.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return envMap, profFile, profile, origins, fmt.Errorf("Profile %s not found in %s (available profiles: %s)",
+			profile, profFile, strings.Join(names, ", "))
+	}
+
+	// Check all settings before setting any variable, unknown settings are
+	// only returned in origins to be reported by validateConfig
+	values := make(map[string]string)
+	for key, v := range settings {
+		name := profileVarName(key)
+		origin := ConfigOrigin{File: profFile, Line: lines[profile+"."+key], Key: key}
+		if !isProfileSetting(envVars, name) {
+			origins[name] = origin
+			continue
+		}
+		val, err := profileValue(v)
+		if err != nil {
+			return envMap, profFile, profile, origins, fmt.Errorf("Invalid value of %s in profile %s (%s): %v", key, profile, origin, err)
+		}
+		values[name] = val
+		origins[name] = origin
+	}
+
+	// environment and env config file take precedence over profile
+	for name, val := range values {
+		if _, exist := os.LookupEnv(name); exist {
+			origins[name] = ConfigOrigin{Overrides: origins[name].String()}
+			continue
+		}
+		os.Setenv(name, val)
+		envMap[name] = val
+	}
+
+	log.Infof("Use profile %s of %s", profile, profFile)
+	return envMap, profFile, profile, origins, nil
+}
+
+//***** Private functions *****
+
+// isProfileSetting returns true when variable can be set by a profile
+func isProfileSetting(envVars []EnvVar, name string) bool {
+	return findEnvVar(envVars, name) != nil && name != "XDS_CONFIG" && name != "XDS_PROFILE"
+}
+
+// profileValue converts a toml value into variable value
+// (arrays are converted into comma separated list, eg. for XDS_PATH_MAP)
+func profileValue(v interface{}) (string, error) {
+	switch val := v.(type) {
+	case string:
+		return val, nil
+	case int64, float64, bool:
+		return fmt.Sprint(val), nil
+	case []interface{}:
+		items := []string{}
+		for _, i := range val {
+			s, err := profileValue(i)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("unsupported type %T", v)
+}
+
+// profileLines returns line number of top level keys and of profiles
+// settings (indexed by profile.key)
+func profileLines(profFile string) (map[string]int, error) {
+	lines := make(map[string]int)
+	fd, err := os.Open(profFile)
+	if err != nil {
+		return lines, err
+	}
+	defer fd.Close()
+
+	reTable := regexp.MustCompile(`^\[\s*profiles\.(?:"([^"]+)"|([^\s\]]+))\s*\]`)
+	reKey := regexp.MustCompile(`^(?:"([^"]+)"|([A-Za-z0-9_-]+))\s*=`)
+	section, num := "", 0
+	sc := bufio.NewScanner(fd)
+	for sc.Scan() {
+		num++
+		ln := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(ln, "[") {
+			section = "?"
+			if m := reTable.FindStringSubmatch(ln); m != nil {
+				section = m[1] + m[2]
+			}
+			continue
+		}
+		if m := reKey.FindStringSubmatch(ln); m != nil {
+			key := m[1] + m[2]
+			if section != "" {
+				key = section + "." + key
+			}
+			lines[key] = num
+		}
+	}
+	return lines, sc.Err()
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testProfiles = `# test profiles
+default = "board1"
+
+[profiles.board1]
+sdk_id = "sdk1"
+XDS_RPATH = "build"
+reconnect_timeout = 30
+path_map = ["/a=/b", "/c=/d"]
+
+[profiles."qemu x86"]
+native_gdb = "/usr/bin/gdb-multiarch"
+`
+
+var testProfileVars = []EnvVar{
+	EnvVar{Name: "XDS_CONFIG"},
+	EnvVar{Name: "XDS_PROFILE"},
+	EnvVar{Name: "XDS_SDK_ID"},
+	EnvVar{Name: "XDS_RPATH"},
+	EnvVar{Name: "XDS_RECONNECT_TIMEOUT"},
+	EnvVar{Name: "XDS_PATH_MAP"},
+	EnvVar{Name: "XDS_NATIVE_GDB"},
+}
+
+func unsetTestProfileVars() {
+	for _, ev := range testProfileVars {
+		os.Unsetenv(ev.Name)
+	}
+}
+
+func TestLoadConfigProfileDefault(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "xds-gdb-test")
+	defer os.RemoveAll(dir)
+	pf := writeTestFile(t, dir, "xds-gdb.toml", testProfiles)
+	unsetTestProfileVars()
+	defer unsetTestProfileVars()
+	os.Setenv("XDS_RPATH", "fromenv")
+
+	envMap, _, profile, origins, err := loadConfigProfile(pf, testProfileVars)
+	if err != nil {
+		t.Fatalf("loadConfigProfile failed: %v", err)
+	}
+	if profile != "board1" || os.Getenv("XDS_PROFILE") != "board1" {
+		t.Errorf("Invalid selected profile: %s", profile)
+	}
+	for k, v := range map[string]string{
+		"XDS_SDK_ID":            "sdk1",
+		"XDS_RPATH":             "fromenv",
+		"XDS_RECONNECT_TIMEOUT": "30",
+		"XDS_PATH_MAP":          "/a=/b,/c=/d",
+	} {
+		if os.Getenv(k) != v {
+			t.Errorf("Invalid value of %s: %q (expected %q)", k, os.Getenv(k), v)
+		}
+	}
+	if _, exist := envMap["XDS_RPATH"]; exist {
+		t.Errorf("Variable set by env must not be returned by profile")
+	}
+	for k, exp := range map[string]string{
+		"XDS_PROFILE": pf + ":2",
+		"XDS_SDK_ID":  pf + ":5",
+		"XDS_RPATH":   "env (overrides " + pf + ":6)",
+	} {
+		if o := origins[k].String(); o != exp {
+			t.Errorf("Invalid origin of %s: %s (expected %s)", k, o, exp)
+		}
+	}
+}
+
+func TestLoadConfigProfileSelect(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "xds-gdb-test")
+	defer os.RemoveAll(dir)
+	pf := writeTestFile(t, dir, "boards.toml", testProfiles)
+	unsetTestProfileVars()
+	defer unsetTestProfileVars()
+
+	os.Setenv("XDS_PROFILE", "qemu x86")
+	_, _, _, origins, err := loadConfigProfile(pf, testProfileVars)
+	if err != nil {
+		t.Fatalf("loadConfigProfile failed: %v", err)
+	}
+	if os.Getenv("XDS_NATIVE_GDB") != "/usr/bin/gdb-multiarch" || os.Getenv("XDS_SDK_ID") != "" {
+		t.Errorf("Invalid profile settings applied")
+	}
+	if o := origins["XDS_NATIVE_GDB"].String(); o != pf+":11" {
+		t.Errorf("Invalid origin of XDS_NATIVE_GDB: %s", o)
+	}
+
+	os.Setenv("XDS_PROFILE", "unknown")
+	if _, _, _, _, err := loadConfigProfile(pf, testProfileVars); err == nil ||
+		!strings.Contains(err.Error(), "available profiles: board1, qemu x86") {
+		t.Errorf("Unknown profile must be rejected: %v", err)
+	}
+}
+
+func TestLoadConfigProfileInvalid(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "xds-gdb-test")
+	defer os.RemoveAll(dir)
+	unsetTestProfileVars()
+	defer unsetTestProfileVars()
+
+	for content, exp := range map[string]string{
+		"[profiles.b]\nsdk_id = { a = 1 }\n": "Invalid value of sdk_id",
+		"[profiles.b\n":                      "Error reading profiles config file",
+	} {
+		pf := writeTestFile(t, dir, "p.toml", content)
+		os.Setenv("XDS_PROFILE", "b")
+		if _, _, _, _, err := loadConfigProfile(pf, testProfileVars); err == nil || !strings.Contains(err.Error(), exp) {
+			t.Errorf("Expected error %q, got: %v", exp, err)
+		}
+		if os.Getenv("XDS_SDK_ID") != "" {
+			t.Errorf("No variable must be set on error")
+		}
+	}
+}
+
+func TestLoadConfigProfileUnknownSettings(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "xds-gdb-test")
+	defer os.RemoveAll(dir)
+	unsetTestProfileVars()
+	defer unsetTestProfileVars()
+
+	pf := writeTestFile(t, dir, "p.toml", "[profiles.b]\nsdk_id = \"x\"\nsdkid = \"y\"\nprofile = \"z\"\n")
+	os.Setenv("XDS_PROFILE", "b")
+	envMap, _, _, origins, err := loadConfigProfile(pf, testProfileVars)
+	if err != nil {
+		t.Fatalf("Unknown settings must be reported by validateConfig: %v", err)
+	}
+	if envMap["XDS_SDK_ID"] != "x" || os.Getenv("XDS_SDKID") != "" || os.Getenv("XDS_PROFILE") != "b" {
+		t.Errorf("Invalid profile settings applied: %v", envMap)
+	}
+
+	// reported with other errors
+	os.Setenv("XDS_LOGLEVEL", "verbose")
+	defer os.Unsetenv("XDS_LOGLEVEL")
+	vars := append([]EnvVar{EnvVar{Name: "XDS_LOGLEVEL", Validate: validateLogLevel}}, testProfileVars...)
+	err = validateConfig(vars, envMap, origins)
+	if err == nil {
+		t.Fatal("Unknown profile settings not reported")
+	}
+	for _, exp := range []string{
+		pf + ":3: Unknown profile setting sdkid, did you mean sdk_id?",
+		pf + ":4: Setting profile cannot be set in a profile",
+		"env: Invalid value of XDS_LOGLEVEL",
+	} {
+		if !strings.Contains(err.Error(), exp) {
+			t.Errorf("%q not found in report:\n%v", exp, err)
+		}
+	}
+}
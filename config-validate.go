@@ -0,0 +1,242 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// validateConfig checks all settings before using them and returns an
+// aggregated report of errors: unknown XDS_* variables defined in config
+// files (confVars), unknown profile settings (origins including a key) and
+// invalid values of known variables
+func validateConfig(envVars []EnvVar, confVars map[string]string, origins map[string]ConfigOrigin) error {
+	errs := []string{}
+	where := func(name string) string {
+		if o, exist := origins[name]; exist {
+			return o.String()
+		}
+		return "env"
+	}
+
+	names := []string{}
+	for name := range confVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !strings.HasPrefix(name, "XDS_") || findEnvVar(envVars, name) != nil {
+			continue
+		}
+		msg := fmt.Sprintf("%s: Unknown variable %s", where(name), name)
+		if s := configSuggest(name, envVars); s != "" {
+			msg += ", did you mean " + s + "?"
+		}
+		errs = append(errs, msg)
+	}
+
+	names = []string{}
+	for name, o := range origins {
+		if o.Key != "" && !isProfileSetting(envVars, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key := origins[name].Key
+		if findEnvVar(envVars, name) != nil {
+			errs = append(errs, fmt.Sprintf("%s: Setting %s cannot be set in a profile", where(name), key))
+			continue
+		}
+		msg := fmt.Sprintf("%s: Unknown profile setting %s", where(name), key)
+		if s := configSuggest(name, envVars); s != "" {
+			msg += ", did you mean " + strings.ToLower(strings.TrimPrefix(s, "XDS_")) + "?"
+		}
+		errs = append(errs, msg)
+	}
+
+	for _, ev := range envVars {
+		val, exist := os.LookupEnv(ev.Name)
+		if !exist || ev.Validate == nil {
+			continue
+		}
+		if err := ev.Validate(strings.TrimSpace(val)); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: Invalid value of %s: %v", where(ev.Name), ev.Name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Invalid configuration:\n  - %s", strings.Join(errs, "\n  - "))
+	}
+	return nil
+}
+
+// findEnvVar returns the definition of a variable (nil when unknown)
+func findEnvVar(envVars []EnvVar, name string) *EnvVar {
+	for i := range envVars {
+		if envVars[i].Name == name {
+			return &envVars[i]
+		}
+	}
+	return nil
+}
+
+// maskSecrets returns a copy of variables where values of secret variables
+// are hidden (eg. to log them)
+func maskSecrets(vars map[string]string, envVars []EnvVar) map[string]string {
+	masked := make(map[string]string)
+	for k, v := range vars {
+		if ev := findEnvVar(envVars, k); ev != nil && ev.Secret && v != "" {
+			v = "********"
+		}
+		masked[k] = v
+	}
+	return masked
+}
+
+// configSuggest returns the closest known variable name (empty when none is
+// close enough)
+func configSuggest(name string, envVars []EnvVar) string {
+	best, bestDist := "", -1
+	for _, ev := range envVars {
+		if d := levenshtein(strings.ToUpper(name), ev.Name); bestDist < 0 || d < bestDist {
+			best, bestDist = ev.Name, d
+		}
+	}
+	// allow about one typo every 4 characters
+	if bestDist < 0 || bestDist > 1+len(strings.TrimPrefix(name, "XDS_"))/4 {
+		return ""
+	}
+	return best
+}
+
+// validateURL checks syntax of an url (scheme is optional, default http)
+func validateURL(val string) error {
+	if val == "" {
+		return nil
+	}
+	u := val
+	if !strings.Contains(u, "://") {
+		u = "http://" + u
+	}
+	pu, err := url.Parse(u)
+	if err != nil {
+		return fmt.Errorf("invalid url %s", val)
+	}
+	if pu.Scheme != "http" && pu.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %s in url %s (supported: http, https)", pu.Scheme, val)
+	}
+	if pu.Hostname() == "" {
+		return fmt.Errorf("missing host in url %s", val)
+	}
+	if p := pu.Port(); p != "" {
+		if n, err := strconv.Atoi(p); err != nil || n <= 0 || n > 65535 {
+			return fmt.Errorf("invalid port %s in url %s", p, val)
+		}
+	}
+	return nil
+}
+
+// validateLogLevel checks a logging level
+func validateLogLevel(val string) error {
+	if _, err := logrus.ParseLevel(val); err != nil {
+		return fmt.Errorf("unknown level %s (supported levels: panic, fatal, error, warn, info, debug)", val)
+	}
+	return nil
+}
+
+// validateBool checks a boolean value (empty value means true)
+func validateBool(val string) error {
+	_, err := parseBool(val)
+	return err
+}
+
+// validateUint checks a positive or null integer value
+func validateUint(val string) error {
+	if val == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(val); err != nil || n < 0 {
+		return fmt.Errorf("%s is not a positive integer", val)
+	}
+	return nil
+}
+
+// validateNonZeroUint checks an integer value greater than 0
+func validateNonZeroUint(val string) error {
+	if val == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(val); err != nil || n <= 0 {
+		return fmt.Errorf("%s is not an integer greater than 0", val)
+	}
+	return nil
+}
+
+// validatePathMap checks paths mapping list syntax
+func validatePathMap(val string) error {
+	_, err := ParsePathMapList(val)
+	return err
+}
+
+// parseBool parses a boolean value (1/0, true/false, yes/no or on/off),
+// empty value means true (IOW variable is defined)
+func parseBool(val string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(val)) {
+	case "", "1", "true", "yes", "on":
+		return true, nil
+	case "0", "false", "no", "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("%s is not a boolean (supported: 1/0, true/false, yes/no, on/off)", val)
+}
+
+// levenshtein returns the edit distance between two strings
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
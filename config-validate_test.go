@@ -0,0 +1,105 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+var testValidateVars = []EnvVar{
+	EnvVar{Name: "XDS_PROJECT_ID"},
+	EnvVar{Name: "XDS_SDK_ID"},
+	EnvVar{Name: "XDS_LOGLEVEL", Validate: validateLogLevel},
+	EnvVar{Name: "XDS_AGENT_URL", Validate: validateURL},
+	EnvVar{Name: "XDS_TEST_BOOL", Validate: validateBool},
+	EnvVar{Name: "XDS_HEARTBEAT_MAX_MISSED", Validate: validateNonZeroUint},
+}
+
+func TestValidateConfig(t *testing.T) {
+	for _, ev := range testValidateVars {
+		os.Unsetenv(ev.Name)
+	}
+	os.Setenv("XDS_LOGLEVEL", "verbose")
+	os.Setenv("XDS_AGENT_URL", "http://localhost:88000")
+	os.Setenv("XDS_TEST_BOOL", "maybe")
+	os.Setenv("XDS_HEARTBEAT_MAX_MISSED", "0")
+	defer func() {
+		for _, ev := range testValidateVars {
+			os.Unsetenv(ev.Name)
+		}
+	}()
+
+	confVars := map[string]string{"XDS_PROJET_ID": "prj", "XDS_SDK_ID": "sdk", "OTHER_VAR": "x", "XDS_UNRELATED_THING": "x"}
+	origins := map[string]ConfigOrigin{
+		"XDS_PROJET_ID": ConfigOrigin{File: "gdb.ini", Line: 3},
+		"XDS_LOGLEVEL":  ConfigOrigin{File: "xds-gdb.env", Line: 1},
+	}
+	err := validateConfig(testValidateVars, confVars, origins)
+	if err == nil {
+		t.Fatal("Invalid configuration not detected")
+	}
+	for _, exp := range []string{
+		"gdb.ini:3: Unknown variable XDS_PROJET_ID, did you mean XDS_PROJECT_ID?",
+		"env: Unknown variable XDS_UNRELATED_THING\n",
+		"xds-gdb.env:1: Invalid value of XDS_LOGLEVEL: unknown level verbose",
+		"env: Invalid value of XDS_AGENT_URL: invalid port 88000",
+		"env: Invalid value of XDS_TEST_BOOL: maybe is not a boolean",
+		"env: Invalid value of XDS_HEARTBEAT_MAX_MISSED: 0 is not an integer greater than 0",
+	} {
+		if !strings.Contains(err.Error(), exp) {
+			t.Errorf("%q not found in report:\n%v", exp, err)
+		}
+	}
+	if strings.Contains(err.Error(), "OTHER_VAR") || strings.Contains(err.Error(), "XDS_SDK_ID") {
+		t.Errorf("Unexpected error in report:\n%v", err)
+	}
+
+	os.Setenv("XDS_LOGLEVEL", "debug")
+	os.Setenv("XDS_AGENT_URL", "localhost:8800")
+	os.Setenv("XDS_TEST_BOOL", "")
+	os.Setenv("XDS_HEARTBEAT_MAX_MISSED", "3")
+	if err := validateConfig(testValidateVars, map[string]string{"XDS_SDK_ID": "sdk"}, nil); err != nil {
+		t.Errorf("Valid configuration rejected: %v", err)
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	for _, u := range []string{"localhost:8800", "http://10.0.0.1:8000", "https://xds.example.com/base", "myhost"} {
+		if err := validateURL(u); err != nil {
+			t.Errorf("Valid url %s rejected: %v", u, err)
+		}
+	}
+	for _, u := range []string{"ftp://localhost", "http://:8000", "localhost:abc", "http://%zz"} {
+		if err := validateURL(u); err == nil {
+			t.Errorf("Invalid url %s accepted", u)
+		}
+	}
+}
+
+func TestGdbSetConfigType(t *testing.T) {
+	g := NewGdbXds(nil, nil, nil)
+	if err := g.SetConfig("prjID", 12); err == nil {
+		t.Errorf("Invalid type must be rejected")
+	}
+	if err := g.SetConfig("listProject", "true"); err == nil {
+		t.Errorf("Invalid type must be rejected")
+	}
+}
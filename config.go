@@ -0,0 +1,748 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	common "github.com/iotbzh/xds-common/golib"
+)
+
+// Name of config env file searched when XDS_CONFIG is not set
+const xdsEnvFile = "xds-gdb.env"
+
+// ConfigOrigin - Where the value of a config variable comes from
+type ConfigOrigin struct {
+	File      string // config file path (empty when set by environment)
+	Line      int    // line number within config file
+	Overrides string // location of config file value overridden by environment
+	Key       string // setting key when set by a profile (eg. sdk_id)
+}
+
+// String returns origin description (eg. file:line or env)
+func (o ConfigOrigin) String() string {
+	if o.File == "" {
+		if o.Overrides != "" {
+			return "env (overrides " + o.Overrides + ")"
+		}
+		return "env"
+	}
+	return fmt.Sprintf("%s:%d", o.File, o.Line)
+}
+
+/*
+ Env config files (xds-gdb.env) are searched at several levels and merged, so
+ that for example a repository level file sets the project while the user file
+ sets the agent URL. Levels, from lowest to highest precedence:
+   1. system config file: /etc/xds/xds-gdb.env
+   2. user config file: $XDG_CONFIG_HOME/xds/xds-gdb.env (or ~/.config/xds)
+   3. files found in directories from project root (first parent directory
+      including .git, else home directory or file system mount point) down to
+      current directory, then in ./target directory
+   4. file set by XDS_CONFIG
+   5. :XDS-ENV: tags of gdb command file
+ Environment variables take precedence over all config files.
+ Discovered files that are not owned by user (or root) or that are writable by
+ group or others are ignored.
+*/
+
+// Directory of system level config files
+var configSystemDir = func() string {
+	if runtime.GOOS == "windows" {
+		return path.Join(os.Getenv("ProgramData"), "xds")
+	}
+	return "/etc/xds"
+}()
+
+// configLayer - Lines of one of the merged env config files
+type configLayer struct {
+	File  string
+	Lines []configLine
+}
+
+// searchConfigFile searches a config file (eg. xds-gdb.env) and returns the
+// one of highest precedence level
+func searchConfigFile(name string) string {
+	files := configLayerFiles(name)
+	if len(files) == 0 {
+		return ""
+	}
+	return files[len(files)-1]
+}
+
+// configLayerFiles returns existing config files (eg. xds-gdb.env) of all
+// levels, from lowest to highest precedence
+func configLayerFiles(name string) []string {
+	files := []string{}
+	dirs := configSearchDirs()
+	for i := len(dirs) - 1; i >= 0; i-- {
+		cf := path.Join(dirs[i], name)
+		log.Debugf("Search config in %s", cf)
+		if !common.Exists(cf) {
+			continue
+		}
+		if err := checkConfigFileOwner(cf); err != nil {
+			log.Warnf("Ignore config file %s: %v", cf, err)
+			continue
+		}
+		files = append(files, cf)
+	}
+	return files
+}
+
+// configProjectRoot returns project root directory, IOW first parent
+// directory of current directory including .git (empty when not found)
+func configProjectRoot() string {
+	curDir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for d := curDir; ; d = path.Dir(d) {
+		if common.Exists(path.Join(d, ".git")) {
+			return d
+		}
+		if configStopDir(d) {
+			return ""
+		}
+	}
+}
+
+// configStopDir returns true when parent directories of dir must not be
+// searched: dir is home directory, file system root or a mount point
+func configStopDir(dir string) bool {
+	parent := path.Dir(dir)
+	if parent == dir || dir == path.Clean(os.Getenv("HOME")) {
+		return true
+	}
+	if u, err := user.Current(); err == nil && dir == path.Clean(u.HomeDir) {
+		return true
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return true
+	}
+	pfi, err := os.Stat(parent)
+	if err != nil {
+		return true
+	}
+	_, dev, ok := fileOwner(fi)
+	_, pdev, pok := fileOwner(pfi)
+	return ok && pok && dev != pdev
+}
+
+// checkConfigFileOwner checks that a discovered or included config file can
+// be trusted: owned by user (or root) and not writable by group or others
+func checkConfigFileOwner(file string) error {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	uid, _, ok := fileOwner(fi)
+	if !ok {
+		return nil
+	}
+	if uid != os.Getuid() && uid != 0 {
+		return fmt.Errorf("owned by another user (uid %d)", uid)
+	}
+	if fi.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("permissions %04o are too open, it must not be writable by group or others (eg. chmod go-w %s)",
+			fi.Mode().Perm(), file)
+	}
+	return nil
+}
+
+// configSearchDirs returns directories where config files are searched, from
+// highest to lowest precedence
+func configSearchDirs() []string {
+	dirs := []string{}
+	if curDir, err := os.Getwd(); err == nil {
+		dirs = append(dirs, path.Join(curDir, "target"))
+		root := configProjectRoot()
+		for d := curDir; ; d = path.Dir(d) {
+			dirs = append(dirs, d)
+			if d == root || configStopDir(d) {
+				break
+			}
+		}
+	}
+	dirs = append(dirs, configUserDir(), configSystemDir)
+
+	// a directory may be at several levels (eg. user directory is current one)
+	uniq := []string{}
+	seen := make(map[string]bool)
+	for _, d := range dirs {
+		d = path.Clean(d)
+		if !seen[d] {
+			seen[d] = true
+			uniq = append(uniq, d)
+		}
+	}
+	return uniq
+}
+
+// configUserDir returns directory of user level config files
+func configUserDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return path.Join(dir, "xds")
+	}
+	home := os.Getenv("HOME")
+	if u, err := user.Current(); err == nil {
+		home = u.HomeDir
+	}
+	return path.Join(home, ".config", "xds")
+}
+
+// configLine - Line of env config file (Num is the line number in source file)
+type configLine struct {
+	Text string
+	Num  int
+}
+
+// configEntry - Variable definition read from env config file
+type configEntry struct {
+	Name   string
+	Value  string
+	Origin ConfigOrigin
+}
+
+// readConfigLines reads all lines of an env config file
+func readConfigLines(file string) ([]configLine, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	lines := []configLine{}
+	sc := bufio.NewScanner(fd)
+	for sc.Scan() {
+		lines = append(lines, configLine{Text: sc.Text(), Num: len(lines) + 1})
+	}
+	return lines, sc.Err()
+}
+
+// readConfigEnv returns variables defined by lines of an env config file
+// (file is used to resolve included files and to set origin of variables).
+// Values may reference variables (${VAR}, $VAR or ${VAR:-default}) that are
+// defined by environment (that takes precedence) or previously in config,
+// and include directive (include <file>) reads another env config file.
+func readConfigEnv(file string, lines []configLine) ([]configEntry, error) {
+	r := &configReader{values: make(map[string]string)}
+	err := r.read(file, lines)
+	return r.entries, err
+}
+
+// readConfigLayers returns variables defined by env config layers (lowest
+// precedence first), values of a layer may reference variables defined by
+// lower layers
+func readConfigLayers(layers []configLayer) ([]configEntry, error) {
+	r := &configReader{values: make(map[string]string)}
+	for _, l := range layers {
+		if err := r.read(l.File, l.Lines); err != nil {
+			return r.entries, err
+		}
+	}
+	return r.entries, nil
+}
+
+// configLineKey returns the variable name defined by a line of env file
+// (empty string for comments or blank lines)
+func configLineKey(line string) string {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ""
+	}
+	line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+	idx := strings.IndexAny(line, "=:")
+	if idx <= 0 {
+		return ""
+	}
+	return strings.TrimSpace(line[:idx])
+}
+
+// runConfigCommand executes config sub-command (show, get, set or unset)
+func runConfigCommand(args []string, envVars []EnvVar, origins map[string]ConfigOrigin, out io.Writer) (int, error) {
+	usage := "usage: " + AppName + " config show [gdb args] | get <NAME> | set <NAME> <VALUE> | unset <NAME>"
+	if len(args) == 0 {
+		return int(syscall.EINVAL), fmt.Errorf(usage)
+	}
+
+	// Check variable name and number of arguments
+	// (gdb args of show are only used to retrieve :XDS-ENV: tags of gdb command file)
+	nbArgs := map[string]int{"show": 0, "get": 1, "set": 2, "unset": 1}
+	nb, exist := nbArgs[args[0]]
+	if !exist || (args[0] != "show" && len(args)-1 != nb) {
+		return int(syscall.EINVAL), fmt.Errorf(usage)
+	}
+	if nb > 0 {
+		ev := findEnvVar(envVars, args[1])
+		if ev == nil {
+			msg := "Unknown config variable " + args[1]
+			if s := configSuggest(args[1], envVars); s != "" {
+				msg += ", did you mean " + s + "?"
+			}
+			return int(syscall.EINVAL), fmt.Errorf(msg)
+		}
+		if args[0] == "set" && ev.Validate != nil {
+			if err := ev.Validate(strings.TrimSpace(args[2])); err != nil {
+				return int(syscall.EINVAL), fmt.Errorf("Invalid value of %s: %v", args[1], err)
+			}
+		}
+	}
+
+	if args[0] == "show" {
+		writer := new(tabwriter.Writer)
+		writer.Init(out, 0, 8, 1, ' ', 0)
+		fmt.Fprintln(writer, "NAME\tVALUE\tORIGIN")
+		for _, ev := range envVars {
+			val, origin := "", "unset"
+			if v, exist := os.LookupEnv(ev.Name); exist {
+				val = v
+				origin = "env"
+				if o, ok := origins[ev.Name]; ok {
+					origin = o.String()
+				}
+			} else if ev.Destination != nil && *ev.Destination != "" {
+				val = *ev.Destination
+				origin = "default"
+				if ev.Name == "XDS_CONFIG" {
+					origin = "discovered"
+				}
+			}
+			if ev.Secret && val != "" {
+				val = "********"
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", ev.Name, val, origin)
+		}
+		writer.Flush()
+		return 0, nil
+	}
+
+	confFile := configTargetFile()
+	if isProfilesFile(confFile) {
+		return int(syscall.EINVAL), fmt.Errorf("Cannot %s %s in profiles config file %s, edit it directly", args[0], args[1], confFile)
+	}
+	switch args[0] {
+	case "get":
+		if !common.Exists(confFile) {
+			return int(syscall.ENOENT), fmt.Errorf("%s not set (config file %s not found)", args[1], confFile)
+		}
+		lines, err := readConfigLines(confFile)
+		if err != nil {
+			return int(syscall.EINVAL), fmt.Errorf("Error reading env config file %s: %v", confFile, err)
+		}
+		entries, err := readConfigEnv(confFile, lines)
+		if err != nil {
+			return int(syscall.EINVAL), fmt.Errorf("Error reading env config file %v", err)
+		}
+		val, exist := "", false
+		for _, e := range entries {
+			if e.Name == args[1] {
+				val, exist = e.Value, true
+			}
+		}
+		if !exist {
+			return int(syscall.ENOENT), fmt.Errorf("%s not set in %s", args[1], confFile)
+		}
+		fmt.Fprintln(out, val)
+
+	case "set":
+		if err := configEditFile(confFile, args[1], &args[2]); err != nil {
+			return int(syscall.EIO), err
+		}
+		fmt.Fprintf(out, "%s set in %s\n", args[1], confFile)
+
+	case "unset":
+		if err := configEditFile(confFile, args[1], nil); err != nil {
+			return int(syscall.EIO), err
+		}
+		fmt.Fprintf(out, "%s unset in %s\n", args[1], confFile)
+	}
+	return 0, nil
+}
+
+// configTargetFile returns the config file edited by config sub-command:
+// XDS_CONFIG, else discovered xds-gdb.env of highest precedence level, else
+// user config directory file
+func configTargetFile() string {
+	if cf := os.Getenv("XDS_CONFIG"); cf != "" {
+		return cf
+	}
+	if cf := searchConfigFile(xdsEnvFile); cf != "" {
+		return cf
+	}
+	return path.Join(configUserDir(), xdsEnvFile)
+}
+
+// configEditFile sets (or removes when value is nil) a variable in config file.
+// Other lines are kept unchanged and file is atomically replaced.
+func configEditFile(confFile, name string, value *string) error {
+	lines, mode, err := configReadFile(confFile)
+	if err != nil {
+		return err
+	}
+	if lines == nil && value == nil {
+		return fmt.Errorf("Config file %s not found", confFile)
+	}
+
+	newLines := []string{}
+	done := false
+	for _, ln := range lines {
+		if configLineKey(ln) != name {
+			newLines = append(newLines, ln)
+			continue
+		}
+		// replace first definition and remove duplicates
+		if value != nil && !done {
+			prefix := ""
+			if strings.HasPrefix(strings.TrimSpace(ln), "export ") {
+				prefix = "export "
+			}
+			newLines = append(newLines, prefix+name+"="+configQuoteValue(*value))
+			done = true
+		}
+	}
+	if value != nil && !done {
+		newLines = append(newLines, name+"="+configQuoteValue(*value))
+	}
+	return configWriteFile(confFile, newLines, mode)
+}
+
+// configEditCmdFile sets a variable in gdb command file using :XDS-ENV: tag.
+// Tag is added after the last existing one (or at beginning of file).
+func configEditCmdFile(cmdFile, name, value string) error {
+	lines, mode, err := configReadFile(cmdFile)
+	if err != nil {
+		return err
+	}
+	if lines == nil {
+		return fmt.Errorf("gdb command file %s not found", cmdFile)
+	}
+
+	tag := "# :XDS-ENV: " + name + "=" + configQuoteValue(value)
+	newLines := []string{}
+	done := false
+	lastTag := -1
+	for _, ln := range lines {
+		text := strings.TrimSpace(ln)
+		if !strings.HasPrefix(text, "#") || !strings.Contains(text, ":XDS-ENV:") {
+			newLines = append(newLines, ln)
+			continue
+		}
+		env := strings.SplitAfterN(text, ":XDS-ENV:", 2)
+		if configLineKey(env[1]) != name {
+			newLines = append(newLines, ln)
+			lastTag = len(newLines) - 1
+			continue
+		}
+		// replace first definition and remove duplicates
+		if !done {
+			newLines = append(newLines, tag)
+			lastTag = len(newLines) - 1
+			done = true
+		}
+	}
+	if !done {
+		newLines = append(newLines[:lastTag+1], append([]string{tag}, newLines[lastTag+1:]...)...)
+	}
+	return configWriteFile(cmdFile, newLines, mode)
+}
+
+// configReadFile returns lines and permissions of a file
+// (nil lines when file doesn't exist)
+func configReadFile(file string) ([]string, os.FileMode, error) {
+	fi, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		return nil, 0644, nil
+	} else if err != nil {
+		return nil, 0, fmt.Errorf("Cannot read config file %s: %v", file, err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Cannot read config file %s: %v", file, err)
+	}
+	lines := []string{}
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	return lines, fi.Mode().Perm(), nil
+}
+
+// configWriteFile atomically replaces file content
+func configWriteFile(file string, lines []string, mode os.FileMode) error {
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Cannot create config directory %s: %v", dir, err)
+	}
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(file))
+	if err != nil {
+		return fmt.Errorf("Cannot create temporary config file: %v", err)
+	}
+	content := strings.Join(lines, "\n")
+	if len(lines) > 0 {
+		content += "\n"
+	}
+	_, err = tmp.WriteString(content)
+	if errC := tmp.Close(); err == nil {
+		err = errC
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("Cannot write config file %s: %v", file, err)
+	}
+	return nil
+}
+
+// configQuoteValue quotes a value (when needed) to be correctly read back
+func configQuoteValue(v string) string {
+	if regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]*$`).MatchString(v) {
+		return v
+	}
+	if !strings.Contains(v, "'") {
+		return "'" + v + "'"
+	}
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, `"`, `\"`, -1)
+	v = strings.Replace(v, `$`, `\$`, -1)
+	return `"` + v + `"`
+}
+
+// configReader - Reader of env config files, used to expand variables and
+// process include directives
+type configReader struct {
+	stack   []string          // files being read (used to detect include cycles)
+	values  map[string]string // values of variables already read
+	entries []configEntry
+}
+
+func (r *configReader) read(file string, lines []configLine) error {
+	absFile, _ := filepath.Abs(file)
+	for i, f := range r.stack {
+		if f == absFile {
+			return fmt.Errorf("Include cycle detected: %s", strings.Join(append(r.stack[i:], absFile), " -> "))
+		}
+	}
+	r.stack = append(r.stack, absFile)
+	defer func() { r.stack = r.stack[:len(r.stack)-1] }()
+
+	for _, ln := range lines {
+		text := strings.TrimSpace(ln.Text)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		where := fmt.Sprintf("%s:%d", file, ln.Num)
+
+		// include directive: include <file>
+		if inc := configIncludeFile(text); inc != "" {
+			incFile, err := r.parseValue(inc)
+			if err != nil {
+				return fmt.Errorf("%s: %v", where, err)
+			}
+			if !filepath.IsAbs(incFile) {
+				incFile = filepath.Join(filepath.Dir(file), incFile)
+			}
+			incLines, err := readConfigLines(incFile)
+			if err != nil {
+				return fmt.Errorf("%s: Cannot include %s: %v", where, incFile, err)
+			}
+			if err := checkConfigFileOwner(incFile); err != nil {
+				log.Warnf("%s: Ignore included config file %s: %v", where, incFile, err)
+				continue
+			}
+			if err := r.read(incFile, incLines); err != nil {
+				return fmt.Errorf("%s: %v", where, err)
+			}
+			continue
+		}
+
+		name := configLineKey(text)
+		if !regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`).MatchString(name) {
+			return fmt.Errorf("%s: Invalid line: %s", where, text)
+		}
+		raw := strings.TrimSpace(strings.TrimPrefix(text, "export "))
+		raw = raw[strings.IndexAny(raw, "=:")+1:]
+		val, err := r.parseValue(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %v", where, err)
+		}
+		r.values[name] = val
+		r.entries = append(r.entries, configEntry{
+			Name:   name,
+			Value:  val,
+			Origin: ConfigOrigin{File: file, Line: ln.Num},
+		})
+	}
+	return nil
+}
+
+// parseValue unquotes and expands variables of a value:
+// 'single quoted' values are kept as is, "double quoted" values support
+// escape characters (\n, \", \$...), inline comments follow unquoted values
+func (r *configReader) parseValue(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+
+	quote := raw[0]
+	if quote != '\'' && quote != '"' {
+		if idx := strings.Index(raw, " #"); idx >= 0 {
+			raw = strings.TrimSpace(raw[:idx])
+		}
+		return r.expand(raw, false)
+	}
+
+	end := -1
+	for i := 1; i < len(raw); i++ {
+		if quote == '"' && raw[i] == '\\' {
+			i++
+		} else if raw[i] == quote {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return "", fmt.Errorf("Unterminated quoted value: %s", raw)
+	}
+	if rest := strings.TrimSpace(raw[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("Unexpected characters after quoted value: %s", rest)
+	}
+	if quote == '\'' {
+		return raw[1:end], nil
+	}
+	return r.expand(raw[1:end], true)
+}
+
+// expand replaces variables references (${VAR}, $VAR or ${VAR:-default})
+// and unescapes characters (only \$ when unescape is false)
+func (r *configReader) expand(s string, unescape bool) (string, error) {
+	var buf bytes.Buffer
+	reName := regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*`)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && (unescape || s[i+1] == '$'):
+			i++
+			switch s[i] {
+			case 'n':
+				buf.WriteByte('\n')
+			case 'r':
+				buf.WriteByte('\r')
+			default:
+				buf.WriteByte(s[i])
+			}
+
+		case c == '$' && i+1 < len(s) && s[i+1] == '{':
+			// search matching brace (default value may reference variables)
+			end, depth := -1, 0
+			for j := i + 1; j < len(s) && end < 0; j++ {
+				if s[j] == '{' {
+					depth++
+				} else if s[j] == '}' {
+					if depth--; depth == 0 {
+						end = j
+					}
+				}
+			}
+			if end < 0 {
+				return "", fmt.Errorf("Unterminated variable reference: %s", s[i:])
+			}
+			expr := s[i+2 : end]
+			name, def, hasDef := expr, "", false
+			if idx := strings.Index(expr, ":-"); idx >= 0 {
+				name, def, hasDef = expr[:idx], expr[idx+2:], true
+			}
+			if reName.FindString(name) != name || name == "" {
+				return "", fmt.Errorf("Invalid variable reference: ${%s}", expr)
+			}
+			val, exist := r.lookup(name)
+			if (!exist || val == "") && hasDef {
+				var err error
+				if val, err = r.expand(def, false); err != nil {
+					return "", err
+				}
+			}
+			buf.WriteString(val)
+			i = end
+
+		case c == '$' && reName.MatchString(s[i+1:]):
+			name := reName.FindString(s[i+1:])
+			val, _ := r.lookup(name)
+			buf.WriteString(val)
+			i += len(name)
+
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String(), nil
+}
+
+// lookup returns value of a variable: environment takes precedence over
+// variables already read, CWD defaults to current directory and
+// XDS_PROJECT_ROOT to project root directory (see configProjectRoot)
+func (r *configReader) lookup(name string) (string, bool) {
+	if val, exist := os.LookupEnv(name); exist {
+		return val, true
+	}
+	if val, exist := r.values[name]; exist {
+		return val, true
+	}
+	if name == "CWD" {
+		if dir, err := os.Getwd(); err == nil {
+			return dir, true
+		}
+	}
+	if name == "XDS_PROJECT_ROOT" {
+		if dir := configProjectRoot(); dir != "" {
+			return dir, true
+		}
+	}
+	log.Debugf("Variable %s referenced in config file is not set", name)
+	return "", false
+}
+
+// configIncludeFile returns the file of an include directive line
+// (empty when line is not an include directive)
+func configIncludeFile(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != "include" || strings.IndexAny(fields[1][:1], "=:") == 0 {
+		return ""
+	}
+	return strings.TrimSpace(line[len("include"):])
+}
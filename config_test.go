@@ -0,0 +1,391 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/joho/godotenv"
+)
+
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	f := path.Join(dir, name)
+	if err := ioutil.WriteFile(f, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestConfigLineKey(t *testing.T) {
+	for line, exp := range map[string]string{
+		"XDS_SDK_ID=abc":             "XDS_SDK_ID",
+		"  export XDS_RPATH = 'a b'": "XDS_RPATH",
+		"XDS_AGENT_URL: localhost":   "XDS_AGENT_URL",
+		"# XDS_SDK_ID=abc":           "",
+		"":                           "",
+		"=abc":                       "",
+	} {
+		if key := configLineKey(line); key != exp {
+			t.Errorf("configLineKey(%q) = %q, expected %q", line, key, exp)
+		}
+	}
+}
+
+func TestLoadConfigEnvFileOrigins(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "xds-gdb-test")
+	defer os.RemoveAll(dir)
+
+	cmdFile := writeTestFile(t, dir, "gdb.ini", "file main\n# :XDS-ENV: XDS_TEST_PRJ=prj1\nbreak main\n#:XDS-ENV:export XDS_TEST_SDK=sdk1\n")
+	os.Setenv("XDS_TEST_SDK", "fromenv")
+	defer os.Unsetenv("XDS_TEST_SDK")
+	defer os.Unsetenv("XDS_TEST_PRJ")
+
+	envMap, confFile, origins, err := loadConfigEnvFile("", cmdFile)
+	if err != nil {
+		t.Fatalf("loadConfigEnvFile failed: %v", err)
+	}
+	if confFile != cmdFile || envMap["XDS_TEST_PRJ"] != "prj1" {
+		t.Errorf("Invalid config: file=%s env=%v", confFile, envMap)
+	}
+	if o := origins["XDS_TEST_PRJ"].String(); o != cmdFile+":2" {
+		t.Errorf("Invalid origin of XDS_TEST_PRJ: %s", o)
+	}
+	if o := origins["XDS_TEST_SDK"].String(); o != "env (overrides "+cmdFile+":4)" {
+		t.Errorf("Invalid origin of XDS_TEST_SDK: %s", o)
+	}
+	if os.Getenv("XDS_TEST_SDK") != "fromenv" || os.Getenv("XDS_TEST_PRJ") != "prj1" {
+		t.Errorf("Environment not correctly set")
+	}
+}
+
+func TestConfigEditFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "xds-gdb-test")
+	defer os.RemoveAll(dir)
+	cf := writeTestFile(t, dir, "xds-gdb.env", "# comment\nexport XDS_SDK_ID=old\nXDS_RPATH=x\nXDS_SDK_ID=dup\n")
+
+	for _, v := range []string{"new", "a b#c", "it's", `q"u\o$te`, ""} {
+		val := v
+		if err := configEditFile(cf, "XDS_SDK_ID", &val); err != nil {
+			t.Fatalf("configEditFile failed: %v", err)
+		}
+		envMap, err := godotenv.Read(cf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if envMap["XDS_SDK_ID"] != v || envMap["XDS_RPATH"] != "x" {
+			t.Errorf("Invalid value read back: %q (expected %q)", envMap["XDS_SDK_ID"], v)
+		}
+	}
+	data, _ := ioutil.ReadFile(cf)
+	if !strings.HasPrefix(string(data), "# comment\nexport XDS_SDK_ID=") || strings.Count(string(data), "XDS_SDK_ID") != 1 {
+		t.Errorf("Invalid file content:\n%s", data)
+	}
+	if fi, _ := os.Stat(cf); fi.Mode().Perm() != 0600 {
+		t.Errorf("File permissions not preserved: %v", fi.Mode())
+	}
+
+	if err := configEditFile(cf, "XDS_SDK_ID", nil); err != nil {
+		t.Fatalf("configEditFile failed: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(cf); string(data) != "# comment\nXDS_RPATH=x\n" {
+		t.Errorf("Invalid file content after unset:\n%s", data)
+	}
+
+	// new file is created
+	nf := path.Join(dir, "sub", "xds-gdb.env")
+	val := "abc"
+	if err := configEditFile(nf, "XDS_SDK_ID", &val); err != nil {
+		t.Fatalf("configEditFile failed: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(nf); string(data) != "XDS_SDK_ID=abc\n" {
+		t.Errorf("Invalid new file content:\n%s", data)
+	}
+}
+
+func TestRunConfigCommand(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "xds-gdb-test")
+	defer os.RemoveAll(dir)
+	cf := writeTestFile(t, dir, "my.env", "XDS_TEST_A=1\n")
+	os.Setenv("XDS_CONFIG", cf)
+	defer os.Unsetenv("XDS_CONFIG")
+	os.Setenv("XDS_TEST_A", "1")
+	defer os.Unsetenv("XDS_TEST_A")
+
+	def := "default-value"
+	envVars := []EnvVar{
+		EnvVar{Name: "XDS_TEST_A"},
+		EnvVar{Name: "XDS_TEST_B", Destination: &def},
+		EnvVar{Name: "XDS_TEST_C"},
+	}
+	origins := map[string]ConfigOrigin{"XDS_TEST_A": ConfigOrigin{File: cf, Line: 1}}
+
+	var out bytes.Buffer
+	if _, err := runConfigCommand([]string{"show"}, envVars, origins, &out); err != nil {
+		t.Fatalf("show failed: %v", err)
+	}
+	for _, exp := range []string{"XDS_TEST_A 1 " + cf + ":1", "XDS_TEST_B default-value default", "XDS_TEST_C unset"} {
+		if !strings.Contains(strings.Join(strings.Fields(out.String()), " "), exp) {
+			t.Errorf("%q not found in:\n%s", exp, out.String())
+		}
+	}
+
+	out.Reset()
+	if _, err := runConfigCommand([]string{"set", "XDS_TEST_C", "v c"}, envVars, origins, &out); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	out.Reset()
+	if _, err := runConfigCommand([]string{"get", "XDS_TEST_C"}, envVars, origins, &out); err != nil || out.String() != "v c\n" {
+		t.Errorf("get failed: %q %v", out.String(), err)
+	}
+	if _, err := runConfigCommand([]string{"unset", "XDS_TEST_C"}, envVars, origins, &out); err != nil {
+		t.Fatalf("unset failed: %v", err)
+	}
+	if _, err := runConfigCommand([]string{"get", "XDS_TEST_C"}, envVars, origins, &out); err == nil {
+		t.Errorf("get of unset variable must fail")
+	}
+
+	for _, args := range [][]string{{}, {"bad"}, {"get"}, {"set", "XDS_TEST_C"}, {"get", "UNKNOWN"}} {
+		if _, err := runConfigCommand(args, envVars, origins, &out); err == nil {
+			t.Errorf("Invalid command %v must fail", args)
+		}
+	}
+}
+
+func TestReadConfigEnvExpand(t *testing.T) {
+	os.Setenv("XDS_TEST_HOME", "/home/me")
+	defer os.Unsetenv("XDS_TEST_HOME")
+	os.Unsetenv("XDS_TEST_UNSET")
+	cwd, _ := os.Getwd()
+
+	lines := []configLine{}
+	for i, l := range []string{
+		"XDS_TEST_ROOT=${XDS_TEST_HOME}/prj",
+		"XDS_TEST_A=$XDS_TEST_ROOT/build # comment",
+		`XDS_TEST_B="${XDS_TEST_UNSET:-${XDS_TEST_ROOT}/def}\t\$HOME \"q\""`,
+		"XDS_TEST_C='${XDS_TEST_ROOT}'",
+		"XDS_TEST_D=${CWD}",
+		`XDS_TEST_E=a\$b-${XDS_TEST_UNSET}-${XDS_TEST_ROOT:-x}`,
+	} {
+		lines = append(lines, configLine{Text: l, Num: i + 1})
+	}
+	entries, err := readConfigEnv("test.env", lines)
+	if err != nil {
+		t.Fatalf("readConfigEnv failed: %v", err)
+	}
+	exp := []string{"/home/me/prj", "/home/me/prj/build", `/home/me/prj/deft$HOME "q"`,
+		"${XDS_TEST_ROOT}", cwd, "a$b--/home/me/prj"}
+	for i, e := range entries {
+		if e.Value != exp[i] || e.Origin.String() != fmt.Sprintf("test.env:%d", i+1) {
+			t.Errorf("Invalid entry %d: %v (expected %q)", i, e, exp[i])
+		}
+	}
+
+	for _, l := range []string{"XDS_A=${XDS_B", "XDS_A=${1X}", `XDS_A="abc`, "XDS_A='a' b", "not a variable"} {
+		if _, err := readConfigEnv("bad.env", []configLine{{Text: l, Num: 3}}); err == nil || !strings.HasPrefix(err.Error(), "bad.env:3: ") {
+			t.Errorf("Invalid line %q must be rejected with location: %v", l, err)
+		}
+	}
+}
+
+func TestReadConfigEnvInclude(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "xds-gdb-test")
+	defer os.RemoveAll(dir)
+	os.MkdirAll(path.Join(dir, "common"), 0755)
+	writeTestFile(t, dir, "common/base.env", "XDS_TEST_ROOT=/base\nXDS_TEST_SDK=sdk-base\n")
+	cmdFile := writeTestFile(t, dir, "gdb.ini", "# :XDS-ENV: include common/base.env\n# :XDS-ENV: XDS_TEST_SDK=${XDS_TEST_ROOT}/sdk\nbreak main\n")
+	defer os.Unsetenv("XDS_TEST_ROOT")
+	defer os.Unsetenv("XDS_TEST_SDK")
+
+	envMap, _, origins, err := loadConfigEnvFile("", cmdFile)
+	if err != nil {
+		t.Fatalf("loadConfigEnvFile failed: %v", err)
+	}
+	if envMap["XDS_TEST_SDK"] != "/base/sdk" || os.Getenv("XDS_TEST_SDK") != "/base/sdk" {
+		t.Errorf("Invalid value: %v", envMap)
+	}
+	if o := origins["XDS_TEST_ROOT"].String(); o != path.Join(dir, "common/base.env")+":1" {
+		t.Errorf("Invalid origin of included variable: %s", o)
+	}
+
+	// include cycle
+	writeTestFile(t, dir, "a.env", "XDS_TEST_A=1\ninclude ${XDS_TEST_INC:-b.env}\n")
+	writeTestFile(t, dir, "b.env", "\ninclude a.env\n")
+	lines, _ := readConfigLines(path.Join(dir, "a.env"))
+	_, err = readConfigEnv(path.Join(dir, "a.env"), lines)
+	if err == nil || !strings.Contains(err.Error(), "a.env:2: "+path.Join(dir, "b.env")+":2: Include cycle detected") {
+		t.Errorf("Include cycle not detected: %v", err)
+	}
+
+	// missing include file
+	writeTestFile(t, dir, "c.env", "include missing.env\n")
+	lines, _ = readConfigLines(path.Join(dir, "c.env"))
+	if _, err = readConfigEnv(path.Join(dir, "c.env"), lines); err == nil || !strings.Contains(err.Error(), "c.env:1: Cannot include") {
+		t.Errorf("Missing include file not reported: %v", err)
+	}
+}
+
+func TestLoadConfigEnvFileLayers(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "xds-gdb-test")
+	defer os.RemoveAll(dir)
+	root := path.Join(dir, "repo")
+	for _, d := range []string{"etc", "home/xds", "repo/.git", "repo/build"} {
+		os.MkdirAll(path.Join(dir, d), 0755)
+	}
+	sysFile := writeTestFile(t, dir, "etc/xds-gdb.env", "XDS_TEST_AGENT=sys-agent\nXDS_TEST_SYS=1\n")
+	writeTestFile(t, dir, "home/xds/xds-gdb.env", "XDS_TEST_AGENT=user-agent\nXDS_TEST_PRJ=user-prj\n")
+	writeTestFile(t, dir, "xds-gdb.env", "XDS_TEST_OUTSIDE=1\n")
+	repoFile := writeTestFile(t, root, "xds-gdb.env", "XDS_TEST_PRJ=repo-prj\nXDS_TEST_URL=${XDS_TEST_AGENT}/api\nXDS_TEST_ROOT=${XDS_PROJECT_ROOT:-none}\n")
+	writeTestFile(t, root, "build/xds-gdb.env", "XDS_TEST_RPATH=build\n")
+	confFile := writeTestFile(t, dir, "my.env", "XDS_TEST_RPATH=my\n")
+	cmdFile := writeTestFile(t, dir, "gdb.ini", "# :XDS-ENV: XDS_TEST_PRJ=cmd-prj\n")
+
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(path.Join(root, "build"))
+	defer func(d string) { configSystemDir = d }(configSystemDir)
+	configSystemDir = path.Join(dir, "etc")
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	os.Setenv("XDG_CONFIG_HOME", path.Join(dir, "home"))
+	names := []string{"XDS_TEST_AGENT", "XDS_TEST_SYS", "XDS_TEST_PRJ", "XDS_TEST_URL", "XDS_TEST_RPATH", "XDS_TEST_OUTSIDE", "XDS_TEST_ROOT"}
+	unset := func() {
+		for _, n := range names {
+			os.Unsetenv(n)
+		}
+	}
+	unset()
+	defer unset()
+
+	// discovered files only
+	envMap, file, origins, err := loadConfigEnvFile("", "")
+	if err != nil {
+		t.Fatalf("loadConfigEnvFile failed: %v", err)
+	}
+	if file != path.Join(root, "build/xds-gdb.env") {
+		t.Errorf("Invalid config file of highest precedence: %s", file)
+	}
+	for n, exp := range map[string]string{
+		"XDS_TEST_AGENT":   "user-agent",
+		"XDS_TEST_SYS":     "1",
+		"XDS_TEST_PRJ":     "repo-prj",
+		"XDS_TEST_URL":     "user-agent/api",
+		"XDS_TEST_RPATH":   "build",
+		"XDS_TEST_OUTSIDE": "",
+		"XDS_TEST_ROOT":    root,
+	} {
+		if envMap[n] != exp {
+			t.Errorf("Invalid value of %s: %q, expected %q", n, envMap[n], exp)
+		}
+	}
+	if o := origins["XDS_TEST_SYS"].String(); o != sysFile+":2" {
+		t.Errorf("Invalid origin of XDS_TEST_SYS: %s", o)
+	}
+	if o := origins["XDS_TEST_PRJ"].String(); o != repoFile+":1" {
+		t.Errorf("Invalid origin of XDS_TEST_PRJ: %s", o)
+	}
+
+	// XDS_CONFIG and :XDS-ENV: tags are on top
+	unset()
+	envMap, file, origins, err = loadConfigEnvFile(confFile, cmdFile)
+	if err != nil {
+		t.Fatalf("loadConfigEnvFile failed: %v", err)
+	}
+	if file != cmdFile || envMap["XDS_TEST_RPATH"] != "my" || envMap["XDS_TEST_PRJ"] != "cmd-prj" || envMap["XDS_TEST_AGENT"] != "user-agent" {
+		t.Errorf("Invalid layered config: file=%s env=%v", file, envMap)
+	}
+	if o := origins["XDS_TEST_PRJ"].String(); o != cmdFile+":1" {
+		t.Errorf("Invalid origin of XDS_TEST_PRJ: %s", o)
+	}
+
+	// environment wins
+	os.Setenv("XDS_TEST_PRJ", "env-prj")
+	if _, _, origins, _ = loadConfigEnvFile(confFile, cmdFile); origins["XDS_TEST_PRJ"].String() != "env (overrides "+cmdFile+":1)" {
+		t.Errorf("Invalid origin of XDS_TEST_PRJ: %s", origins["XDS_TEST_PRJ"].String())
+	}
+
+	if _, _, _, err = loadConfigEnvFile(path.Join(dir, "missing.env"), ""); err == nil {
+		t.Errorf("Missing XDS_CONFIG file not reported")
+	}
+}
+
+func TestLoadConfigEnvFileUntrusted(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "xds-gdb-test")
+	defer os.RemoveAll(dir)
+	home := path.Join(dir, "home/me")
+	os.MkdirAll(path.Join(home, "prj/src"), 0755)
+	writeTestFile(t, dir, "home/xds-gdb.env", "XDS_TEST_ABOVE=1\n")
+	writeTestFile(t, home, "xds-gdb.env", "XDS_TEST_HOME=1\n")
+	writeTestFile(t, home, "prj/xds-gdb.env", "XDS_TEST_PRJ=1\ninclude ../inc.env\n")
+	os.Chmod(writeTestFile(t, home, "prj/src/xds-gdb.env", "XDS_TEST_SRC=1\n"), 0666)
+	os.Chmod(writeTestFile(t, home, "inc.env", "XDS_TEST_INC=1\n"), 0666)
+
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(path.Join(home, "prj/src"))
+	defer func(d string) { configSystemDir = d }(configSystemDir)
+	configSystemDir = path.Join(dir, "etc")
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	os.Setenv("XDG_CONFIG_HOME", path.Join(dir, "config"))
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+	names := []string{"XDS_TEST_ABOVE", "XDS_TEST_HOME", "XDS_TEST_PRJ", "XDS_TEST_SRC", "XDS_TEST_INC"}
+	for _, n := range names {
+		os.Unsetenv(n)
+	}
+	defer func() {
+		for _, n := range names {
+			os.Unsetenv(n)
+		}
+	}()
+
+	// without .git, search stops at home directory
+	envMap, _, _, err := loadConfigEnvFile("", "")
+	if err != nil {
+		t.Fatalf("loadConfigEnvFile failed: %v", err)
+	}
+	for n, exp := range map[string]string{
+		"XDS_TEST_ABOVE": "",
+		"XDS_TEST_HOME":  "1",
+		"XDS_TEST_PRJ":   "1",
+		"XDS_TEST_SRC":   "",
+		"XDS_TEST_INC":   "",
+	} {
+		if envMap[n] != exp {
+			t.Errorf("Invalid value of %s: %q, expected %q", n, envMap[n], exp)
+		}
+	}
+
+	if err := checkConfigFileOwner(path.Join(home, "prj/src/xds-gdb.env")); err == nil || !strings.Contains(err.Error(), "permissions 0666 are too open") {
+		t.Errorf("File writable by others not rejected: %v", err)
+	}
+	if err := checkConfigFileOwner(path.Join(home, "xds-gdb.env")); err != nil {
+		t.Errorf("User file rejected: %v", err)
+	}
+
+	// included file is read once trusted
+	os.Chmod(path.Join(home, "inc.env"), 0644)
+	if envMap, _, _, err = loadConfigEnvFile("", ""); err != nil || envMap["XDS_TEST_INC"] != "1" {
+		t.Errorf("Trusted included file not read: %v %v", envMap, err)
+	}
+}
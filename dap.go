@@ -0,0 +1,833 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Timeout of a MI command sent on behalf of a DAP request
+var dapCmdTimeout = 30 * time.Second
+
+// DapServer - Debug Adapter Protocol server that drives gdb through MI commands
+type DapServer struct {
+	log      *logrus.Logger
+	gdb      IGDB
+	rewriter *MIRewriter
+	in       *bufio.Reader
+	out      io.Writer
+
+	outMutex sync.Mutex
+	seq      int
+
+	miMutex   sync.Mutex
+	miToken   int
+	miPending map[string]chan *MIRecord
+
+	started     bool
+	isLaunch    bool
+	stopOnEntry bool
+	breakpoints map[string][]string // source path -> gdb breakpoint numbers
+	funcBkpts   []string
+	frames      map[int]dapFrameRef
+	vars        map[int]dapVarRef
+	varObjs     []string
+	nextRef     int
+
+	exitChan chan exitResult
+}
+
+// dapMessage - DAP base protocol message (request, response or event)
+type dapMessage struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	Command    string          `json:"command,omitempty"`
+	Arguments  json.RawMessage `json:"arguments,omitempty"`
+	RequestSeq int             `json:"request_seq,omitempty"`
+	Success    *bool           `json:"success,omitempty"`
+	Message    string          `json:"message,omitempty"`
+	Event      string          `json:"event,omitempty"`
+	Body       interface{}     `json:"body,omitempty"`
+}
+
+type dapFrameRef struct {
+	thread string
+	level  string
+}
+
+type dapVarRef struct {
+	frame  dapFrameRef
+	varObj string // empty for a scope
+}
+
+type dapSource struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type dapLaunchArgs struct {
+	Program       string   `json:"program"`
+	Args          []string `json:"args"`
+	Cwd           string   `json:"cwd"`
+	StopOnEntry   bool     `json:"stopOnEntry"`
+	SetupCommands []string `json:"setupCommands"`
+	Pid           int      `json:"pid"`    // attach only
+	Target        string   `json:"target"` // attach only (eg. remote localhost:2345)
+}
+
+// NewDapServer creates a new instance of DapServer
+func NewDapServer(log *logrus.Logger, gdb IGDB, rewriter *MIRewriter, in io.Reader, out io.Writer) *DapServer {
+	return &DapServer{
+		log:         log,
+		gdb:         gdb,
+		rewriter:    rewriter,
+		in:          bufio.NewReader(in),
+		out:         out,
+		miPending:   make(map[string]chan *MIRecord),
+		breakpoints: make(map[string][]string),
+		frames:      make(map[int]dapFrameRef),
+		vars:        make(map[int]dapVarRef),
+		exitChan:    make(chan exitResult, 1),
+	}
+}
+
+// Serve processes DAP requests until disconnection or gdb exit
+func (d *DapServer) Serve() (int, error) {
+	go func() {
+		for {
+			req, err := d.readMessage()
+			if err != nil {
+				if err != io.EOF {
+					d.log.Errorf("DAP: read error: %v", err)
+				}
+				d.exit(0, nil)
+				return
+			}
+			if req.Type != "request" {
+				continue
+			}
+			if !d.handleRequest(req) {
+				return
+			}
+		}
+	}()
+
+	res := <-d.exitChan
+	return res.code, res.error
+}
+
+//***** Private functions *****
+
+func (d *DapServer) exit(code int, err error) {
+	select {
+	case d.exitChan <- exitResult{err, code}:
+	default:
+	}
+}
+
+func (d *DapServer) readMessage() (*dapMessage, error) {
+	length := -1
+	for {
+		ln, err := d.in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		ln = strings.TrimSpace(ln)
+		if ln == "" {
+			if length >= 0 {
+				break
+			}
+			continue
+		}
+		if strings.HasPrefix(ln, "Content-Length:") {
+			if length, err = strconv.Atoi(strings.TrimSpace(ln[len("Content-Length:"):])); err != nil {
+				return nil, fmt.Errorf("invalid header '%s'", ln)
+			}
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(d.in, body); err != nil {
+		return nil, err
+	}
+	d.log.Debugf("DAP: recv <%s>", string(body))
+	msg := &dapMessage{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (d *DapServer) send(msg *dapMessage) {
+	d.outMutex.Lock()
+	defer d.outMutex.Unlock()
+	d.seq++
+	msg.Seq = d.seq
+	body, err := json.Marshal(msg)
+	if err != nil {
+		d.log.Errorf("DAP: cannot encode message: %v", err)
+		return
+	}
+	d.log.Debugf("DAP: send <%s>", string(body))
+	fmt.Fprintf(d.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (d *DapServer) sendEvent(event string, body interface{}) {
+	d.send(&dapMessage{Type: "event", Event: event, Body: body})
+}
+
+func (d *DapServer) respond(req *dapMessage, body interface{}, err error) {
+	success := err == nil
+	res := &dapMessage{
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Command:    req.Command,
+		Success:    &success,
+		Body:       body,
+	}
+	if err != nil {
+		res.Message = err.Error()
+	}
+	d.send(res)
+}
+
+// miCmd sends a MI command to gdb and waits for its result record
+func (d *DapServer) miCmd(format string, a ...interface{}) (*MIRecord, error) {
+	d.miMutex.Lock()
+	d.miToken++
+	token := strconv.Itoa(d.miToken)
+	ch := make(chan *MIRecord, 1)
+	d.miPending[token] = ch
+	d.miMutex.Unlock()
+
+	defer func() {
+		d.miMutex.Lock()
+		delete(d.miPending, token)
+		d.miMutex.Unlock()
+	}()
+
+	cmd := ParseMICommand(token + fmt.Sprintf(format, a...))
+	if d.rewriter != nil && !d.rewriter.Rewrite(cmd) {
+		return &MIRecord{Type: MIRecordResult, Token: token, Class: "done"}, nil
+	}
+	d.log.Debugf("DAP: send MI <%s>", cmd.String())
+	if err := d.gdb.Write(cmd.String() + "\n"); err != nil {
+		return nil, err
+	}
+
+	select {
+	case rec := <-ch:
+		if rec.Class == "error" {
+			return rec, fmt.Errorf("%s", rec.GetString("msg"))
+		}
+		return rec, nil
+	case <-time.After(dapCmdTimeout):
+		return nil, fmt.Errorf("timeout while waiting result of %s", cmd.String())
+	}
+}
+
+// onMIRecord dispatches records received from gdb
+func (d *DapServer) onMIRecord(rec *MIRecord) {
+	switch rec.Type {
+	case MIRecordResult:
+		d.miMutex.Lock()
+		ch, exist := d.miPending[rec.Token]
+		d.miMutex.Unlock()
+		if exist {
+			ch <- rec
+		}
+
+	case MIRecordConsole:
+		d.sendEvent("output", map[string]interface{}{"category": "console", "output": rec.Stream})
+
+	case MIRecordTarget:
+		d.sendEvent("output", map[string]interface{}{"category": "stdout", "output": rec.Stream})
+
+	case MIRecordExec:
+		switch rec.Class {
+		case "running":
+			d.sendEvent("continued", map[string]interface{}{
+				"threadId":            dapID(rec.GetString("thread-id")),
+				"allThreadsContinued": rec.GetString("thread-id") == "all",
+			})
+		case "stopped":
+			d.onStopped(rec)
+		}
+
+	case MIRecordNotify:
+		switch rec.Class {
+		case "thread-created":
+			d.sendEvent("thread", map[string]interface{}{"reason": "started", "threadId": dapID(rec.GetString("id"))})
+		case "thread-exited":
+			d.sendEvent("thread", map[string]interface{}{"reason": "exited", "threadId": dapID(rec.GetString("id"))})
+		}
+	}
+}
+
+func (d *DapServer) onStopped(rec *MIRecord) {
+	reason := rec.GetString("reason")
+	switch reason {
+	case "exited-normally", "exited", "exited-signalled":
+		code, _ := strconv.ParseInt(rec.GetString("exit-code"), 8, 32)
+		d.sendEvent("exited", map[string]interface{}{"exitCode": code})
+		d.sendEvent("terminated", nil)
+		return
+	}
+
+	dapReason := "pause"
+	switch reason {
+	case "breakpoint-hit":
+		dapReason = "breakpoint"
+		if rec.GetString("disp") == "del" && d.stopOnEntry {
+			dapReason = "entry"
+		}
+	case "end-stepping-range", "function-finished", "location-reached":
+		dapReason = "step"
+	case "watchpoint-trigger", "read-watchpoint-trigger", "access-watchpoint-trigger":
+		dapReason = "data breakpoint"
+	case "signal-received":
+		if rec.GetString("signal-name") != "SIGINT" {
+			dapReason = "exception"
+		}
+	}
+	d.sendEvent("stopped", map[string]interface{}{
+		"reason":            dapReason,
+		"description":       reason,
+		"threadId":          dapID(rec.GetString("thread-id")),
+		"allThreadsStopped": rec.GetString("stopped-threads") == "all",
+	})
+}
+
+func (d *DapServer) resetRefs() {
+	for _, name := range d.varObjs {
+		if _, err := d.miCmd("-var-delete %s", miQuote(name)); err != nil {
+			d.log.Debugf("DAP: cannot delete varobj %s: %v", name, err)
+		}
+	}
+	d.varObjs = []string{}
+	d.frames = make(map[int]dapFrameRef)
+	d.vars = make(map[int]dapVarRef)
+}
+
+func (d *DapServer) newRef() int {
+	d.nextRef++
+	return d.nextRef
+}
+
+// handleRequest processes a DAP request, returns false to stop reading requests
+func (d *DapServer) handleRequest(req *dapMessage) bool {
+	var body interface{}
+	var err error
+
+	switch req.Command {
+	case "initialize":
+		body = map[string]interface{}{
+			"supportsConfigurationDoneRequest":  true,
+			"supportsFunctionBreakpoints":       true,
+			"supportsConditionalBreakpoints":    true,
+			"supportsEvaluateForHovers":         true,
+			"supportsTerminateRequest":          true,
+			"supportTerminateDebuggee":          true,
+			"supportsSetVariable":               false,
+			"supportsRestartRequest":            false,
+			"supportsExceptionInfoRequest":      false,
+			"supportsConditionalDataBreakpoint": false,
+		}
+
+	case "launch", "attach":
+		args := dapLaunchArgs{}
+		if len(req.Arguments) > 0 {
+			err = json.Unmarshal(req.Arguments, &args)
+		}
+		if err == nil {
+			err = d.startGdb(req.Command == "launch", &args)
+		}
+		if err == nil {
+			d.respond(req, nil, nil)
+			d.sendEvent("initialized", nil)
+			return true
+		}
+
+	case "configurationDone":
+		if d.isLaunch {
+			if d.stopOnEntry {
+				_, err = d.miCmd("-break-insert -t main")
+			}
+			if err == nil {
+				_, err = d.miCmd("-exec-run")
+			}
+		}
+
+	case "setBreakpoints":
+		body, err = d.setBreakpoints(req.Arguments)
+
+	case "setFunctionBreakpoints":
+		body, err = d.setFunctionBreakpoints(req.Arguments)
+
+	case "setExceptionBreakpoints":
+		body = map[string]interface{}{"breakpoints": []interface{}{}}
+
+	case "threads":
+		body, err = d.threads()
+
+	case "stackTrace":
+		body, err = d.stackTrace(req.Arguments)
+
+	case "scopes":
+		body, err = d.scopes(req.Arguments)
+
+	case "variables":
+		body, err = d.variables(req.Arguments)
+
+	case "evaluate":
+		body, err = d.evaluate(req.Arguments)
+
+	case "continue", "next", "stepIn", "stepOut":
+		args := struct {
+			ThreadID int `json:"threadId"`
+		}{}
+		json.Unmarshal(req.Arguments, &args)
+		d.resetRefs()
+		op := map[string]string{
+			"continue": "-exec-continue",
+			"next":     "-exec-next",
+			"stepIn":   "-exec-step",
+			"stepOut":  "-exec-finish",
+		}[req.Command]
+		if req.Command == "continue" {
+			_, err = d.miCmd("%s --all", op)
+			body = map[string]interface{}{"allThreadsContinued": true}
+		} else {
+			_, err = d.miCmd("%s --thread %d", op, args.ThreadID)
+		}
+
+	case "pause":
+		// Use signal as done for -gdb-exit, -exec-interrupt is not reliable with gdbserver
+		err = d.gdb.SendSignal(syscall.SIGINT)
+
+	case "disconnect", "terminate":
+		if d.started {
+			if err := d.gdb.SendSignal(syscall.SIGINT); err != nil {
+				d.log.Debugf("DAP: error while sending signal SIGINT: %v", err)
+			}
+			time.Sleep(time.Millisecond * 200)
+			d.gdb.Write("-gdb-exit\n")
+		}
+		d.respond(req, nil, nil)
+		if req.Command == "disconnect" || !d.started {
+			d.exit(0, nil)
+			return false
+		}
+		return true
+
+	default:
+		err = fmt.Errorf("unsupported request '%s'", req.Command)
+	}
+
+	d.respond(req, body, err)
+	return true
+}
+
+func (d *DapServer) startGdb(isLaunch bool, args *dapLaunchArgs) error {
+	if d.started {
+		return fmt.Errorf("gdb already started")
+	}
+	d.isLaunch = isLaunch
+	d.stopOnEntry = args.StopOnEntry
+
+	if code, err := d.gdb.Init(); err != nil {
+		return fmt.Errorf("%s (code %d)", err.Error(), code)
+	}
+
+	d.gdb.OnError(func(err error) {
+		d.sendEvent("output", map[string]interface{}{"category": "stderr", "output": "ERROR: " + err.Error() + "\n"})
+	})
+	d.gdb.OnDisconnect(func(err error) {
+		msg := "XDS-Agent disconnected\n"
+		if err != nil {
+			msg = fmt.Sprintf("XDS-Agent disconnected: %v\n", err)
+		}
+		d.sendEvent("output", map[string]interface{}{"category": "stderr", "output": msg})
+		d.sendEvent("terminated", nil)
+		d.exit(int(syscall.ESHUTDOWN), err)
+	})
+	d.gdb.OnExit(func(code int, err error) {
+		d.sendEvent("terminated", nil)
+		d.exit(code, err)
+	})
+	d.gdb.Read(func(timestamp, stdout, stderr string) {
+		if stderr != "" {
+			d.log.Debugf("DAP: recv ERR <%s>", stderr)
+			d.sendEvent("output", map[string]interface{}{"category": "stderr", "output": stderr})
+		}
+	})
+	d.gdb.InferiorRead(func(timestamp, stdout, stderr string) {
+		if stdout != "" {
+			d.sendEvent("output", map[string]interface{}{"category": "stdout", "output": stdout})
+		}
+		if stderr != "" {
+			d.sendEvent("output", map[string]interface{}{"category": "stderr", "output": stderr})
+		}
+	})
+	d.gdb.OnMIRecord(d.onMIRecord)
+
+	if code, err := d.gdb.Start(false); err != nil {
+		return fmt.Errorf("%s (code %d)", err.Error(), code)
+	}
+	d.started = true
+
+	if args.Cwd != "" {
+		if _, err := d.miCmd("-environment-cd %s", miQuote(args.Cwd)); err != nil {
+			return err
+		}
+	}
+	if args.Program != "" {
+		if _, err := d.miCmd("-file-exec-and-symbols %s", miQuote(args.Program)); err != nil {
+			return err
+		}
+	}
+	if len(args.Args) > 0 {
+		quoted := make([]string, len(args.Args))
+		for i, a := range args.Args {
+			quoted[i] = miQuote(a)
+		}
+		if _, err := d.miCmd("-exec-arguments %s", strings.Join(quoted, " ")); err != nil {
+			return err
+		}
+	}
+	for _, c := range args.SetupCommands {
+		var err error
+		if strings.HasPrefix(c, "-") {
+			_, err = d.miCmd("%s", c)
+		} else {
+			_, err = d.miCmd("-interpreter-exec console %s", miQuote(c))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", c, err)
+		}
+	}
+
+	if !isLaunch {
+		if args.Target != "" {
+			if _, err := d.miCmd("-target-select %s", args.Target); err != nil {
+				return err
+			}
+		} else if args.Pid != 0 {
+			if _, err := d.miCmd("-target-attach %d", args.Pid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *DapServer) insertBreakpoint(location, condition string) map[string]interface{} {
+	cond := ""
+	if condition != "" {
+		cond = "-c " + miQuote(condition) + " "
+	}
+	rec, err := d.miCmd("-break-insert -f %s%s", cond, miQuote(location))
+	if err != nil {
+		return map[string]interface{}{"verified": false, "message": err.Error()}
+	}
+	bkpt, _ := rec.Get("bkpt").(MITuple)
+	res := map[string]interface{}{
+		"id":       dapID(bkpt.GetString("number")),
+		"verified": bkpt.GetString("addr") != "<PENDING>",
+	}
+	if line, err := strconv.Atoi(bkpt.GetString("line")); err == nil {
+		res["line"] = line
+	}
+	if src := dapSourceFromFrame(bkpt); src != nil {
+		res["source"] = src
+	}
+	return res
+}
+
+func (d *DapServer) deleteBreakpoints(numbers []string) {
+	if len(numbers) == 0 {
+		return
+	}
+	if _, err := d.miCmd("-break-delete %s", strings.Join(numbers, " ")); err != nil {
+		d.log.Debugf("DAP: cannot delete breakpoints %v: %v", numbers, err)
+	}
+}
+
+func (d *DapServer) setBreakpoints(rawArgs json.RawMessage) (interface{}, error) {
+	args := struct {
+		Source      dapSource `json:"source"`
+		Breakpoints []struct {
+			Line      int    `json:"line"`
+			Condition string `json:"condition"`
+		} `json:"breakpoints"`
+	}{}
+	if err := json.Unmarshal(rawArgs, &args); err != nil {
+		return nil, err
+	}
+	d.deleteBreakpoints(d.breakpoints[args.Source.Path])
+	d.breakpoints[args.Source.Path] = []string{}
+
+	bkpts := []interface{}{}
+	for _, b := range args.Breakpoints {
+		res := d.insertBreakpoint(fmt.Sprintf("%s:%d", args.Source.Path, b.Line), b.Condition)
+		if id, exist := res["id"]; exist {
+			d.breakpoints[args.Source.Path] = append(d.breakpoints[args.Source.Path], strconv.Itoa(id.(int)))
+		}
+		bkpts = append(bkpts, res)
+	}
+	return map[string]interface{}{"breakpoints": bkpts}, nil
+}
+
+func (d *DapServer) setFunctionBreakpoints(rawArgs json.RawMessage) (interface{}, error) {
+	args := struct {
+		Breakpoints []struct {
+			Name      string `json:"name"`
+			Condition string `json:"condition"`
+		} `json:"breakpoints"`
+	}{}
+	if err := json.Unmarshal(rawArgs, &args); err != nil {
+		return nil, err
+	}
+	d.deleteBreakpoints(d.funcBkpts)
+	d.funcBkpts = []string{}
+
+	bkpts := []interface{}{}
+	for _, b := range args.Breakpoints {
+		res := d.insertBreakpoint(b.Name, b.Condition)
+		if id, exist := res["id"]; exist {
+			d.funcBkpts = append(d.funcBkpts, strconv.Itoa(id.(int)))
+		}
+		bkpts = append(bkpts, res)
+	}
+	return map[string]interface{}{"breakpoints": bkpts}, nil
+}
+
+func (d *DapServer) threads() (interface{}, error) {
+	rec, err := d.miCmd("-thread-info")
+	if err != nil {
+		return nil, err
+	}
+	threads := []interface{}{}
+	list, _ := rec.Get("threads").(MIList)
+	for _, t := range list {
+		th, _ := t.(MITuple)
+		name := th.GetString("name")
+		if name == "" {
+			name = th.GetString("target-id")
+		}
+		threads = append(threads, map[string]interface{}{
+			"id":   dapID(th.GetString("id")),
+			"name": name,
+		})
+	}
+	return map[string]interface{}{"threads": threads}, nil
+}
+
+func (d *DapServer) stackTrace(rawArgs json.RawMessage) (interface{}, error) {
+	args := struct {
+		ThreadID   int `json:"threadId"`
+		StartFrame int `json:"startFrame"`
+		Levels     int `json:"levels"`
+	}{}
+	if err := json.Unmarshal(rawArgs, &args); err != nil {
+		return nil, err
+	}
+	rng := ""
+	if args.Levels > 0 {
+		rng = fmt.Sprintf(" %d %d", args.StartFrame, args.StartFrame+args.Levels-1)
+	}
+	rec, err := d.miCmd("-stack-list-frames --thread %d%s", args.ThreadID, rng)
+	if err != nil {
+		return nil, err
+	}
+	frames := []interface{}{}
+	list, _ := rec.Get("stack").(MIList)
+	for _, f := range list {
+		res, _ := f.(MIResult)
+		fr, _ := res.Value.(MITuple)
+		id := d.newRef()
+		d.frames[id] = dapFrameRef{thread: strconv.Itoa(args.ThreadID), level: fr.GetString("level")}
+		name := fr.GetString("func")
+		if name == "" {
+			name = fr.GetString("addr")
+		}
+		line, _ := strconv.Atoi(fr.GetString("line"))
+		frame := map[string]interface{}{
+			"id":     id,
+			"name":   name,
+			"line":   line,
+			"column": 0,
+		}
+		if src := dapSourceFromFrame(fr); src != nil {
+			frame["source"] = src
+		}
+		frames = append(frames, frame)
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+func (d *DapServer) scopes(rawArgs json.RawMessage) (interface{}, error) {
+	args := struct {
+		FrameID int `json:"frameId"`
+	}{}
+	if err := json.Unmarshal(rawArgs, &args); err != nil {
+		return nil, err
+	}
+	frame, exist := d.frames[args.FrameID]
+	if !exist {
+		return nil, fmt.Errorf("unknown frame %d", args.FrameID)
+	}
+	ref := d.newRef()
+	d.vars[ref] = dapVarRef{frame: frame}
+	return map[string]interface{}{
+		"scopes": []interface{}{
+			map[string]interface{}{"name": "Locals", "variablesReference": ref, "expensive": false},
+		},
+	}, nil
+}
+
+func (d *DapServer) variables(rawArgs json.RawMessage) (interface{}, error) {
+	args := struct {
+		VariablesReference int `json:"variablesReference"`
+	}{}
+	if err := json.Unmarshal(rawArgs, &args); err != nil {
+		return nil, err
+	}
+	ref, exist := d.vars[args.VariablesReference]
+	if !exist {
+		return nil, fmt.Errorf("unknown variables reference %d", args.VariablesReference)
+	}
+
+	vars := []interface{}{}
+	if ref.varObj == "" {
+		// Scope: create a varobj for each local variable and argument
+		rec, err := d.miCmd("-stack-list-variables --thread %s --frame %s --no-values", ref.frame.thread, ref.frame.level)
+		if err != nil {
+			return nil, err
+		}
+		list, _ := rec.Get("variables").(MIList)
+		for _, v := range list {
+			vt, _ := v.(MITuple)
+			name := vt.GetString("name")
+			vr, err := d.miCmd("-var-create --thread %s --frame %s - * %s", ref.frame.thread, ref.frame.level, miQuote(name))
+			if err != nil {
+				vars = append(vars, map[string]interface{}{"name": name, "value": err.Error(), "variablesReference": 0})
+				continue
+			}
+			d.varObjs = append(d.varObjs, vr.GetString("name"))
+			vars = append(vars, d.dapVariable(name, vr.Results, ref.frame))
+		}
+	} else {
+		rec, err := d.miCmd("-var-list-children --all-values %s", miQuote(ref.varObj))
+		if err != nil {
+			return nil, err
+		}
+		list, _ := rec.Get("children").(MIList)
+		for _, c := range list {
+			res, _ := c.(MIResult)
+			ct, _ := res.Value.(MITuple)
+			vars = append(vars, d.dapVariable(ct.GetString("exp"), ct, ref.frame))
+		}
+	}
+	return map[string]interface{}{"variables": vars}, nil
+}
+
+func (d *DapServer) dapVariable(name string, vo MITuple, frame dapFrameRef) map[string]interface{} {
+	ref := 0
+	if nb, _ := strconv.Atoi(vo.GetString("numchild")); nb > 0 {
+		ref = d.newRef()
+		d.vars[ref] = dapVarRef{frame: frame, varObj: vo.GetString("name")}
+	}
+	return map[string]interface{}{
+		"name":               name,
+		"value":              vo.GetString("value"),
+		"type":               vo.GetString("type"),
+		"variablesReference": ref,
+	}
+}
+
+func (d *DapServer) evaluate(rawArgs json.RawMessage) (interface{}, error) {
+	args := struct {
+		Expression string `json:"expression"`
+		FrameID    int    `json:"frameId"`
+		Context    string `json:"context"`
+	}{}
+	if err := json.Unmarshal(rawArgs, &args); err != nil {
+		return nil, err
+	}
+
+	// Debug console: execute gdb command (output sent as console output events)
+	if args.Context == "repl" {
+		var err error
+		if strings.HasPrefix(args.Expression, "-") {
+			_, err = d.miCmd("%s", args.Expression)
+		} else {
+			_, err = d.miCmd("-interpreter-exec console %s", miQuote(args.Expression))
+		}
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"result": "", "variablesReference": 0}, nil
+	}
+
+	frameOpt := ""
+	if frame, exist := d.frames[args.FrameID]; exist {
+		frameOpt = fmt.Sprintf("--thread %s --frame %s ", frame.thread, frame.level)
+	}
+	rec, err := d.miCmd("-data-evaluate-expression %s%s", frameOpt, miQuote(args.Expression))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"result": rec.GetString("value"), "variablesReference": 0}, nil
+}
+
+// dapID converts a gdb id (thread or breakpoint number) into a DAP id
+func dapID(id string) int {
+	n, _ := strconv.Atoi(id)
+	return n
+}
+
+// dapSourceFromFrame returns DAP source of a frame or breakpoint tuple
+func dapSourceFromFrame(t MITuple) *dapSource {
+	p := t.GetString("fullname")
+	if p == "" {
+		p = t.GetString("file")
+	}
+	if p == "" {
+		return nil
+	}
+	return &dapSource{Name: filepath.Base(p), Path: p}
+}
+
+// runDapServer starts DAP server on stdin/stdout
+func runDapServer(log *logrus.Logger, gdb IGDB, rewriter *MIRewriter) (int, error) {
+	log.Infof("Start Debug Adapter Protocol server")
+	return NewDapServer(log, gdb, rewriter, os.Stdin, os.Stdout).Serve()
+}
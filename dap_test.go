@@ -0,0 +1,459 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// stubGdb - in-process IGDB answering MI commands with canned records
+type stubGdb struct {
+	mutex    sync.Mutex
+	replies  map[string][]string // MI operation -> queued replies ("" to never answer)
+	writes   []string
+	signals  []os.Signal
+	started  bool
+	onRecord func(rec *MIRecord)
+}
+
+func newStubGdb() *stubGdb {
+	return &stubGdb{replies: make(map[string][]string)}
+}
+
+// reply queues the records sent back for the next command of operation op,
+// result records get the token of the command
+func (s *stubGdb) reply(op string, records ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.replies[op] = append(s.replies[op], strings.Join(records, "\n"))
+}
+
+// commands returns the MI commands written without their token
+func (s *stubGdb) commands() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.writes...)
+}
+
+func (s *stubGdb) Init() (int, error)                                    { return 0, nil }
+func (s *stubGdb) Close() error                                          { return nil }
+func (s *stubGdb) SetConfig(name string, value interface{}) error        { return nil }
+func (s *stubGdb) Cmd() string                                           { return "gdb" }
+func (s *stubGdb) Args() []string                                        { return []string{} }
+func (s *stubGdb) Env() []string                                         { return []string{} }
+func (s *stubGdb) OnError(f func(error))                                 {}
+func (s *stubGdb) OnDisconnect(f func(error))                            {}
+func (s *stubGdb) OnExit(f func(int, error))                             {}
+func (s *stubGdb) Read(f func(timestamp, stdout, stderr string))         {}
+func (s *stubGdb) InferiorRead(f func(timestamp, stdout, stderr string)) {}
+func (s *stubGdb) InferiorWrite(args ...interface{}) error               { return nil }
+
+func (s *stubGdb) OnMIRecord(f func(rec *MIRecord)) {
+	s.onRecord = f
+}
+
+func (s *stubGdb) Start(inferiorTTY bool) (int, error) {
+	s.started = true
+	return 0, nil
+}
+
+func (s *stubGdb) SendSignal(sig os.Signal) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.signals = append(s.signals, sig)
+	return nil
+}
+
+func (s *stubGdb) Write(args ...interface{}) error {
+	cmd := ParseMICommand(strings.TrimSpace(fmt.Sprint(args...)))
+	s.mutex.Lock()
+	s.writes = append(s.writes, strings.TrimPrefix(cmd.String(), cmd.Token))
+	op := cmd.Operation
+	if !cmd.IsCLI {
+		op = "-" + op
+	}
+	reply := "^done"
+	if q := s.replies[op]; len(q) > 0 {
+		reply, s.replies[op] = q[0], q[1:]
+	}
+	s.mutex.Unlock()
+
+	if reply == "" {
+		return nil
+	}
+	for _, ln := range strings.Split(reply, "\n") {
+		if strings.HasPrefix(ln, "^") {
+			ln = cmd.Token + ln
+		}
+		s.onRecord(ParseMIRecord(ln))
+	}
+	return nil
+}
+
+// dapClient - DAP client side of a DapServer running in-process
+type dapClient struct {
+	t      *testing.T
+	in     io.WriteCloser
+	msgC   chan *dapMessage
+	seq    int
+	events []*dapMessage
+	exitC  chan exitResult
+}
+
+func newDapClient(t *testing.T, gdb IGDB) *dapClient {
+	tlog := logrus.New()
+	tlog.Out = ioutil.Discard
+	tlog.Level = logrus.DebugLevel
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &dapClient{t: t, in: inW, msgC: make(chan *dapMessage, 100), exitC: make(chan exitResult, 1)}
+	go func() {
+		code, err := NewDapServer(tlog, gdb, nil, inR, outW).Serve()
+		c.exitC <- exitResult{err, code}
+	}()
+	go func() {
+		rd := bufio.NewReader(outR)
+		for {
+			var length int
+			if _, err := fmt.Fscanf(rd, "Content-Length: %d\r\n\r\n", &length); err != nil {
+				close(c.msgC)
+				return
+			}
+			body := make([]byte, length)
+			if _, err := io.ReadFull(rd, body); err != nil {
+				close(c.msgC)
+				return
+			}
+			msg := &dapMessage{}
+			if err := json.Unmarshal(body, msg); err != nil {
+				t.Errorf("Invalid DAP message %s: %v", body, err)
+			}
+			c.msgC <- msg
+		}
+	}()
+	return c
+}
+
+// request sends a DAP request and returns its response, events received
+// meanwhile are stored in c.events
+func (c *dapClient) request(command string, args interface{}) *dapMessage {
+	c.seq++
+	req := map[string]interface{}{"seq": c.seq, "type": "request", "command": command}
+	if args != nil {
+		req["arguments"] = args
+	}
+	body, _ := json.Marshal(req)
+	if _, err := fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		c.t.Fatalf("Cannot send request %s: %v", command, err)
+	}
+	for {
+		select {
+		case msg, ok := <-c.msgC:
+			if !ok {
+				c.t.Fatalf("DAP server closed while waiting response of %s", command)
+			}
+			if msg.Type == "event" {
+				c.events = append(c.events, msg)
+				continue
+			}
+			if msg.RequestSeq != c.seq || msg.Command != command {
+				c.t.Fatalf("Unexpected response %+v (expected response of %s)", msg, command)
+			}
+			return msg
+		case <-time.After(5 * time.Second):
+			c.t.Fatalf("Timeout while waiting response of %s", command)
+		}
+	}
+}
+
+// event waits for an event not yet received
+func (c *dapClient) event(name string) *dapMessage {
+	for i, ev := range c.events {
+		if ev.Event == name {
+			c.events = append(c.events[:i], c.events[i+1:]...)
+			return ev
+		}
+	}
+	for {
+		select {
+		case msg := <-c.msgC:
+			if msg != nil && msg.Type == "event" && msg.Event == name {
+				return msg
+			}
+			if msg != nil && msg.Type == "event" {
+				c.events = append(c.events, msg)
+			}
+		case <-time.After(5 * time.Second):
+			c.t.Fatalf("Timeout while waiting event %s", name)
+		}
+	}
+}
+
+func dapBody(t *testing.T, msg *dapMessage) map[string]interface{} {
+	if msg.Success == nil || !*msg.Success {
+		t.Fatalf("Request %s failed: %s", msg.Command, msg.Message)
+	}
+	body, _ := msg.Body.(map[string]interface{})
+	return body
+}
+
+// dapList returns a list of a DAP body as JSON to ease comparison
+func dapList(body map[string]interface{}, name string) string {
+	js, _ := json.Marshal(body[name])
+	return string(js)
+}
+
+func startDapClient(t *testing.T, gdb *stubGdb) *dapClient {
+	c := newDapClient(t, gdb)
+	body := dapBody(t, c.request("initialize", map[string]interface{}{"adapterID": "xds-gdb"}))
+	if body["supportsConfigurationDoneRequest"] != true || body["supportsFunctionBreakpoints"] != true {
+		t.Errorf("Unexpected capabilities: %v", body)
+	}
+	dapBody(t, c.request("launch", map[string]interface{}{
+		"program": "/prj/build/hello",
+		"args":    []string{"-v", "my file", `say "hi"`, `C:\tmp`},
+		"cwd":     "/prj/build",
+	}))
+	c.event("initialized")
+	if !gdb.started {
+		t.Fatalf("gdb not started by launch request")
+	}
+	return c
+}
+
+func (c *dapClient) disconnect() {
+	dapBody(c.t, c.request("disconnect", nil))
+	select {
+	case res := <-c.exitC:
+		if res.code != 0 || res.error != nil {
+			c.t.Errorf("Unexpected DAP server exit: code=%d err=%v", res.code, res.error)
+		}
+	case <-time.After(5 * time.Second):
+		c.t.Errorf("DAP server not stopped by disconnect")
+	}
+}
+
+func TestDapLaunch(t *testing.T) {
+	gdb := newStubGdb()
+	c := startDapClient(t, gdb)
+	exp := []string{
+		`-environment-cd "/prj/build"`,
+		`-file-exec-and-symbols "/prj/build/hello"`,
+		`-exec-arguments "-v" "my file" "say \"hi\"" "C:\\tmp"`,
+	}
+	if cmds := gdb.commands(); strings.Join(cmds, "\n") != strings.Join(exp, "\n") {
+		t.Errorf("Unexpected launch commands:\n%s", strings.Join(cmds, "\n"))
+	}
+	if res := c.request("launch", nil); res.Success == nil || *res.Success {
+		t.Errorf("Second launch must fail")
+	}
+
+	gdb.reply("-exec-run", `^running`, `*running,thread-id="all"`,
+		`*stopped,reason="breakpoint-hit",disp="keep",bkptno="1",thread-id="1",stopped-threads="all"`)
+	dapBody(t, c.request("configurationDone", nil))
+	if ev := c.event("stopped"); !strings.Contains(fmt.Sprint(ev.Body), "reason:breakpoint") {
+		t.Errorf("Unexpected stopped event: %v", ev.Body)
+	}
+
+	gdb.reply("-exec-continue", `^running`,
+		`*stopped,reason="exited",exit-code="012"`)
+	dapBody(t, c.request("continue", map[string]interface{}{"threadId": 1}))
+	if ev := c.event("exited"); fmt.Sprint(ev.Body) != "map[exitCode:10]" {
+		t.Errorf("Unexpected exited event: %v", ev.Body)
+	}
+	c.event("terminated")
+
+	c.disconnect()
+	if len(gdb.signals) != 1 || gdb.signals[0] != syscall.SIGINT {
+		t.Errorf("gdb not interrupted on disconnect: %v", gdb.signals)
+	}
+	if cmds := gdb.commands(); cmds[len(cmds)-1] != "-gdb-exit" {
+		t.Errorf("gdb not exited on disconnect: %v", cmds)
+	}
+}
+
+func TestDapSetBreakpoints(t *testing.T) {
+	gdb := newStubGdb()
+	c := startDapClient(t, gdb)
+	defer c.disconnect()
+
+	gdb.reply("-break-insert", `^done,bkpt={number="1",type="breakpoint",addr="0x0000000000400526",file="main.c",fullname="/prj/main.c",line="10"}`)
+	gdb.reply("-break-insert", `^done,bkpt={number="2",type="breakpoint",addr="<PENDING>",pending="/prj/main.c:20"}`)
+	gdb.reply("-break-insert", `^error,msg="No line 99 in file \"main.c\"."`)
+	args := map[string]interface{}{
+		"source": map[string]string{"path": "/prj/main.c"},
+		"breakpoints": []map[string]interface{}{
+			{"line": 10},
+			{"line": 20, "condition": `s == "a"`},
+			{"line": 99},
+		},
+	}
+	body := dapBody(t, c.request("setBreakpoints", args))
+	exp := `[{"id":1,"line":10,"source":{"name":"main.c","path":"/prj/main.c"},"verified":true},` +
+		`{"id":2,"verified":false},` +
+		`{"message":"No line 99 in file \"main.c\".","verified":false}]`
+	if bkpts := dapList(body, "breakpoints"); bkpts != exp {
+		t.Errorf("Unexpected breakpoints:\n%s\nexpected:\n%s", bkpts, exp)
+	}
+	cmds := gdb.commands()[3:]
+	if len(cmds) != 3 || cmds[0] != `-break-insert -f "/prj/main.c:10"` || cmds[1] != `-break-insert -f -c "s == \"a\"" "/prj/main.c:20"` {
+		t.Errorf("Unexpected break commands: %q", cmds)
+	}
+
+	// breakpoints of a source are replaced
+	gdb.reply("-break-insert", `^done,bkpt={number="3",type="breakpoint",addr="0x0000000000400530",file="main.c",fullname="/prj/main.c",line="12"}`)
+	args["breakpoints"] = []map[string]interface{}{{"line": 12}}
+	body = dapBody(t, c.request("setBreakpoints", args))
+	if bkpts := dapList(body, "breakpoints"); !strings.HasPrefix(bkpts, `[{"id":3,"line":12,`) {
+		t.Errorf("Unexpected breakpoints: %s", bkpts)
+	}
+	if cmds := gdb.commands(); cmds[6] != "-break-delete 1 2" {
+		t.Errorf("Previous breakpoints not deleted: %q", cmds[6:])
+	}
+}
+
+func TestDapStackAndVariables(t *testing.T) {
+	gdb := newStubGdb()
+	c := startDapClient(t, gdb)
+	defer c.disconnect()
+
+	gdb.reply("-stack-list-frames", `^done,stack=[frame={level="0",addr="0x0000000000400526",func="main",file="main.c",fullname="/prj/main.c",line="10"},frame={level="1",addr="0x00007ffff7a2d830"}]`)
+	body := dapBody(t, c.request("stackTrace", map[string]interface{}{"threadId": 1, "startFrame": 0, "levels": 20}))
+	exp := `[{"column":0,"id":1,"line":10,"name":"main","source":{"name":"main.c","path":"/prj/main.c"}},` +
+		`{"column":0,"id":2,"line":0,"name":"0x00007ffff7a2d830"}]`
+	if frames := dapList(body, "stackFrames"); frames != exp {
+		t.Errorf("Unexpected frames:\n%s\nexpected:\n%s", frames, exp)
+	}
+	if cmds := gdb.commands(); cmds[len(cmds)-1] != "-stack-list-frames --thread 1 0 19" {
+		t.Errorf("Unexpected stack command: %s", cmds[len(cmds)-1])
+	}
+
+	body = dapBody(t, c.request("scopes", map[string]interface{}{"frameId": 1}))
+	if scopes := dapList(body, "scopes"); scopes != `[{"expensive":false,"name":"Locals","variablesReference":3}]` {
+		t.Fatalf("Unexpected scopes: %s", scopes)
+	}
+	if res := c.request("scopes", map[string]interface{}{"frameId": 42}); res.Success == nil || *res.Success {
+		t.Errorf("Scopes of unknown frame must fail")
+	}
+
+	gdb.reply("-stack-list-variables", `^done,variables=[{name="argc",arg="1"},{name="s"}]`)
+	gdb.reply("-var-create", `^done,name="var1",numchild="0",value="1",type="int",has_more="0"`)
+	gdb.reply("-var-create", `^done,name="var2",numchild="2",value="{...}",type="struct S",has_more="0"`)
+	body = dapBody(t, c.request("variables", map[string]interface{}{"variablesReference": 3}))
+	exp = `[{"name":"argc","type":"int","value":"1","variablesReference":0},` +
+		`{"name":"s","type":"struct S","value":"{...}","variablesReference":4}]`
+	if vars := dapList(body, "variables"); vars != exp {
+		t.Errorf("Unexpected variables:\n%s\nexpected:\n%s", vars, exp)
+	}
+	if cmds := gdb.commands(); cmds[len(cmds)-1] != `-var-create --thread 1 --frame 0 - * "s"` {
+		t.Errorf("Unexpected varobj command: %s", cmds[len(cmds)-1])
+	}
+
+	gdb.reply("-var-list-children", `^done,numchild="2",children=[child={name="var2.a",exp="a",numchild="0",value="3",type="int"},child={name="var2.p",exp="p",numchild="1",value="0x601010",type="char *"}],has_more="0"`)
+	body = dapBody(t, c.request("variables", map[string]interface{}{"variablesReference": 4}))
+	exp = `[{"name":"a","type":"int","value":"3","variablesReference":0},` +
+		`{"name":"p","type":"char *","value":"0x601010","variablesReference":5}]`
+	if vars := dapList(body, "variables"); vars != exp {
+		t.Errorf("Unexpected children:\n%s\nexpected:\n%s", vars, exp)
+	}
+	if cmds := gdb.commands(); cmds[len(cmds)-1] != `-var-list-children --all-values "var2"` {
+		t.Errorf("Unexpected children command: %s", cmds[len(cmds)-1])
+	}
+
+	// varobjs are deleted when execution resumes
+	gdb.reply("-exec-next", `^running`)
+	dapBody(t, c.request("next", map[string]interface{}{"threadId": 1}))
+	cmds := gdb.commands()
+	if exp := []string{`-var-delete "var1"`, `-var-delete "var2"`, `-exec-next --thread 1`}; strings.Join(cmds[len(cmds)-3:], ",") != strings.Join(exp, ",") {
+		t.Errorf("Unexpected commands on next: %q", cmds[len(cmds)-3:])
+	}
+}
+
+func TestDapEvaluate(t *testing.T) {
+	gdb := newStubGdb()
+	c := startDapClient(t, gdb)
+	defer c.disconnect()
+
+	gdb.reply("-stack-list-frames", `^done,stack=[frame={level="0",addr="0x0000000000400526",func="main"}]`)
+	dapBody(t, c.request("stackTrace", map[string]interface{}{"threadId": 2}))
+
+	gdb.reply("-data-evaluate-expression", `^done,value="2"`)
+	body := dapBody(t, c.request("evaluate", map[string]interface{}{"expression": "argc + 1", "frameId": 1, "context": "watch"}))
+	if body["result"] != "2" {
+		t.Errorf("Unexpected evaluate result: %v", body)
+	}
+	if cmds := gdb.commands(); cmds[len(cmds)-1] != `-data-evaluate-expression --thread 2 --frame 0 "argc + 1"` {
+		t.Errorf("Unexpected evaluate command: %s", cmds[len(cmds)-1])
+	}
+
+	gdb.reply("-data-evaluate-expression", `^error,msg="No symbol \"foo\" in current context."`)
+	res := c.request("evaluate", map[string]interface{}{"expression": "foo", "context": "hover"})
+	if res.Success == nil || *res.Success || res.Message != `No symbol "foo" in current context.` {
+		t.Errorf("Unexpected evaluate error: %+v", res)
+	}
+
+	// debug console executes gdb commands, output is sent as console events
+	gdb.reply("-interpreter-exec", `~"rip            0x400526\t0x400526 <main+4>\n"`, `^done`)
+	body = dapBody(t, c.request("evaluate", map[string]interface{}{"expression": "info registers rip", "context": "repl"}))
+	if body["result"] != "" {
+		t.Errorf("Unexpected repl result: %v", body)
+	}
+	if ev := c.event("output"); fmt.Sprint(ev.Body) != "map[category:console output:rip            0x400526\t0x400526 <main+4>\n]" {
+		t.Errorf("Unexpected repl output: %v", ev.Body)
+	}
+	if cmds := gdb.commands(); cmds[len(cmds)-1] != `-interpreter-exec console "info registers rip"` {
+		t.Errorf("Unexpected repl command: %s", cmds[len(cmds)-1])
+	}
+}
+
+func TestDapCmdTimeout(t *testing.T) {
+	defer func(d time.Duration) { dapCmdTimeout = d }(dapCmdTimeout)
+	dapCmdTimeout = 100 * time.Millisecond
+
+	gdb := newStubGdb()
+	c := startDapClient(t, gdb)
+	defer c.disconnect()
+
+	gdb.reply("-data-evaluate-expression", "")
+	res := c.request("evaluate", map[string]interface{}{"expression": "slow()"})
+	if res.Success == nil || *res.Success || !strings.HasPrefix(res.Message, "timeout while waiting result of ") {
+		t.Errorf("Unexpected result of unanswered command: %+v", res)
+	}
+
+	// a late result is ignored and following commands still work
+	gdb.onRecord(ParseMIRecord(`4^done,value="1"`))
+	gdb.reply("-data-evaluate-expression", `^done,value="42"`)
+	body := dapBody(t, c.request("evaluate", map[string]interface{}{"expression": "x"}))
+	if body["result"] != "42" {
+		t.Errorf("Unexpected evaluate result after timeout: %v", body)
+	}
+}
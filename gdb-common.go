@@ -34,6 +34,8 @@ type IGDB interface {
 	OnExit(f func(int, error))
 	Read(f func(timestamp, stdout, stderr string))
 	InferiorRead(f func(timestamp, stdout, stderr string))
+	OnMIRecord(f func(rec *MIRecord))
 	Write(args ...interface{}) error
+	InferiorWrite(args ...interface{}) error
 	SendSignal(sig os.Signal) error
 }
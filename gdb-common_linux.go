@@ -54,6 +54,21 @@ func tcgetattr(fd uintptr, termios *syscall.Termios) error {
 	return nil
 }
 
+// isTerminal returns true when file descriptor is a terminal
+func isTerminal(fd uintptr) bool {
+	var termios syscall.Termios
+	return tcgetattr(fd, &termios) == nil
+}
+
 func isIgnoredSignal(sig os.Signal) bool {
 	return (sig == syscall.SIGWINCH)
 }
+
+// fileOwner returns user ID of file owner and ID of device including file
+func fileOwner(fi os.FileInfo) (uid int, dev uint64, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, 0, false
+	}
+	return int(st.Uid), uint64(st.Dev), true
+}
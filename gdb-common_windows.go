@@ -37,6 +37,25 @@ func NewGdbNative(log *logrus.Logger, args []string, env []string) *GdbXds {
 	return nil
 }
 
+func NewGdbTemplate(log *logrus.Logger, args []string, env []string) *GdbXds {
+	fmt.Printf("Command template gdb mode not supported on Windows !")
+	os.Exit(int(syscall.ENOSYS))
+
+	return nil
+}
+
+// isTerminal returns true when file descriptor is a terminal
+// (interactive mode is not supported on Windows)
+func isTerminal(fd uintptr) bool {
+	return false
+}
+
 func isIgnoredSignal(sig os.Signal) bool {
 	return false
 }
+
+// fileOwner returns user ID of file owner and ID of device including file
+// (not supported on Windows)
+func fileOwner(fi os.FileInfo) (uid int, dev uint64, ok bool) {
+	return -1, 0, false
+}
@@ -0,0 +1,363 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MICommand - GDB/MI input command ([token]-operation [options] [--] [parameters])
+// CLI commands are also supported ([token]command args), IOW when IsCLI is set
+type MICommand struct {
+	Token     string
+	Operation string // operation name without leading '-' (or CLI command name)
+	Args      []string
+	IsCLI     bool
+
+	raw      string
+	rawArgs  string
+	modified bool
+}
+
+// MIRewriteRule - Rule used to rewrite a command matching an operation
+type MIRewriteRule struct {
+	Operation     string           `json:"operation"`               // -mi-operation or cli command to match
+	Replace       string           `json:"replace,omitempty"`       // new operation / command name
+	Drop          bool             `json:"drop,omitempty"`          // do not forward command, reply ^done
+	AddArgs       []string         `json:"addArgs,omitempty"`       // arguments inserted before original ones
+	RemoveOptions map[string]int   `json:"removeOptions,omitempty"` // option name -> number of values to remove
+	TransformArgs []MIArgTransform `json:"transformArgs,omitempty"` // regexp replacement applied on each argument
+}
+
+// MIArgTransform - Regexp replacement of an argument (argument removed when result is empty)
+type MIArgTransform struct {
+	Match   string `json:"match"`
+	Replace string `json:"replace"`
+
+	re *regexp.Regexp
+}
+
+// MIRewriteConfig - Content of overwrite rules config file (XDS_OVERWRITE_RULES)
+type MIRewriteConfig struct {
+	Preset  string                     `json:"preset"`
+	Presets map[string][]MIRewriteRule `json:"presets"`
+}
+
+// MIRewriter - Rules engine used to rewrite commands sent to gdb
+type MIRewriter struct {
+	rules map[string][]*MIRewriteRule
+}
+
+// Name of default overwrite preset
+const miDefaultPreset = "default"
+
+// Name of preset disabling overwrite (default one with native and template
+// backends, IOW when gdb is not connected to a XDS gdbserver)
+const miNonePreset = "none"
+
+// Built-in overwrite presets: default one and per-IDE ones (gdb is already
+// connected to gdbserver and so debugged program is already started, a client
+// tty is meaningless on server side)
+var miBuiltinPresets = map[string][]MIRewriteRule{
+	// default xds-gdb rewrite (IOW gdb already connected to gdbserver)
+	miDefaultPreset: []MIRewriteRule{
+		MIRewriteRule{Operation: "-exec-run", Replace: "-exec-continue"},
+		MIRewriteRule{Operation: "-file-exec-and-symbols", Replace: "-file-exec-file"},
+	},
+	// Visual Studio Code (cppdbg / MIEngine)
+	"vscode": []MIRewriteRule{
+		MIRewriteRule{Operation: "-exec-run", Replace: "-exec-continue", RemoveOptions: map[string]int{"--start": 0}},
+		MIRewriteRule{Operation: "-file-exec-and-symbols", Replace: "-file-exec-file"},
+		MIRewriteRule{Operation: "-inferior-tty-set", Drop: true},
+	},
+	// Eclipse CDT (commands are sent with --thread-group option)
+	"eclipse": []MIRewriteRule{
+		MIRewriteRule{Operation: "-exec-run", Replace: "-exec-continue", RemoveOptions: map[string]int{"--start": 0}},
+		MIRewriteRule{Operation: "-file-exec-and-symbols", Replace: "-file-exec-file", RemoveOptions: map[string]int{"--thread-group": 1}},
+		MIRewriteRule{Operation: "-inferior-tty-set", Drop: true},
+	},
+	// Qt Creator (program is started using CLI run command)
+	"qtcreator": []MIRewriteRule{
+		MIRewriteRule{Operation: "-exec-run", Replace: "-exec-continue"},
+		MIRewriteRule{Operation: "run", Replace: "continue"},
+		MIRewriteRule{Operation: "-file-exec-and-symbols", Replace: "-file-exec-file"},
+		MIRewriteRule{Operation: "-inferior-tty-set", Drop: true},
+	},
+	// CLion
+	"clion": []MIRewriteRule{
+		MIRewriteRule{Operation: "-exec-run", Replace: "-exec-continue"},
+		MIRewriteRule{Operation: "-file-exec-and-symbols", Replace: "-file-exec-file"},
+		MIRewriteRule{Operation: "-inferior-tty-set", Drop: true},
+	},
+	// no rewrite at all
+	miNonePreset: []MIRewriteRule{},
+}
+
+// ParseMICommand parses a command line sent to gdb
+func ParseMICommand(line string) *MICommand {
+	cmd := &MICommand{raw: line}
+	s := strings.TrimSpace(line)
+
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	cmd.Token = s[:i]
+	s = s[i:]
+
+	if strings.HasPrefix(s, "-") {
+		s = s[1:]
+	} else {
+		cmd.IsCLI = true
+	}
+	if idx := strings.IndexAny(s, " \t"); idx >= 0 {
+		cmd.Operation = s[:idx]
+		cmd.rawArgs = strings.TrimSpace(s[idx:])
+	} else {
+		cmd.Operation = s
+	}
+	cmd.Args = splitMIArgs(cmd.rawArgs)
+
+	return cmd
+}
+
+// Options returns the options of a MI command
+// (IOW arguments starting with '-' located before '--' or first parameter)
+func (c *MICommand) Options() []string {
+	opts := []string{}
+	for _, a := range c.Args {
+		if a == "--" || !strings.HasPrefix(a, "-") {
+			break
+		}
+		opts = append(opts, a)
+	}
+	return opts
+}
+
+// Parameters returns the parameters of a MI command
+func (c *MICommand) Parameters() []string {
+	for i, a := range c.Args {
+		if a == "--" {
+			return c.Args[i+1:]
+		}
+		if !strings.HasPrefix(a, "-") {
+			return c.Args[i:]
+		}
+	}
+	return []string{}
+}
+
+// String returns the command line (unchanged when no rewrite was applied)
+func (c *MICommand) String() string {
+	if !c.modified {
+		return c.raw
+	}
+	s := c.Token
+	if !c.IsCLI {
+		s += "-"
+	}
+	s += c.Operation
+	if len(c.Args) > 0 {
+		s += " " + strings.Join(c.Args, " ")
+	}
+	return s
+}
+
+// NewMIRewriter creates a new rewrite engine from a preset
+// defined either in config file (when set) or in built-in presets
+func NewMIRewriter(confFile, preset string) (*MIRewriter, error) {
+	presets := make(map[string][]MIRewriteRule)
+	for k, v := range miBuiltinPresets {
+		presets[k] = v
+	}
+
+	if confFile != "" {
+		data, err := ioutil.ReadFile(confFile)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read overwrite rules file %s: %v", confFile, err)
+		}
+		conf := MIRewriteConfig{}
+		if err := json.Unmarshal(data, &conf); err != nil {
+			return nil, fmt.Errorf("Cannot decode overwrite rules file %s: %v", confFile, err)
+		}
+		for k, v := range conf.Presets {
+			presets[k] = v
+		}
+		if preset == "" {
+			preset = conf.Preset
+		}
+	}
+	if preset == "" {
+		preset = miDefaultPreset
+	}
+
+	rules, exist := presets[preset]
+	if !exist {
+		names := []string{}
+		for k := range presets {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("Unknown overwrite preset '%s' (supported: %s)", preset, strings.Join(names, ", "))
+	}
+
+	return NewMIRewriterFromRules(rules)
+}
+
+// NewMIRewriterFromRules creates a new rewrite engine from a list of rules
+func NewMIRewriterFromRules(rules []MIRewriteRule) (*MIRewriter, error) {
+	rw := &MIRewriter{rules: make(map[string][]*MIRewriteRule)}
+	for i := range rules {
+		r := rules[i]
+		if r.Operation == "" {
+			return nil, fmt.Errorf("Invalid overwrite rule #%d: operation not set", i)
+		}
+		for j := range r.TransformArgs {
+			re, err := regexp.Compile(r.TransformArgs[j].Match)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid overwrite rule %s: %v", r.Operation, err)
+			}
+			r.TransformArgs[j].re = re
+		}
+		rw.rules[r.Operation] = append(rw.rules[r.Operation], &r)
+	}
+	return rw, nil
+}
+
+// ParseMIRewriteLegacy converts XDS_OVERWRITE_COMMANDS syntax
+// (eg. "-exec-run:-exec-continue,-file-exec-and-symbols:-file-exec-file") into rules
+func ParseMIRewriteLegacy(def string) ([]MIRewriteRule, error) {
+	rules := []MIRewriteRule{}
+	def = strings.TrimSpace(def)
+	if len(def) == 0 {
+		return rules, nil
+	}
+	for _, d := range strings.Split(def, ",") {
+		kv := strings.Split(d, ":")
+		if len(kv) != 2 {
+			return rules, fmt.Errorf("Invalid definition in XDS_OVERWRITE_COMMANDS (%s)", d)
+		}
+		rules = append(rules, MIRewriteRule{
+			Operation: strings.TrimSpace(kv[0]),
+			Replace:   strings.TrimSpace(kv[1]),
+		})
+	}
+	return rules, nil
+}
+
+// Rewrite applies rules matching command operation.
+// Returns false when command must not be sent to gdb.
+func (rw *MIRewriter) Rewrite(cmd *MICommand) bool {
+	key := cmd.Operation
+	if !cmd.IsCLI {
+		key = "-" + key
+	}
+	for _, r := range rw.rules[key] {
+		if r.Drop {
+			return false
+		}
+		if r.Replace != "" {
+			cmd.Operation = strings.TrimPrefix(r.Replace, "-")
+			cmd.IsCLI = !strings.HasPrefix(r.Replace, "-")
+		}
+		if len(r.RemoveOptions) > 0 {
+			args := []string{}
+			for i := 0; i < len(cmd.Args); i++ {
+				if nb, exist := r.RemoveOptions[cmd.Args[i]]; exist {
+					i += nb
+					continue
+				}
+				args = append(args, cmd.Args[i])
+			}
+			cmd.Args = args
+		}
+		if len(r.TransformArgs) > 0 {
+			args := []string{}
+			for _, a := range cmd.Args {
+				for _, t := range r.TransformArgs {
+					a = t.re.ReplaceAllString(a, t.Replace)
+				}
+				if a != "" {
+					args = append(args, a)
+				}
+			}
+			cmd.Args = args
+		}
+		if len(r.AddArgs) > 0 {
+			cmd.Args = append(append([]string{}, r.AddArgs...), cmd.Args...)
+		}
+		cmd.modified = true
+	}
+	return true
+}
+
+// String returns the list of rules (for debug purpose)
+func (rw *MIRewriter) String() string {
+	s := []string{}
+	for op, rules := range rw.rules {
+		for _, r := range rules {
+			desc := op + ":" + r.Replace
+			if r.Drop {
+				desc += "(drop)"
+			}
+			if len(r.AddArgs)+len(r.RemoveOptions)+len(r.TransformArgs) > 0 {
+				desc += "(args)"
+			}
+			s = append(s, desc)
+		}
+	}
+	sort.Strings(s)
+	return "[" + strings.Join(s, " ") + "]"
+}
+
+//***** Private functions *****
+
+// splitMIArgs splits arguments separated by blanks, c-strings are kept quoted
+func splitMIArgs(s string) []string {
+	args := []string{}
+	cur := ""
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inQuote && c == '\\' && i+1 < len(s):
+			cur += s[i : i+2]
+			i++
+		case c == '"':
+			inQuote = !inQuote
+			cur += string(c)
+		case !inQuote && (c == ' ' || c == '\t'):
+			if cur != "" {
+				args = append(args, cur)
+				cur = ""
+			}
+		default:
+			cur += string(c)
+		}
+	}
+	if cur != "" {
+		args = append(args, cur)
+	}
+	return args
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMICommand(t *testing.T) {
+	for line, exp := range map[string]MICommand{
+		"123-exec-run\n": {Token: "123", Operation: "exec-run", Args: []string{}},
+		"-break-insert -t -c \"x > 1\" main.c:10": {Operation: "break-insert", Args: []string{"-t", "-c", `"x > 1"`, "main.c:10"}},
+		"  info   registers  ":                    {Operation: "info", Args: []string{"registers"}, IsCLI: true},
+		"42run":                                   {Token: "42", Operation: "run", Args: []string{}, IsCLI: true},
+	} {
+		cmd := ParseMICommand(line)
+		if cmd.Token != exp.Token || cmd.Operation != exp.Operation || cmd.IsCLI != exp.IsCLI || !reflect.DeepEqual(cmd.Args, exp.Args) {
+			t.Errorf("ParseMICommand(%q) = %+v (expected %+v)", line, cmd, exp)
+		}
+		if cmd.String() != line {
+			t.Errorf("Unmodified command must be returned as is: %q", cmd.String())
+		}
+	}
+
+	cmd := ParseMICommand("-data-evaluate-expression --thread 1 --frame 0 -- -x")
+	if opts := cmd.Options(); !reflect.DeepEqual(opts, []string{"--thread"}) {
+		t.Errorf("Unexpected options: %v", opts)
+	}
+	if params := cmd.Parameters(); !reflect.DeepEqual(params, []string{"1", "--frame", "0", "--", "-x"}) {
+		t.Errorf("Unexpected parameters: %v", params)
+	}
+	cmd = ParseMICommand("-exec-arguments -- -v")
+	if params := cmd.Parameters(); !reflect.DeepEqual(params, []string{"-v"}) {
+		t.Errorf("Unexpected parameters after --: %v", params)
+	}
+}
+
+func TestSplitMIArgs(t *testing.T) {
+	for s, exp := range map[string][]string{
+		"":                               {},
+		"a  b\tc":                        {"a", "b", "c"},
+		`"with space" x`:                 {`"with space"`, "x"},
+		`"escaped \" quote" "a\\" b`:     {`"escaped \" quote"`, `"a\\"`, "b"},
+		`prefix"in quote"suffix tail`:    {`prefix"in quote"suffix`, "tail"},
+		`"unterminated quote with space`: {`"unterminated quote with space`},
+	} {
+		if args := splitMIArgs(s); !reflect.DeepEqual(args, exp) {
+			t.Errorf("splitMIArgs(%q) = %q (expected %q)", s, args, exp)
+		}
+	}
+}
+
+func TestMIRewrite(t *testing.T) {
+	rw, err := NewMIRewriterFromRules([]MIRewriteRule{
+		{Operation: "-exec-run", Replace: "-exec-continue", RemoveOptions: map[string]int{"--start": 0, "--thread-group": 1}},
+		{Operation: "-inferior-tty-set", Drop: true},
+		{Operation: "-file-exec-and-symbols", TransformArgs: []MIArgTransform{
+			{Match: "^/home/user/", Replace: "/home/devel/"},
+			{Match: "^--remove-me$", Replace: ""},
+		}},
+		{Operation: "-break-insert", AddArgs: []string{"-f"}},
+		{Operation: "run", Replace: "-exec-continue"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for line, exp := range map[string]string{
+		"12-exec-run --thread-group i1 --start":                 "12-exec-continue",
+		"-file-exec-and-symbols --remove-me /home/user/prj/app": "-file-exec-and-symbols /home/devel/prj/app",
+		"-break-insert main":                                    "-break-insert -f main",
+		"run":                                                   "-exec-continue",
+		"-exec-next":                                            "-exec-next",
+		"echo -exec-run":                                        "echo -exec-run",
+	} {
+		cmd := ParseMICommand(line)
+		if !rw.Rewrite(cmd) {
+			t.Errorf("%q must not be dropped", line)
+		}
+		if cmd.String() != exp {
+			t.Errorf("Rewrite(%q) = %q (expected %q)", line, cmd.String(), exp)
+		}
+	}
+	if rw.Rewrite(ParseMICommand("5-inferior-tty-set /dev/pts/3")) {
+		t.Errorf("-inferior-tty-set must be dropped")
+	}
+
+	if _, err := NewMIRewriterFromRules([]MIRewriteRule{{Replace: "x"}}); err == nil {
+		t.Errorf("Rule without operation not reported")
+	}
+	if _, err := NewMIRewriterFromRules([]MIRewriteRule{{Operation: "x", TransformArgs: []MIArgTransform{{Match: "("}}}}); err == nil {
+		t.Errorf("Invalid regexp not reported")
+	}
+}
+
+func TestMIRewriterPresets(t *testing.T) {
+	for _, preset := range []string{"", "default", "vscode", "eclipse", "qtcreator", "clion"} {
+		rw, err := NewMIRewriter("", preset)
+		if err != nil {
+			t.Fatalf("Preset %q: %v", preset, err)
+		}
+		cmd := ParseMICommand("-exec-run")
+		if !rw.Rewrite(cmd) || cmd.String() != "-exec-continue" {
+			t.Errorf("Preset %q: unexpected rewrite of -exec-run: %q", preset, cmd.String())
+		}
+	}
+
+	rw, _ := NewMIRewriter("", "eclipse")
+	cmd := ParseMICommand("-file-exec-and-symbols --thread-group i1 /prj/app")
+	if rw.Rewrite(cmd); cmd.String() != "-file-exec-file /prj/app" {
+		t.Errorf("Unexpected eclipse rewrite: %q", cmd.String())
+	}
+	rw, _ = NewMIRewriter("", "qtcreator")
+	cmd = ParseMICommand("run")
+	if rw.Rewrite(cmd); cmd.String() != "continue" {
+		t.Errorf("Unexpected qtcreator rewrite: %q", cmd.String())
+	}
+	rw, _ = NewMIRewriter("", "none")
+	cmd = ParseMICommand("-exec-run")
+	if rw.Rewrite(cmd); cmd.String() != "-exec-run" {
+		t.Errorf("none preset must not rewrite: %q", cmd.String())
+	}
+
+	if _, err := NewMIRewriter("", "emacs"); err == nil || !strings.Contains(err.Error(), "clion, default, eclipse, none, qtcreator, vscode") {
+		t.Errorf("Unknown preset not reported with supported ones: %v", err)
+	}
+
+	// presets of config file extend built-in ones
+	dir, _ := ioutil.TempDir("", "xds-gdb-test")
+	defer os.RemoveAll(dir)
+	conf := writeTestFile(t, dir, "rules.json", `{"preset": "mine", "presets": {"mine": [{"operation": "-exec-run", "drop": true}]}}`)
+	rw, err := NewMIRewriter(conf, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rw.Rewrite(ParseMICommand("-exec-run")) {
+		t.Errorf("Preset of config file not used")
+	}
+	if _, err := NewMIRewriter(conf, "vscode"); err != nil {
+		t.Errorf("Built-in preset not available with config file: %v", err)
+	}
+}
@@ -0,0 +1,461 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MIRecordType - Type of a GDB/MI output record
+type MIRecordType string
+
+// Types of GDB/MI output records
+const (
+	MIRecordResult  MIRecordType = "result"  // [token]^class,results
+	MIRecordExec    MIRecordType = "exec"    // [token]*class,results
+	MIRecordStatus  MIRecordType = "status"  // [token]+class,results
+	MIRecordNotify  MIRecordType = "notify"  // [token]=class,results
+	MIRecordConsole MIRecordType = "console" // ~"text"
+	MIRecordTarget  MIRecordType = "target"  // @"text"
+	MIRecordLog     MIRecordType = "log"     // &"text"
+	MIRecordPrompt  MIRecordType = "prompt"  // (gdb)
+	MIRecordUnknown MIRecordType = "unknown" // not a MI line (eg. CLI or inferior output)
+)
+
+// MIValue - Value of a GDB/MI result (MIConst, MITuple or MIList)
+type MIValue interface {
+	String() string
+}
+
+// MIConst - GDB/MI constant (c-string, stored unescaped)
+type MIConst string
+
+// MIResult - GDB/MI result (variable=value)
+type MIResult struct {
+	Variable string
+	Value    MIValue
+}
+
+// MITuple - GDB/MI tuple ({var=value,...}), order and duplicated names are kept
+type MITuple []MIResult
+
+// MIList - GDB/MI list ([value,...] or [var=value,...])
+// Items of a list of results are MIResult
+type MIList []MIValue
+
+// MIRecord - GDB/MI output record
+type MIRecord struct {
+	Type    MIRecordType
+	Token   string
+	Class   string  // result or async class (eg. done, error, stopped, breakpoint-modified)
+	Results MITuple // results of result and async records
+	Stream  string  // unescaped text of stream records
+	Raw     string  // original line (without newline)
+}
+
+// MIParser - Stream parser that turns gdb output chunks into MI records
+type MIParser struct {
+	buf string
+}
+
+// NewMIParser creates a new instance of MIParser
+func NewMIParser() *MIParser {
+	return &MIParser{}
+}
+
+// Feed adds a chunk of gdb output and returns all complete records.
+// A trailing partial line is kept until the next call, except the
+// "(gdb) " prompt that is returned as soon as it is received.
+func (p *MIParser) Feed(chunk string) []*MIRecord {
+	records := []*MIRecord{}
+	p.buf += chunk
+	for {
+		idx := strings.IndexByte(p.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		line := strings.TrimRight(p.buf[:idx], "\r")
+		p.buf = p.buf[idx+1:]
+		records = append(records, ParseMIRecord(line))
+	}
+	if strings.TrimSpace(p.buf) == "(gdb)" {
+		records = append(records, ParseMIRecord(p.buf))
+		p.buf = ""
+	}
+	return records
+}
+
+// Flush returns the pending partial line as a record (if any)
+func (p *MIParser) Flush() *MIRecord {
+	if p.buf == "" {
+		return nil
+	}
+	rec := ParseMIRecord(strings.TrimRight(p.buf, "\r"))
+	p.buf = ""
+	return rec
+}
+
+// ParseMIRecord parses a single line of gdb output.
+// Lines that are not valid MI are returned as MIRecordUnknown records.
+func ParseMIRecord(line string) *MIRecord {
+	rec := &MIRecord{Type: MIRecordUnknown, Raw: line}
+
+	if strings.TrimSpace(line) == "(gdb)" {
+		rec.Type = MIRecordPrompt
+		return rec
+	}
+
+	// Optional token
+	i := 0
+	for i < len(line) && line[i] >= '0' && line[i] <= '9' {
+		i++
+	}
+	if i >= len(line) {
+		return rec
+	}
+	token := line[:i]
+
+	switch line[i] {
+	case '~', '@', '&':
+		if token != "" {
+			return rec
+		}
+		l := miLexer{s: line, pos: i + 1}
+		str, err := l.cstring()
+		if err != nil || l.pos != len(l.s) {
+			return rec
+		}
+		rec.Type = map[byte]MIRecordType{
+			'~': MIRecordConsole,
+			'@': MIRecordTarget,
+			'&': MIRecordLog,
+		}[line[i]]
+		rec.Stream = str
+		return rec
+
+	case '^', '*', '+', '=':
+		l := miLexer{s: line, pos: i + 1}
+		class := l.ident()
+		if class == "" {
+			return rec
+		}
+		results := MITuple{}
+		for l.pos < len(l.s) {
+			if !l.accept(',') {
+				return rec
+			}
+			res, err := l.result()
+			if err != nil {
+				return rec
+			}
+			results = append(results, res)
+		}
+		rec.Type = map[byte]MIRecordType{
+			'^': MIRecordResult,
+			'*': MIRecordExec,
+			'+': MIRecordStatus,
+			'=': MIRecordNotify,
+		}[line[i]]
+		rec.Token = token
+		rec.Class = class
+		rec.Results = results
+		return rec
+	}
+
+	return rec
+}
+
+// String returns the MI representation of the record
+func (r *MIRecord) String() string {
+	switch r.Type {
+	case MIRecordPrompt:
+		return "(gdb) "
+	case MIRecordConsole:
+		return "~" + miQuote(r.Stream)
+	case MIRecordTarget:
+		return "@" + miQuote(r.Stream)
+	case MIRecordLog:
+		return "&" + miQuote(r.Stream)
+	case MIRecordResult, MIRecordExec, MIRecordStatus, MIRecordNotify:
+		prefix := map[MIRecordType]string{
+			MIRecordResult: "^",
+			MIRecordExec:   "*",
+			MIRecordStatus: "+",
+			MIRecordNotify: "=",
+		}[r.Type]
+		s := r.Token + prefix + r.Class
+		for _, res := range r.Results {
+			s += "," + res.String()
+		}
+		return s
+	}
+	return r.Raw
+}
+
+// IsStream returns true for console, target and log stream records
+func (r *MIRecord) IsStream() bool {
+	return r.Type == MIRecordConsole || r.Type == MIRecordTarget || r.Type == MIRecordLog
+}
+
+// IsAsync returns true for exec, status and notify async records
+func (r *MIRecord) IsAsync() bool {
+	return r.Type == MIRecordExec || r.Type == MIRecordStatus || r.Type == MIRecordNotify
+}
+
+// Get returns the value of the first result named name
+func (r *MIRecord) Get(name string) MIValue {
+	return r.Results.Get(name)
+}
+
+// GetString returns the value of the first constant result named name
+func (r *MIRecord) GetString(name string) string {
+	return r.Results.GetString(name)
+}
+
+// Get returns the value of the first result named name (or nil)
+func (t MITuple) Get(name string) MIValue {
+	for _, r := range t {
+		if r.Variable == name {
+			return r.Value
+		}
+	}
+	return nil
+}
+
+// GetString returns the value of the first constant result named name
+func (t MITuple) GetString(name string) string {
+	if c, ok := t.Get(name).(MIConst); ok {
+		return string(c)
+	}
+	return ""
+}
+
+// String returns the MI representation of the tuple
+func (t MITuple) String() string {
+	s := []string{}
+	for _, r := range t {
+		s = append(s, r.String())
+	}
+	return "{" + strings.Join(s, ",") + "}"
+}
+
+// String returns the MI representation of the list
+func (l MIList) String() string {
+	s := []string{}
+	for _, v := range l {
+		s = append(s, v.String())
+	}
+	return "[" + strings.Join(s, ",") + "]"
+}
+
+// String returns the MI representation of the result
+func (r MIResult) String() string {
+	return r.Variable + "=" + r.Value.String()
+}
+
+// String returns the MI representation (quoted c-string) of the constant
+func (c MIConst) String() string {
+	return miQuote(string(c))
+}
+
+//***** Private functions *****
+
+// miLexer - GDB/MI output lexer
+type miLexer struct {
+	s   string
+	pos int
+}
+
+func (l *miLexer) accept(c byte) bool {
+	if l.pos < len(l.s) && l.s[l.pos] == c {
+		l.pos++
+		return true
+	}
+	return false
+}
+
+func (l *miLexer) ident() string {
+	start := l.pos
+	for l.pos < len(l.s) {
+		c := l.s[l.pos]
+		if c == ',' || c == '=' || c == '{' || c == '}' || c == '[' || c == ']' || c == '"' {
+			break
+		}
+		l.pos++
+	}
+	return l.s[start:l.pos]
+}
+
+func (l *miLexer) result() (MIResult, error) {
+	name := l.ident()
+	if name == "" || !l.accept('=') {
+		return MIResult{}, fmt.Errorf("invalid result at offset %d", l.pos)
+	}
+	val, err := l.value()
+	if err != nil {
+		return MIResult{}, err
+	}
+	return MIResult{Variable: name, Value: val}, nil
+}
+
+func (l *miLexer) value() (MIValue, error) {
+	if l.pos >= len(l.s) {
+		return nil, fmt.Errorf("unexpected end of line")
+	}
+	switch l.s[l.pos] {
+	case '"':
+		str, err := l.cstring()
+		return MIConst(str), err
+
+	case '{':
+		l.pos++
+		tuple := MITuple{}
+		if l.accept('}') {
+			return tuple, nil
+		}
+		for {
+			res, err := l.result()
+			if err != nil {
+				return nil, err
+			}
+			tuple = append(tuple, res)
+			if l.accept('}') {
+				return tuple, nil
+			}
+			if !l.accept(',') {
+				return nil, fmt.Errorf("invalid tuple at offset %d", l.pos)
+			}
+		}
+
+	case '[':
+		l.pos++
+		list := MIList{}
+		if l.accept(']') {
+			return list, nil
+		}
+		for {
+			var item MIValue
+			var err error
+			if l.pos >= len(l.s) {
+				return nil, fmt.Errorf("unexpected end of line")
+			}
+			if c := l.s[l.pos]; c == '"' || c == '{' || c == '[' {
+				item, err = l.value()
+			} else {
+				item, err = l.result()
+			}
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+			if l.accept(']') {
+				return list, nil
+			}
+			if !l.accept(',') {
+				return nil, fmt.Errorf("invalid list at offset %d", l.pos)
+			}
+		}
+	}
+	return nil, fmt.Errorf("invalid value at offset %d", l.pos)
+}
+
+// cstring decodes a quoted C string (including octal escapes)
+func (l *miLexer) cstring() (string, error) {
+	if !l.accept('"') {
+		return "", fmt.Errorf("missing quote at offset %d", l.pos)
+	}
+	var sb bytes.Buffer
+	for l.pos < len(l.s) {
+		c := l.s[l.pos]
+		l.pos++
+		switch c {
+		case '"':
+			return sb.String(), nil
+		case '\\':
+			if l.pos >= len(l.s) {
+				return "", fmt.Errorf("unterminated escape sequence")
+			}
+			e := l.s[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case 'f':
+				sb.WriteByte('\f')
+			case 'v':
+				sb.WriteByte('\v')
+			case 'a':
+				sb.WriteByte('\a')
+			case 'b':
+				sb.WriteByte('\b')
+			case 'e':
+				sb.WriteByte('\033')
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				end := l.pos - 1
+				for end < len(l.s) && end < l.pos+2 && l.s[end] >= '0' && l.s[end] <= '7' {
+					end++
+				}
+				v, _ := strconv.ParseUint(l.s[l.pos-1:end], 8, 8)
+				sb.WriteByte(byte(v))
+				l.pos = end
+			default:
+				sb.WriteByte(e)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+// miQuote encodes a string as a GDB/MI c-string
+func miQuote(s string) string {
+	var sb bytes.Buffer
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\r':
+			sb.WriteString(`\r`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				sb.WriteString(fmt.Sprintf("\\%03o", c))
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
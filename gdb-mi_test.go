@@ -0,0 +1,165 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+func TestMIParserFeed(t *testing.T) {
+	p := NewMIParser()
+
+	// record split across chunks is only returned once complete
+	if recs := p.Feed(`12^done,value="4`); len(recs) != 0 {
+		t.Fatalf("Partial line returned: %v", recs)
+	}
+	recs := p.Feed("2\"\n~\"hello\\n\"\n=thread-")
+	if len(recs) != 2 {
+		t.Fatalf("Unexpected records: %v", recs)
+	}
+	if r := recs[0]; r.Type != MIRecordResult || r.Token != "12" || r.Class != "done" || r.GetString("value") != "42" {
+		t.Errorf("Unexpected result record: %+v", r)
+	}
+	if r := recs[1]; r.Type != MIRecordConsole || r.Stream != "hello\n" || !r.IsStream() {
+		t.Errorf("Unexpected console record: %+v", r)
+	}
+
+	// prompt is returned without waiting for newline, CRLF is accepted
+	recs = p.Feed("group-added,id=\"i1\"\r\n(gdb) ")
+	if len(recs) != 2 || recs[0].Type != MIRecordNotify || recs[0].Class != "thread-group-added" || !recs[0].IsAsync() {
+		t.Fatalf("Unexpected records: %v", recs)
+	}
+	if recs[1].Type != MIRecordPrompt {
+		t.Errorf("Prompt not returned: %+v", recs[1])
+	}
+
+	// Flush returns pending partial line
+	p.Feed("Hello from inferior")
+	if r := p.Flush(); r == nil || r.Type != MIRecordUnknown || r.Raw != "Hello from inferior" {
+		t.Errorf("Unexpected flushed record: %+v", r)
+	}
+	if r := p.Flush(); r != nil {
+		t.Errorf("Nothing should be pending: %+v", r)
+	}
+}
+
+func TestParseMIRecordCString(t *testing.T) {
+	for line, exp := range map[string]string{
+		`~"tab\there"`:               "tab\there",
+		`@"quote \" backslash \\"`:   `quote " backslash \`,
+		`&"octal \101\0102 \033[0m"`: "octal A\b2 \033[0m",
+		`~"ctrl \a\b\f\v\r\e end"`:   "ctrl \a\b\f\v\r\033 end",
+		`~"unknown \q escape"`:       "unknown q escape",
+		`~""`:                        "",
+	} {
+		r := ParseMIRecord(line)
+		if !r.IsStream() || r.Stream != exp {
+			t.Errorf("ParseMIRecord(%s): type=%s stream=%q (expected %q)", line, r.Type, r.Stream, exp)
+		}
+	}
+
+	// quoting is the reverse of parsing
+	for _, s := range []string{"a\"b\\c\nd\te\r", "\x01\x7f", "plain"} {
+		r := ParseMIRecord("~" + miQuote(s))
+		if r.Stream != s {
+			t.Errorf("miQuote(%q) round trip gives %q", s, r.Stream)
+		}
+	}
+	if q := miQuote("\x01"); q != `"\001"` {
+		t.Errorf("Unexpected quoting of control char: %s", q)
+	}
+}
+
+func TestParseMIRecordNested(t *testing.T) {
+	line := `^done,stack=[frame={level="0",addr="0x1",args=[{name="argc",value="1"}]},frame={level="1",func="main"}],` +
+		`bkpt={number="1",thread-groups=["i1","i2"],empty={},none=[]}`
+	r := ParseMIRecord(line)
+	if r.Type != MIRecordResult || len(r.Results) != 2 {
+		t.Fatalf("Unexpected record: %+v", r)
+	}
+
+	stack, ok := r.Get("stack").(MIList)
+	if !ok || len(stack) != 2 {
+		t.Fatalf("Invalid stack list: %v", r.Get("stack"))
+	}
+	frame0, ok := stack[0].(MIResult)
+	if !ok || frame0.Variable != "frame" {
+		t.Fatalf("Invalid frame result: %v", stack[0])
+	}
+	tuple := frame0.Value.(MITuple)
+	if tuple.GetString("level") != "0" || tuple.GetString("addr") != "0x1" {
+		t.Errorf("Invalid frame tuple: %v", tuple)
+	}
+	args := tuple.Get("args").(MIList)
+	if len(args) != 1 || args[0].(MITuple).GetString("name") != "argc" {
+		t.Errorf("Invalid args list: %v", args)
+	}
+
+	bkpt := r.Get("bkpt").(MITuple)
+	if groups := bkpt.Get("thread-groups").(MIList); len(groups) != 2 || groups[1].(MIConst) != "i2" {
+		t.Errorf("Invalid list of constants: %v", groups)
+	}
+	if e := bkpt.Get("empty").(MITuple); len(e) != 0 {
+		t.Errorf("Invalid empty tuple: %v", e)
+	}
+	if e := bkpt.Get("none").(MIList); len(e) != 0 {
+		t.Errorf("Invalid empty list: %v", e)
+	}
+	if bkpt.Get("missing") != nil || bkpt.GetString("empty") != "" {
+		t.Errorf("Missing or non constant values must be empty")
+	}
+
+	// String re-encodes the record
+	if s := r.String(); s != line {
+		t.Errorf("Unexpected string:\n%s\n%s", s, line)
+	}
+}
+
+func TestParseMIRecordUnknown(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"Hello world",
+		"123",
+		`12~"token not allowed on stream"`,
+		`~"unterminated`,
+		`~"trailing" data`,
+		"^",
+		"^done,",
+		"^done,value",
+		`^done,value={a="1"`,
+		`^done,value=[a="1",`,
+		`*stopped,reason="x"garbage`,
+	} {
+		r := ParseMIRecord(line)
+		if r.Type != MIRecordUnknown || r.Raw != line || r.String() != line {
+			t.Errorf("ParseMIRecord(%q) should be unknown: %+v", line, r)
+		}
+	}
+	for line, typ := range map[string]MIRecordType{
+		"(gdb)":                      MIRecordPrompt,
+		"(gdb) ":                     MIRecordPrompt,
+		"*running,thread-id=\"all\"": MIRecordExec,
+		"+download":                  MIRecordStatus,
+		"^exit":                      MIRecordResult,
+	} {
+		if r := ParseMIRecord(line); r.Type != typ {
+			t.Errorf("ParseMIRecord(%q): type %s (expected %s)", line, r.Type, typ)
+		}
+	}
+}
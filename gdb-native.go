@@ -25,6 +25,8 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -42,48 +44,90 @@ type GdbNative struct {
 	exeCmd *exec.Cmd
 	fdPty  *os.File
 
+	// mkCmd creates the command used to start gdb (overloaded by GdbTemplate)
+	mkCmd func(args []string) *exec.Cmd
+
+	// inferior (debugged program) pty
+	fdInfPty *os.File
+	fdInfTty *os.File
+
+	miParser *MIParser
+
 	// callbacks
 	cbOnDisconnect func(error)
 	cbRead         func(timestamp, stdout, stderr string)
 	cbInferiorRead func(timestamp, stdout, stderr string)
 	cbOnExit       func(code int, err error)
+	cbOnMIRecord   func(rec *MIRecord)
 
+	mutex   sync.Mutex
 	running bool
 }
 
 // NewGdbNative creates a new instance of GdbNative
 func NewGdbNative(log *logrus.Logger, args []string, env []string) *GdbNative {
-	return &GdbNative{
+	g := &GdbNative{
 		log:   log,
 		ccmd:  "/usr/bin/gdb",
 		aargs: args,
 		eenv:  env,
+
+		miParser: NewMIParser(),
+	}
+	g.mkCmd = func(args []string) *exec.Cmd {
+		return exec.Command(g.ccmd, args...)
 	}
+	return g
 }
 
 // SetConfig set additional config fields
 func (g *GdbNative) SetConfig(name string, value interface{}) error {
-	return fmt.Errorf("Unknown %s field", name)
+	val, isString := value.(string)
+	if !isString {
+		return fmt.Errorf("Invalid type of %s field value (%T)", name, value)
+	}
+	switch name {
+	case "gdb":
+		if val = strings.TrimSpace(val); val != "" {
+			g.ccmd = val
+		}
+	default:
+		return fmt.Errorf("Unknown %s field", name)
+	}
+	return nil
 }
 
 // Init initializes gdb XDS
 func (g *GdbNative) Init() (int, error) {
 
 	// Create the exec command
-	g.exeCmd = exec.Command(g.ccmd, g.aargs...)
+	g.exeCmd = g.newCmd(g.aargs)
 
 	return 0, nil
 }
 
 // Close frees allocated objects and close opened connections
 func (g *GdbNative) Close() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
 	g.cbOnDisconnect = nil
 	g.cbOnExit = nil
 	g.cbRead = nil
 	g.cbInferiorRead = nil
+	g.cbOnMIRecord = nil
 
 	g.running = false
 
+	if g.fdInfPty != nil {
+		g.fdInfPty.Close()
+		g.fdInfPty = nil
+	}
+	if g.fdInfTty != nil {
+		g.fdInfTty.Close()
+		g.fdInfTty = nil
+	}
+
 	return nil
 }
 
@@ -91,19 +135,57 @@ func (g *GdbNative) Close() error {
 func (g *GdbNative) Start(inferiorTTY bool) (int, error) {
 	var err error
 
+	// Allocate a dedicated pty for the debugged program
+	if inferiorTTY {
+		if g.fdInfPty, g.fdInfTty, err = pty.Open(); err != nil {
+			return int(syscall.ESPIPE), err
+		}
+		// gdb options must precede user args (eg. --args prog arg1 arg2)
+		g.exeCmd = g.newCmd(append([]string{"--tty=" + g.fdInfTty.Name()}, g.aargs...))
+		g.log.Infof("Inferior tty: %s", g.fdInfTty.Name())
+
+		// Handle inferior STDOUT
+		go func() {
+			sc := bufio.NewScanner(g.fdInfPty)
+			sc.Split(split)
+			for sc.Scan() {
+				g.mutex.Lock()
+				cbInferiorRead, running := g.cbInferiorRead, g.running
+				g.mutex.Unlock()
+				if cbInferiorRead != nil {
+					cbInferiorRead(time.Now().String(), sc.Text(), "")
+				}
+				if !running {
+					return
+				}
+			}
+		}()
+	}
+
 	// Start pty and consequently gdb process
 	if g.fdPty, err = pty.Start(g.exeCmd); err != nil {
 		return int(syscall.ESPIPE), err
 	}
 
+	g.mutex.Lock()
 	g.running = true
+	g.mutex.Unlock()
 
 	// Monitor gdb process EOF
 	go func() {
 		// Execute command and wait EOF
-		err := g.exeCmd.Wait()
-		g.cbOnDisconnect(err)
+		code, err := exitStatus(g.exeCmd.Wait())
+		g.log.Infof("gdb exited: code=%d err=%v", code, err)
+
+		g.mutex.Lock()
 		g.running = false
+		cbOnExit, cbOnDisconnect := g.cbOnExit, g.cbOnDisconnect
+		g.mutex.Unlock()
+		if cbOnExit != nil {
+			cbOnExit(code, err)
+		} else if cbOnDisconnect != nil {
+			cbOnDisconnect(err)
+		}
 	}()
 
 	// Handle STDOUT
@@ -111,10 +193,18 @@ func (g *GdbNative) Start(inferiorTTY bool) (int, error) {
 		sc := bufio.NewScanner(g.fdPty)
 		sc.Split(split)
 		for sc.Scan() {
-			if g.cbRead != nil {
-				g.cbRead(time.Now().String(), sc.Text(), "")
+			g.mutex.Lock()
+			cbRead, cbOnMIRecord, running := g.cbRead, g.cbOnMIRecord, g.running
+			g.mutex.Unlock()
+			if cbRead != nil {
+				cbRead(time.Now().String(), sc.Text(), "")
+			}
+			if cbOnMIRecord != nil {
+				for _, rec := range g.miParser.Feed(sc.Text()) {
+					cbOnMIRecord(rec)
+				}
 			}
-			if !g.running {
+			if !running {
 				return
 			}
 		}
@@ -163,6 +253,11 @@ func (g *GdbNative) InferiorRead(f func(timestamp, stdout, stderr string)) {
 	g.cbInferiorRead = f
 }
 
+// OnMIRecord calls when a complete GDB/MI record has been parsed from gdb stdout
+func (g *GdbNative) OnMIRecord(f func(rec *MIRecord)) {
+	g.cbOnMIRecord = f
+}
+
 // Write writes message/string into gdb stdin
 func (g *GdbNative) Write(args ...interface{}) error {
 	s := fmt.Sprint(args...)
@@ -170,6 +265,16 @@ func (g *GdbNative) Write(args ...interface{}) error {
 	return err
 }
 
+// InferiorWrite writes message/string into stdin of the debugged program (IOW inferior)
+func (g *GdbNative) InferiorWrite(args ...interface{}) error {
+	if g.fdInfPty == nil {
+		return fmt.Errorf("inferior tty not allocated")
+	}
+	s := fmt.Sprint(args...)
+	_, err := g.fdInfPty.Write([]byte(s))
+	return err
+}
+
 // SendSignal is used to send a signal to remote process/gdb
 func (g *GdbNative) SendSignal(sig os.Signal) error {
 	if g.exeCmd == nil {
@@ -180,6 +285,34 @@ func (g *GdbNative) SendSignal(sig os.Signal) error {
 
 //***** Private functions *****
 
+// newCmd creates the gdb command with config env variables
+// (IOW variables passed to gdb and so to debugged program)
+func (g *GdbNative) newCmd(args []string) *exec.Cmd {
+	cmd := g.mkCmd(args)
+	cmd.Env = append(os.Environ(), g.eenv...)
+	return cmd
+}
+
+// exitStatus converts the result of exec.Cmd.Wait into an exit code
+// (error is only returned when gdb didn't exit normally)
+func exitStatus(err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return int(syscall.ECHILD), err
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok {
+		return 1, err
+	}
+	if status.Signaled() {
+		return 128 + int(status.Signal()), fmt.Errorf("gdb killed by signal %v", status.Signal())
+	}
+	return status.ExitStatus(), nil
+}
+
 func split(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	if atEOF && len(data) == 0 {
 		return 0, nil, nil
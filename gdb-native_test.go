@@ -0,0 +1,118 @@
+// +build !windows
+
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// runTestGdbNative runs a shell script in place of gdb and returns its output and exit code
+func runTestGdbNative(t *testing.T, script string, env []string, inferiorTTY bool) (string, int, error) {
+	return runTestGdbNativeCmd(t, "/bin/sh", []string{"-c", script}, env, inferiorTTY)
+}
+
+// runTestGdbNativeCmd runs cmd with args in place of gdb
+func runTestGdbNativeCmd(t *testing.T, cmd string, args []string, env []string, inferiorTTY bool) (string, int, error) {
+	tlog := logrus.New()
+	tlog.Out = ioutil.Discard
+
+	g := NewGdbNative(tlog, args, env)
+	g.ccmd = cmd
+	if _, err := g.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	var mutex sync.Mutex
+	out := ""
+	g.Read(func(timestamp, stdout, stderr string) {
+		mutex.Lock()
+		out += stdout
+		mutex.Unlock()
+	})
+	type result struct {
+		code int
+		err  error
+	}
+	exitC := make(chan result, 1)
+	g.OnExit(func(code int, err error) { exitC <- result{code, err} })
+
+	if _, err := g.Start(inferiorTTY); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer g.Close()
+
+	select {
+	case res := <-exitC:
+		// let reader goroutine flush remaining output
+		time.Sleep(100 * time.Millisecond)
+		mutex.Lock()
+		defer mutex.Unlock()
+		return out, res.code, res.err
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout while waiting exit")
+	}
+	return "", 0, nil
+}
+
+func TestGdbNativeEnvAndExitCode(t *testing.T) {
+	out, code, err := runTestGdbNative(t, "echo VAR=$XDS_TEST_VAR; exit 3", []string{"XDS_TEST_VAR=hello"}, false)
+	if !strings.Contains(out, "VAR=hello") {
+		t.Errorf("Env variable not set: %q", out)
+	}
+	if code != 3 || err != nil {
+		t.Errorf("Unexpected exit status: code=%d err=%v", code, err)
+	}
+}
+
+func TestGdbNativeKilled(t *testing.T) {
+	_, code, err := runTestGdbNative(t, "kill -TERM $$", nil, false)
+	if code != 128+15 || err == nil {
+		t.Errorf("Unexpected exit status: code=%d err=%v", code, err)
+	}
+}
+
+func TestGdbNativeInferiorTTY(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xds-gdb-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gdb := filepath.Join(dir, "gdb")
+	if err := ioutil.WriteFile(gdb, []byte("#!/bin/sh\necho \"ARGS=$*\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// gdb --tty option must reference the allocated inferior pty and
+	// precede user args, --args takes all remaining args as program args
+	out, code, _ := runTestGdbNativeCmd(t, gdb, []string{"--args", "prog", "a", "b"}, nil, true)
+	if code != 0 || !regexp.MustCompile(`ARGS=--tty=/dev/\S+ --args prog a b`).MatchString(out) {
+		t.Errorf("Inferior tty not set before user args: code=%d out=%q", code, out)
+	}
+}
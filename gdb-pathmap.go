@@ -0,0 +1,280 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PathMap - Mapping between a client path and a server path
+type PathMap struct {
+	Client string
+	Server string
+}
+
+// PathMapper - Translates paths between client and server
+// (used to rewrite gdb commands and MI output)
+type PathMapper struct {
+	maps    []PathMap
+	pending string
+}
+
+// NewPathMapper creates a new instance of PathMapper
+func NewPathMapper() *PathMapper {
+	return &PathMapper{maps: []PathMap{}}
+}
+
+// ParsePathMapList decodes a list of mappings (syntax: clientPath=serverPath,...)
+func ParsePathMapList(def string) ([]PathMap, error) {
+	maps := []PathMap{}
+	def = strings.TrimSpace(def)
+	if def == "" {
+		return maps, nil
+	}
+	for _, d := range strings.Split(def, ",") {
+		kv := strings.SplitN(d, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return maps, fmt.Errorf("Invalid path mapping definition (%s)", d)
+		}
+		maps = append(maps, PathMap{Client: strings.TrimSpace(kv[0]), Server: strings.TrimSpace(kv[1])})
+	}
+	return maps, nil
+}
+
+// Add adds a new mapping (ignored when client and server paths are identical
+// or when mapping is already defined)
+func (m *PathMapper) Add(client, server string) {
+	client = strings.TrimRight(client, "/\\")
+	server = strings.TrimRight(filepath.ToSlash(server), "/")
+	if client == "" || server == "" || filepath.ToSlash(client) == server {
+		return
+	}
+	for _, pm := range m.maps {
+		if pm.Client == client && pm.Server == server {
+			return
+		}
+	}
+	m.maps = append(m.maps, PathMap{Client: client, Server: server})
+
+	// longest client paths first to use most specific mapping in ToServer
+	sort.SliceStable(m.maps, func(i, j int) bool {
+		return len(m.maps[i].Client) > len(m.maps[j].Client)
+	})
+}
+
+// Empty returns true when no mapping is defined
+func (m *PathMapper) Empty() bool {
+	return m == nil || len(m.maps) == 0
+}
+
+// String returns the list of mappings (for debug purpose)
+func (m *PathMapper) String() string {
+	s := []string{}
+	for _, p := range m.maps {
+		s = append(s, p.Client+"="+p.Server)
+	}
+	return strings.Join(s, ",")
+}
+
+// ToServer translates a client path into a server path
+func (m *PathMapper) ToServer(p string) (string, bool) {
+	sp := filepath.ToSlash(p)
+	for _, pm := range m.maps {
+		client := filepath.ToSlash(pm.Client)
+		if rest, ok := pathTrimPrefix(sp, client); ok {
+			return pm.Server + rest, true
+		}
+	}
+	return p, false
+}
+
+// ToClient translates a server path into a client path using the most
+// specific mapping, IOW the longest matching server path (mappings are sorted
+// by client path)
+func (m *PathMapper) ToClient(p string) (string, bool) {
+	var best *PathMap
+	bestRest := ""
+	for i, pm := range m.maps {
+		if rest, ok := pathTrimPrefix(p, pm.Server); ok && (best == nil || len(pm.Server) > len(best.Server)) {
+			best = &m.maps[i]
+			bestRest = rest
+		}
+	}
+	if best == nil {
+		return p, false
+	}
+	return best.Client + filepath.FromSlash(bestRest), true
+}
+
+// CommandToServer translates client paths used in command arguments
+// (eg. -break-insert location or -file-exec-and-symbols file)
+func (m *PathMapper) CommandToServer(cmd *MICommand) bool {
+	changed := false
+	for i, a := range cmd.Args {
+		quoted := strings.HasPrefix(a, "\"")
+		arg := a
+		if quoted {
+			l := miLexer{s: a}
+			str, err := l.cstring()
+			if err != nil {
+				continue
+			}
+			arg = str
+		}
+		if sp, ok := m.ToServer(arg); ok {
+			if quoted {
+				sp = miQuote(sp)
+			}
+			cmd.Args[i] = sp
+			changed = true
+		}
+	}
+	if changed {
+		cmd.modified = true
+	}
+	return changed
+}
+
+// RecordToClient translates server paths found in a MI record
+// (IOW in all constants like file or fullname fields, and in stream records)
+func (m *PathMapper) RecordToClient(rec *MIRecord) bool {
+	changed := false
+	if rec.IsStream() {
+		rec.Stream, changed = m.streamToClient(rec.Stream)
+		return changed
+	}
+	for i := range rec.Results {
+		var c bool
+		rec.Results[i].Value, c = m.valueToClient(rec.Results[i].Value)
+		changed = changed || c
+	}
+	return changed
+}
+
+// FilterOutput translates server paths of complete MI lines of gdb output.
+// Partial lines that cannot be a MI record (eg. CLI prompts) are returned
+// unchanged, others are kept until end of line is received.
+func (m *PathMapper) FilterOutput(chunk string) string {
+	out := ""
+	m.pending += chunk
+	for {
+		idx := strings.IndexByte(m.pending, '\n')
+		if idx < 0 {
+			break
+		}
+		line := m.pending[:idx+1]
+		m.pending = m.pending[idx+1:]
+
+		eol := "\n"
+		if strings.HasSuffix(line, "\r\n") {
+			eol = "\r\n"
+		}
+		rec := ParseMIRecord(strings.TrimSuffix(line, eol))
+		if rec.Type != MIRecordUnknown && m.RecordToClient(rec) {
+			line = rec.String() + eol
+		}
+		out += line
+	}
+	if m.pending != "" && strings.IndexAny(m.pending[:1], "0123456789^*+=~@&") < 0 {
+		out += m.pending
+		m.pending = ""
+	}
+	return out
+}
+
+//***** Private functions *****
+
+func (m *PathMapper) valueToClient(v MIValue) (MIValue, bool) {
+	changed := false
+	switch val := v.(type) {
+	case MIConst:
+		if p, ok := m.toClientLocation(string(val)); ok {
+			return MIConst(p), true
+		}
+	case MIResult:
+		nv, c := m.valueToClient(val.Value)
+		return MIResult{Variable: val.Variable, Value: nv}, c
+	case MITuple:
+		for i := range val {
+			var c bool
+			val[i].Value, c = m.valueToClient(val[i].Value)
+			changed = changed || c
+		}
+	case MIList:
+		for i := range val {
+			var c bool
+			val[i], c = m.valueToClient(val[i])
+			changed = changed || c
+		}
+	}
+	return v, changed
+}
+
+// toClientLocation translates a path or a location (path:line)
+func (m *PathMapper) toClientLocation(s string) (string, bool) {
+	if !strings.HasPrefix(s, "/") {
+		return s, false
+	}
+	return m.ToClient(s)
+}
+
+// streamToClient translates server paths found in a text (IOW words
+// starting with a server path followed by a path separator or a location)
+func (m *PathMapper) streamToClient(s string) (string, bool) {
+	var out bytes.Buffer
+	changed := false
+	for i := 0; i < len(s); {
+		if s[i] != '/' || (i > 0 && strings.IndexByte(pathDelimiters, s[i-1]) < 0) {
+			out.WriteByte(s[i])
+			i++
+			continue
+		}
+		end := strings.IndexAny(s[i:], pathDelimiters)
+		if end < 0 {
+			end = len(s) - i
+		}
+		word := s[i : i+end]
+		if p, ok := m.ToClient(word); ok {
+			word = p
+			changed = true
+		}
+		out.WriteString(word)
+		i += end
+	}
+	return out.String(), changed
+}
+
+// Characters that end a path in a text
+const pathDelimiters = " \t\r\n\"'`,;()[]<>{}="
+
+// pathTrimPrefix returns the remaining part of p when p is prefix or
+// located under prefix directory
+func pathTrimPrefix(p, prefix string) (string, bool) {
+	if p == prefix {
+		return "", true
+	}
+	if strings.HasPrefix(p, prefix) && (p[len(prefix)] == '/' || p[len(prefix)] == ':') {
+		return p[len(prefix):], true
+	}
+	return "", false
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+func newTestPathMapper() *PathMapper {
+	m := NewPathMapper()
+	m.Add("/home/user/prj/", "/home/devel/prj")
+	m.Add("/home/user/prj/lib", "/opt/lib")
+	m.Add("/same", "/same")
+	return m
+}
+
+func TestPathMapperAdd(t *testing.T) {
+	m := newTestPathMapper()
+	if s := m.String(); s != "/home/user/prj/lib=/opt/lib,/home/user/prj=/home/devel/prj" {
+		t.Errorf("Unexpected mappings: %s", s)
+	}
+
+	// gdb start adds project mapping again on each start
+	m.Add("/home/user/prj", "/home/devel/prj/")
+	m.Add("/home/user/prj", "/home/devel/prj")
+	if s := m.String(); s != "/home/user/prj/lib=/opt/lib,/home/user/prj=/home/devel/prj" {
+		t.Errorf("Duplicated mappings: %s", s)
+	}
+	if !NewPathMapper().Empty() || m.Empty() {
+		t.Errorf("Unexpected Empty result")
+	}
+}
+
+func TestPathMapperToServer(t *testing.T) {
+	m := newTestPathMapper()
+	for p, exp := range map[string]string{
+		"/home/user/prj":            "/home/devel/prj",
+		"/home/user/prj/main.c":     "/home/devel/prj/main.c",
+		"/home/user/prj/main.c:10":  "/home/devel/prj/main.c:10",
+		"/home/user/prj:10":         "/home/devel/prj:10",
+		"/home/user/prj/lib/a.c":    "/opt/lib/a.c",
+		"/home/user/prj2/main.c":    "",
+		"/home/user/project/main.c": "",
+		"main.c":                    "",
+	} {
+		sp, ok := m.ToServer(p)
+		if ok != (exp != "") || (ok && sp != exp) {
+			t.Errorf("ToServer(%s) = %s, %v (expected '%s')", p, sp, ok, exp)
+		}
+	}
+}
+
+func TestPathMapperToClient(t *testing.T) {
+	m := newTestPathMapper()
+	for p, exp := range map[string]string{
+		"/home/devel/prj/main.c":    "/home/user/prj/main.c",
+		"/home/devel/prj/main.c:10": "/home/user/prj/main.c:10",
+		"/opt/lib":                  "/home/user/prj/lib",
+		"/opt/library/a.c":          "",
+		"/home/devel/prj-old/a.c":   "",
+	} {
+		cp, ok := m.ToClient(p)
+		if ok != (exp != "") || (ok && cp != exp) {
+			t.Errorf("ToClient(%s) = %s, %v (expected '%s')", p, cp, ok, exp)
+		}
+	}
+
+	// nested server paths: longest server prefix is used whatever client
+	// paths length
+	m = &PathMapper{}
+	m.Add("/home/user/a", "/srv")
+	m.Add("/b", "/srv/x")
+	for p, exp := range map[string]string{
+		"/srv/x/f.c":  "/b/f.c",
+		"/srv/xy/f.c": "/home/user/a/xy/f.c",
+		"/srv/f.c":    "/home/user/a/f.c",
+	} {
+		if cp, ok := m.ToClient(p); !ok || cp != exp {
+			t.Errorf("ToClient(%s) = %s, %v (expected '%s')", p, cp, ok, exp)
+		}
+	}
+}
+
+func TestPathMapperCommandToServer(t *testing.T) {
+	m := newTestPathMapper()
+	cmd := ParseMICommand(`12-break-insert -f "/home/user/prj/my main.c:10"`)
+	if !m.CommandToServer(cmd) || cmd.String() != `12-break-insert -f "/home/devel/prj/my main.c:10"` {
+		t.Errorf("Unexpected translated command: %s", cmd.String())
+	}
+	cmd = ParseMICommand(`-file-exec-and-symbols /home/user/prj2/hello`)
+	if m.CommandToServer(cmd) || cmd.String() != `-file-exec-and-symbols /home/user/prj2/hello` {
+		t.Errorf("Command must not be translated: %s", cmd.String())
+	}
+}
+
+func TestPathMapperFilterOutput(t *testing.T) {
+	m := newTestPathMapper()
+	for in, exp := range map[string]string{
+		"*stopped,reason=\"breakpoint-hit\",frame={func=\"main\",file=\"main.c\",fullname=\"/home/devel/prj/main.c\",line=\"10\"}\n": "*stopped,reason=\"breakpoint-hit\",frame={func=\"main\",file=\"main.c\",fullname=\"/home/user/prj/main.c\",line=\"10\"}\n",
+		"^done,files=[{file=\"a.c\",fullname=\"/opt/lib/a.c\"},{file=\"b.c\",fullname=\"/opt/libs/b.c\"}]\r\n":                       "^done,files=[{file=\"a.c\",fullname=\"/home/user/prj/lib/a.c\"},{file=\"b.c\",fullname=\"/opt/libs/b.c\"}]\r\n",
+		"~\"Breakpoint 1 at 0x400526: file /home/devel/prj/main.c, line 10.\\n\"\n":                                                  "~\"Breakpoint 1 at 0x400526: file /home/user/prj/main.c, line 10.\\n\"\n",
+		"~\"Source directories searched: /home/devel/prj:/home/devel/prj2:$cdir\\n\"\n":                                              "~\"Source directories searched: /home/user/prj:/home/devel/prj2:$cdir\\n\"\n",
+		"~\"/home/devel/project/x.c and /usr/home/devel/prj/y.c\\n\"\n":                                                              "~\"/home/devel/project/x.c and /usr/home/devel/prj/y.c\\n\"\n",
+		"(gdb) ": "(gdb) ",
+	} {
+		if out := m.FilterOutput(in); out != exp {
+			t.Errorf("FilterOutput:\n%q\nexpected:\n%q", out, exp)
+		}
+	}
+}
+
+func TestPathMapperFilterOutputChunks(t *testing.T) {
+	m := newTestPathMapper()
+	out := m.FilterOutput("=library-loaded,id=\"/opt/li")
+	if out != "" {
+		t.Errorf("Partial MI line must be kept: %q", out)
+	}
+	out += m.FilterOutput("b/libfoo.so\"\n^done\n")
+	if out != "=library-loaded,id=\"/home/user/prj/lib/libfoo.so\"\n^done\n" {
+		t.Errorf("Unexpected output: %q", out)
+	}
+}
@@ -0,0 +1,155 @@
+// +build !windows
+
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// GdbTemplate - Implementation of IGDB used to run gdb through a user defined
+// command (eg. ssh, container or chroot). Placeholders of command template:
+//
+//	{gdb}  : gdb binary (default: gdb)
+//	{args} : gdb arguments
+//	{env}  : config env variables (VAR=value ...), eg. used as: env {env} {gdb} {args}
+//	{cwd}  : working directory (default: current directory)
+//
+// Values are quoted for shell and command is executed by /bin/sh.
+type GdbTemplate struct {
+	*GdbNative
+	template string
+	cwd      string
+}
+
+// Placeholders supported in command template
+var gdbTemplatePlaceholders = []string{"{gdb}", "{args}", "{env}", "{cwd}"}
+
+// NewGdbTemplate creates a new instance of GdbTemplate
+func NewGdbTemplate(log *logrus.Logger, args []string, env []string) *GdbTemplate {
+	g := &GdbTemplate{
+		GdbNative: NewGdbNative(log, args, env),
+	}
+	g.ccmd = "gdb"
+	g.cwd, _ = os.Getwd()
+	g.mkCmd = g.templateCmd
+	return g
+}
+
+// SetConfig set additional config fields
+func (g *GdbTemplate) SetConfig(name string, value interface{}) error {
+	val, isString := value.(string)
+	if !isString {
+		return fmt.Errorf("Invalid type of %s field value (%T)", name, value)
+	}
+	val = strings.TrimSpace(val)
+	switch name {
+	case "template":
+		g.template = val
+	case "gdb":
+		if val != "" {
+			g.ccmd = val
+		}
+	case "cwd":
+		if val != "" {
+			g.cwd = val
+		}
+	default:
+		return fmt.Errorf("Unknown %s field", name)
+	}
+	return nil
+}
+
+// Init checks command template and initializes gdb command
+func (g *GdbTemplate) Init() (int, error) {
+	if g.template == "" {
+		return int(syscall.EINVAL), fmt.Errorf("gdb command template not set")
+	}
+	// gdb arguments (IOW --interpreter, --tty, -x options) cannot be dropped
+	if !strings.Contains(g.template, "{args}") {
+		return int(syscall.EINVAL), fmt.Errorf("gdb command template must contain {args} placeholder (%s)", g.template)
+	}
+	// (shell variables like ${var} are not placeholders)
+	for _, m := range regexp.MustCompile(`(?:^|[^$])({[a-z]+})`).FindAllStringSubmatch(g.template, -1) {
+		if !isTemplatePlaceholder(m[1]) {
+			return int(syscall.EINVAL), fmt.Errorf("Unknown placeholder %s in gdb command template (supported: %s)",
+				m[1], strings.Join(gdbTemplatePlaceholders, ", "))
+		}
+	}
+
+	code, err := g.GdbNative.Init()
+	if err == nil {
+		g.log.Infof("gdb command: %v", g.exeCmd.Args)
+	}
+	return code, err
+}
+
+// ExpandTemplate returns the command line built from template
+func (g *GdbTemplate) ExpandTemplate(args []string) string {
+	qArgs := []string{}
+	for _, a := range args {
+		if a != "" {
+			qArgs = append(qArgs, shellQuote(a))
+		}
+	}
+	qEnv := []string{}
+	for _, e := range g.eenv {
+		if kv := strings.SplitN(e, "=", 2); len(kv) == 2 {
+			qEnv = append(qEnv, kv[0]+"="+shellQuote(kv[1]))
+		}
+	}
+
+	return strings.NewReplacer(
+		"{gdb}", shellQuote(g.ccmd),
+		"{args}", strings.Join(qArgs, " "),
+		"{env}", strings.Join(qEnv, " "),
+		"{cwd}", shellQuote(g.cwd),
+	).Replace(g.template)
+}
+
+//***** Private functions *****
+
+func (g *GdbTemplate) templateCmd(args []string) *exec.Cmd {
+	return exec.Command("/bin/sh", "-c", g.ExpandTemplate(args))
+}
+
+func isTemplatePlaceholder(p string) bool {
+	for _, tp := range gdbTemplatePlaceholders {
+		if p == tp {
+			return true
+		}
+	}
+	return false
+}
+
+// shellQuote quotes a string for /bin/sh (unchanged when quoting is not needed)
+func shellQuote(s string) string {
+	if s != "" && regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`).MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,105 @@
+// +build !windows
+
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func newTestGdbTemplate(t *testing.T, template string, args, env []string) *GdbTemplate {
+	tlog := logrus.New()
+	tlog.Out = ioutil.Discard
+
+	g := NewGdbTemplate(tlog, args, env)
+	g.SetConfig("template", template)
+	g.SetConfig("cwd", "/home/user/my prj")
+	return g
+}
+
+func TestGdbTemplateExpand(t *testing.T) {
+	g := newTestGdbTemplate(t, "ssh board \"cd {cwd} && env {env} {gdb} {args}\"",
+		[]string{"--interpreter=mi2", "", "-x", "it's.ini"}, []string{"VAR=a b", "EMPTY="})
+	g.SetConfig("gdb", "aarch64-linux-gdb")
+
+	exp := `ssh board "cd '/home/user/my prj' && env VAR='a b' EMPTY='' aarch64-linux-gdb --interpreter=mi2 -x 'it'\''s.ini'"`
+	if cmd := g.ExpandTemplate(g.Args()); cmd != exp {
+		t.Errorf("Invalid expanded command:\n got: %s\n exp: %s", cmd, exp)
+	}
+}
+
+func TestGdbTemplateInvalid(t *testing.T) {
+	for _, tmpl := range []string{"", "{gdb}", "{gdb} {args} {unknown}"} {
+		g := newTestGdbTemplate(t, tmpl, nil, nil)
+		if _, err := g.Init(); err == nil {
+			t.Errorf("Template '%s' must be rejected", tmpl)
+		}
+	}
+	g := newTestGdbTemplate(t, "${SHELL:-sh} -c 'echo' {args}", nil, nil)
+	if _, err := g.Init(); err != nil {
+		t.Errorf("Shell variables must be accepted: %v", err)
+	}
+}
+
+func TestGdbTemplateRun(t *testing.T) {
+	g := newTestGdbTemplate(t, "env {env} {gdb} -c 'echo $VAR \"$@\"; exit 5' sh {args}",
+		[]string{"--interpreter=mi2", "a b"}, []string{"VAR=hello"})
+	g.SetConfig("gdb", "/bin/sh")
+	if _, err := g.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	var mutex sync.Mutex
+	out := ""
+	g.Read(func(timestamp, stdout, stderr string) {
+		mutex.Lock()
+		out += stdout
+		mutex.Unlock()
+	})
+	exitC := make(chan int, 1)
+	g.OnExit(func(code int, err error) { exitC <- code })
+
+	if _, err := g.Start(true); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer g.Close()
+
+	select {
+	case code := <-exitC:
+		if code != 5 {
+			t.Errorf("Unexpected exit code: %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout while waiting exit")
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !regexp.MustCompile(`hello --tty=/dev/\S+ --interpreter=mi2 a b`).MatchString(out) {
+		t.Errorf("Unexpected output: %q", out)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+
+	common "github.com/iotbzh/xds-common/golib"
+)
+
+// Name of default token file (within user config directory)
+const xdsAgentTokenFile = "agent-token"
+
+// Max duration of token credential helper command
+const tokenHelperTimeout = 10 * time.Second
+
+// HTTP header used to send token to xds-agent (HTTP requests and io.socket handshake)
+const agentAuthHeader = "Authorization"
+
+// agentAuth - Sources of the token used to authenticate on xds-agent, by
+// precedence order: token value, token file, credential helper command, then
+// default token file of user config directory
+type agentAuth struct {
+	token  string
+	file   string
+	helper string
+
+	source string // where the token comes from (used in error messages)
+}
+
+// load returns the token (empty when no token is defined)
+func (a *agentAuth) load() (string, error) {
+	switch {
+	case a.token != "":
+		a.source = "XDS_AGENT_TOKEN"
+		return a.token, nil
+	case a.file != "":
+		a.source = "token file " + a.file
+		return readTokenFile(a.file)
+	case a.helper != "":
+		a.source = "credential helper '" + a.helper + "'"
+		return runTokenHelper(a.helper)
+	}
+	if f := path.Join(configUserDir(), xdsAgentTokenFile); common.Exists(f) {
+		a.source = "token file " + f
+		return readTokenFile(f)
+	}
+	return "", nil
+}
+
+// header returns the value of authentication header
+func (a *agentAuth) header(token string) string {
+	return "Bearer " + token
+}
+
+// authError returns an authentication error (IOW token is missing or rejected)
+func (a *agentAuth) authError(url string, err error) error {
+	if a.source == "" {
+		return fmt.Errorf("Authentication required by XDS agent %s (%v): set XDS_AGENT_TOKEN, XDS_AGENT_TOKEN_FILE or XDS_AGENT_TOKEN_HELPER", url, err)
+	}
+	return fmt.Errorf("Authentication on XDS agent %s failed using token of %s (%v)", url, a.source, err)
+}
+
+// isAuthError returns true when err is an HTTP authentication failure
+// (401 Unauthorized or 403 Forbidden)
+func isAuthError(err error) bool {
+	code := httpStatus(err)
+	return code == http.StatusUnauthorized || code == http.StatusForbidden
+}
+
+//***** Private functions *****
+
+// readTokenFile reads token from a file that must only be accessible by owner
+func readTokenFile(file string) (string, error) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return "", fmt.Errorf("Cannot read token file: %v", err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("Permissions %04o of token file %s are too open, it must only be accessible by owner (eg. chmod 600 %s)",
+			fi.Mode().Perm(), file, file)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("Cannot read token file: %v", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("Token file %s is empty", file)
+	}
+	return token, nil
+}
+
+// runTokenHelper runs credential helper command and returns the token printed
+// on its first output line
+func runTokenHelper(helper string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenHelperTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", helper)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", helper)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("Credential helper '%s' timeout (%v)", helper, tokenHelperTimeout)
+	}
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("Credential helper '%s' failed: %s", helper, msg)
+	}
+	token := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	if token == "" {
+		return "", fmt.Errorf("Credential helper '%s' returned an empty token", helper)
+	}
+	return token, nil
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestGdbXdsAuthToken(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "xds-gdb-test")
+	defer os.RemoveAll(dir)
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	os.Setenv("XDG_CONFIG_HOME", dir)
+
+	a := newFakeAgent(t)
+	defer a.Close()
+	a.RequireToken("s3cret")
+
+	tokenFile := writeTestFile(t, dir, "token", "s3cret\n")
+	os.MkdirAll(path.Join(dir, "xds"), 0700)
+	writeTestFile(t, dir, "xds/"+xdsAgentTokenFile, "s3cret")
+
+	for _, conf := range []map[string]string{
+		{"agentToken": "s3cret"},
+		{"agentTokenFile": tokenFile},
+		{"agentTokenHelper": "echo s3cret; echo other"},
+		{}, // default token file of user config directory
+	} {
+		g := newTestGdbXds(t, a, conf)
+		if code, err := g.Init(); code != 0 || err != nil {
+			t.Errorf("Init failed with %v: code=%d err=%v", conf, code, err)
+			continue
+		}
+		so := a.WaitSocket()
+		if h := so.Request().Header.Get("Authorization"); h != "Bearer s3cret" {
+			t.Errorf("Invalid io.socket authorization header with %v: %q", conf, h)
+		}
+	}
+
+	os.Remove(path.Join(dir, "xds", xdsAgentTokenFile))
+	for _, tc := range []struct {
+		conf map[string]string
+		code int
+		err  string
+	}{
+		{map[string]string{}, int(syscall.EACCES), "Authentication required by XDS agent " + a.URL()},
+		{map[string]string{"agentToken": "wrong"}, int(syscall.EACCES), "failed using token of XDS_AGENT_TOKEN"},
+		{map[string]string{"agentTokenHelper": "echo wrong"}, int(syscall.EACCES), "failed using token of credential helper 'echo wrong'"},
+		{map[string]string{"agentTokenHelper": "echo oops >&2; exit 1"}, int(syscall.EACCES), "Credential helper 'echo oops >&2; exit 1' failed: oops"},
+		{map[string]string{"agentTokenFile": path.Join(dir, "missing")}, int(syscall.EACCES), "Cannot read token file"},
+	} {
+		g := newTestGdbXds(t, a, tc.conf)
+		if code, err := g.Init(); code != tc.code || err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("Unexpected result with %v: code=%d err=%v", tc.conf, code, err)
+		}
+	}
+
+	// connection failure is not reported as an authentication error
+	g := newTestGdbXds(t, a, map[string]string{"agentToken": "s3cret", "agentURL": "http://localhost:1"})
+	if code, err := g.Init(); code != int(syscallEBADE) || err == nil || isAuthError(err) {
+		t.Errorf("Unexpected result on connection failure: code=%d err=%v", code, err)
+	}
+}
+
+func TestReadTokenFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "xds-gdb-test")
+	defer os.RemoveAll(dir)
+
+	file := writeTestFile(t, dir, "token", "  abc\n")
+	if token, err := readTokenFile(file); err != nil || token != "abc" {
+		t.Errorf("readTokenFile failed: token=%q err=%v", token, err)
+	}
+	for mode, exp := range map[os.FileMode]string{0640: "0640", 0604: "0604"} {
+		os.Chmod(file, mode)
+		if _, err := readTokenFile(file); err == nil || !strings.Contains(err.Error(), "Permissions "+exp) {
+			t.Errorf("Too open permissions %04o not reported: %v", mode, err)
+		}
+	}
+	empty := writeTestFile(t, dir, "empty", "\n")
+	if _, err := readTokenFile(empty); err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Errorf("Empty token file not reported: %v", err)
+	}
+}
+
+func TestIsAuthError(t *testing.T) {
+	for _, tc := range []struct {
+		err error
+		exp bool
+	}{
+		{&httpStatusError{code: 401, msg: "HTTP status 401 Unauthorized: Invalid token"}, true},
+		{&httpStatusError{code: 403, msg: "HTTP status 403 Forbidden"}, true},
+		{&httpStatusError{code: 500, msg: "HTTP status 500 Internal Server Error: 401"}, false},
+		{fmt.Errorf("HTTP status 401 Unauthorized"), false},
+		{nil, false},
+	} {
+		if isAuthError(tc.err) != tc.exp {
+			t.Errorf("isAuthError(%v) != %v", tc.err, tc.exp)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"debug/elf"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+// ElfInfo - Target description read from ELF header of debugged program
+type ElfInfo struct {
+	Machine   elf.Machine
+	Class     elf.Class
+	OSABI     elf.OSABI
+	HardFloat bool // ARM hard-float ABI (EF_ARM_ABI_FLOAT_HARD flag)
+}
+
+// ARM specific ELF header flags
+const elfARMFloatHard = 0x400
+
+// SDK arch name prefixes for each ELF machine
+var elfSdkArchs = map[elf.Machine][]string{
+	elf.EM_AARCH64: {"aarch64", "arm64", "cortexa53", "cortexa57", "cortexa72"},
+	elf.EM_X86_64:  {"x86_64", "x86-64", "amd64", "corei7-64", "core2-64"},
+	elf.EM_386:     {"i386", "i486", "i586", "i686", "x86", "core2-32", "corei7-32"},
+	elf.EM_ARM:     {"arm", "cortexa", "cortexm"},
+	elf.EM_MIPS:    {"mips"},
+	elf.EM_PPC:     {"ppc", "powerpc"},
+	elf.EM_PPC64:   {"ppc64", "powerpc64"},
+}
+
+// gdb options followed by a value (long options may start by - or --)
+var gdbOptsWithValue = map[string]bool{
+	"-x": true, "-command": true, "-ex": true, "-eval-command": true,
+	"-ix": true, "-init-command": true, "-iex": true, "-init-eval-command": true,
+	"-cd": true, "-d": true, "-directory": true, "-data-directory": true,
+	"-s": true, "-symbols": true, "-c": true, "-core": true, "-p": true, "-pid": true,
+	"-b": true, "-D": true, "-l": true, "-tty": true,
+}
+
+// readElfInfo reads target description from ELF header of file
+func readElfInfo(file string) (*ElfInfo, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	f, err := elf.NewFile(fd)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not an ELF file: %v", file, err)
+	}
+	info := &ElfInfo{
+		Machine: f.Machine,
+		Class:   f.Class,
+		OSABI:   f.OSABI,
+	}
+
+	// e_flags is not exposed by debug/elf, read it from 32 bits header
+	if f.Machine == elf.EM_ARM && f.Class == elf.ELFCLASS32 {
+		buf := make([]byte, 4)
+		if _, err := fd.ReadAt(buf, 36); err == nil {
+			info.HardFloat = f.ByteOrder.Uint32(buf)&elfARMFloatHard != 0
+		}
+	}
+	return info, nil
+}
+
+// String returns a human readable description of ELF target
+func (e *ElfInfo) String() string {
+	desc := fmt.Sprintf("%s, %s, %s", e.Machine, e.Class, e.OSABI)
+	if e.Machine == elf.EM_ARM {
+		if e.HardFloat {
+			desc += ", hard-float"
+		} else {
+			desc += ", soft-float"
+		}
+	}
+	return desc
+}
+
+// matchSdk returns true when SDK arch (or name when arch is not set) targets
+// the same machine and ABI
+func (e *ElfInfo) matchSdk(s xaapiv1.SDK) bool {
+	archs := []string{s.Arch}
+	if s.Arch == "" {
+		archs = strings.FieldsFunc(s.Name, func(r rune) bool {
+			return r == '_' || r == ' '
+		})
+	}
+	for _, a := range archs {
+		a = strings.ToLower(a)
+		if sdkArchMachine(a) != e.Machine {
+			continue
+		}
+		switch e.Machine {
+		case elf.EM_ARM:
+			if e.HardFloat != strings.Contains(a, "hf") {
+				continue
+			}
+		case elf.EM_MIPS:
+			if (e.Class == elf.ELFCLASS64) != strings.HasPrefix(a, "mips64") {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// findGdbProgram returns the program to debug set in gdb arguments or by
+// file command of gdb command files
+func findGdbProgram(args []string) string {
+	cmdFiles := []string{}
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "" {
+			continue
+		}
+		if !strings.HasPrefix(a, "-") {
+			return a
+		}
+		opt, val := "-"+strings.TrimLeft(a, "-"), ""
+		if n := strings.Index(opt, "="); n != -1 {
+			opt, val = opt[:n], opt[n+1:]
+		} else if gdbOptsWithValue[opt] || opt == "-e" || opt == "-exec" || opt == "-se" {
+			if i+1 < len(args) {
+				val = args[i+1]
+				i++
+			}
+		}
+		switch opt {
+		case "-args":
+			if i+1 < len(args) {
+				return args[i+1]
+			}
+		case "-e", "-exec", "-se":
+			if val != "" {
+				return val
+			}
+		case "-x", "-command":
+			cmdFiles = append(cmdFiles, val)
+		}
+	}
+
+	for _, f := range cmdFiles {
+		if prog := cmdFileProgram(f); prog != "" {
+			return prog
+		}
+	}
+	return ""
+}
+
+// autoSelectSdk selects the SDK to use when XDS_SDK_ID is not set: default
+// SDK of project when it matches debugged program, else the newest SDK
+// matching ELF header of debugged program.
+// Returns nil when no SDK can be selected, else selected SDK and reason.
+func (g *GdbXds) autoSelectSdk(sdks []xaapiv1.SDK) (*xaapiv1.SDK, string) {
+	var info *ElfInfo
+	prog := findGdbProgram(g.aargs)
+	if prog != "" {
+		var err error
+		if info, err = readElfInfo(prog); err != nil {
+			g.log.Infof("Cannot detect target of program %s: %v", prog, err)
+		} else {
+			g.log.Infof("Program %s targets %s", prog, info)
+		}
+	}
+
+	if g.project != nil && g.project.DefaultSdk != "" {
+		for i, s := range sdks {
+			if s.ID != g.project.DefaultSdk {
+				continue
+			}
+			if info == nil {
+				return &sdks[i], fmt.Sprintf("default SDK of project %s", g.project.Label)
+			}
+			if info.matchSdk(s) {
+				return &sdks[i], fmt.Sprintf("default SDK of project %s, matching program %s (%s)",
+					g.project.Label, prog, info)
+			}
+			g.log.Warnf("Default SDK %s of project %s doesn't match program %s (%s)", s.Name, g.project.Label, prog, info)
+		}
+	}
+	if info == nil {
+		return nil, ""
+	}
+
+	matches := []int{}
+	for i, s := range sdks {
+		if info.matchSdk(s) {
+			matches = append(matches, i)
+		}
+	}
+	if len(matches) == 0 {
+		g.log.Warnf("No installed SDK matches program %s (%s)", prog, info)
+		return nil, ""
+	}
+	best := matches[0]
+	for _, i := range matches[1:] {
+		if compareVersions(sdks[i].Version, sdks[best].Version) > 0 {
+			best = i
+		}
+	}
+	why := fmt.Sprintf("matching program %s (%s)", prog, info)
+	if len(matches) > 1 {
+		names := []string{}
+		for _, i := range matches {
+			names = append(names, sdks[i].Name)
+		}
+		why += fmt.Sprintf(", newest version (%s) among matching SDKs: %s", sdks[best].Version, strings.Join(names, ", "))
+	}
+	return &sdks[best], why
+}
+
+// compareVersions compares 2 versions (eg. 4.0.1 and 3.99.1+snapshot) and
+// returns -1, 0 or 1 when a is older, equal or newer than b
+func compareVersions(a, b string) int {
+	split := func(v string) []string {
+		return strings.FieldsFunc(v, func(r rune) bool {
+			return r == '.' || r == '+' || r == '-' || r == '_'
+		})
+	}
+	va, vb := split(a), split(b)
+	for i := 0; i < len(va) && i < len(vb); i++ {
+		na, errA := strconv.Atoi(va[i])
+		nb, errB := strconv.Atoi(vb[i])
+		switch {
+		case errA == nil && errB == nil && na != nb:
+			if na > nb {
+				return 1
+			}
+			return -1
+		case (errA != nil || errB != nil) && va[i] != vb[i]:
+			// numeric part is newer than a suffix (eg. 4.0.1 > 4.0+snapshot)
+			if errA == nil {
+				return 1
+			}
+			if errB == nil {
+				return -1
+			}
+			if va[i] > vb[i] {
+				return 1
+			}
+			return -1
+		}
+	}
+	switch {
+	case len(va) > len(vb):
+		return 1
+	case len(va) < len(vb):
+		return -1
+	}
+	return 0
+}
+
+//***** Private functions *****
+
+// sdkArchMachine returns ELF machine targeted by SDK arch (longest matching
+// prefix, IOW x86-64 is not a x86 arch), or EM_NONE when unknown
+func sdkArchMachine(arch string) elf.Machine {
+	machine, length := elf.EM_NONE, 0
+	for m, prefixes := range elfSdkArchs {
+		for _, p := range prefixes {
+			if strings.HasPrefix(arch, p) && len(p) > length {
+				machine, length = m, len(p)
+			}
+		}
+	}
+	return machine
+}
+
+// cmdFileProgram returns program set by file or exec-file command of a gdb
+// command file
+func cmdFileProgram(file string) string {
+	fd, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer fd.Close()
+
+	sc := bufio.NewScanner(fd)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && (fields[0] == "file" || fields[0] == "exec-file") {
+			return fields[1]
+		}
+	}
+	return ""
+}
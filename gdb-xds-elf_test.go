@@ -0,0 +1,210 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+// writeTestElf writes a minimal little endian ELF header (without sections)
+func writeTestElf(t *testing.T, file string, class elf.Class, machine elf.Machine, flags uint32) {
+	size, flagsOff := 52, 36
+	if class == elf.ELFCLASS64 {
+		size, flagsOff = 64, 48
+	}
+	hdr := make([]byte, size)
+	copy(hdr, elf.ELFMAG)
+	hdr[elf.EI_CLASS] = byte(class)
+	hdr[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	hdr[elf.EI_OSABI] = byte(elf.ELFOSABI_LINUX)
+	binary.LittleEndian.PutUint16(hdr[16:], uint16(elf.ET_EXEC))
+	binary.LittleEndian.PutUint16(hdr[18:], uint16(machine))
+	binary.LittleEndian.PutUint32(hdr[20:], uint32(elf.EV_CURRENT))
+	binary.LittleEndian.PutUint32(hdr[flagsOff:], flags)
+	binary.LittleEndian.PutUint16(hdr[flagsOff+4:], uint16(size))
+	if err := ioutil.WriteFile(file, hdr, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadElfInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xds-gdb-elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sdks := []xaapiv1.SDK{
+		{Name: "aarch64", Arch: "aarch64"},
+		{Name: "corei7-64", Arch: "corei7-64"},
+		{Name: "i586", Arch: "i586"},
+		{Name: "armv7vehf", Arch: "armv7vehf-neon-vfpv4"},
+		{Name: "armv5e", Arch: "armv5e"},
+		{Name: "poky-agl_x86-64_4.0.1"},
+	}
+	for _, tc := range []struct {
+		class   elf.Class
+		machine elf.Machine
+		flags   uint32
+		desc    string
+		match   []string
+	}{
+		{elf.ELFCLASS64, elf.EM_AARCH64, 0, "EM_AARCH64, ELFCLASS64, ELFOSABI_LINUX", []string{"aarch64"}},
+		{elf.ELFCLASS64, elf.EM_X86_64, 0, "EM_X86_64, ELFCLASS64, ELFOSABI_LINUX", []string{"corei7-64", "poky-agl_x86-64_4.0.1"}},
+		{elf.ELFCLASS32, elf.EM_386, 0, "EM_386, ELFCLASS32, ELFOSABI_LINUX", []string{"i586"}},
+		{elf.ELFCLASS32, elf.EM_ARM, 0x05000400, "EM_ARM, ELFCLASS32, ELFOSABI_LINUX, hard-float", []string{"armv7vehf"}},
+		{elf.ELFCLASS32, elf.EM_ARM, 0x05000200, "EM_ARM, ELFCLASS32, ELFOSABI_LINUX, soft-float", []string{"armv5e"}},
+	} {
+		file := filepath.Join(dir, "prog")
+		writeTestElf(t, file, tc.class, tc.machine, tc.flags)
+		info, err := readElfInfo(file)
+		if err != nil {
+			t.Fatalf("readElfInfo %s: %v", tc.desc, err)
+		}
+		if info.String() != tc.desc {
+			t.Errorf("Invalid ELF info: got %q, expected %q", info.String(), tc.desc)
+		}
+		match := []string{}
+		for _, s := range sdks {
+			if info.matchSdk(s) {
+				match = append(match, s.Name)
+			}
+		}
+		if len(match) != len(tc.match) || (len(match) > 0 && match[0] != tc.match[0]) {
+			t.Errorf("Invalid SDKs matching %s: %v (expected %v)", tc.desc, match, tc.match)
+		}
+	}
+
+	if _, err := readElfInfo("gdb-xds-elf_test.go"); err == nil {
+		t.Errorf("readElfInfo should fail on non ELF file")
+	}
+}
+
+func TestFindGdbProgram(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xds-gdb-elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cmdFile := filepath.Join(dir, "gdb.ini")
+	if err := ioutil.WriteFile(cmdFile, []byte("# :XDS-ENV: XDS_SDK_ID=x\nset pagination off\nfile build/myprog\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		args []string
+		prog string
+	}{
+		{[]string{"--interpreter=mi2", "helloworld"}, "helloworld"},
+		{[]string{"-x", cmdFile, "-ex", "run", "prog"}, "prog"},
+		{[]string{"", "-q", "--args", "prog", "arg1"}, "prog"},
+		{[]string{"--exec=prog", "core"}, "prog"},
+		{[]string{"-se", "prog"}, "prog"},
+		{[]string{"--command", cmdFile}, "build/myprog"},
+		{[]string{"-x", cmdFile + ".unknown"}, ""},
+		{[]string{"--interpreter=mi2"}, ""},
+	} {
+		if prog := findGdbProgram(tc.args); prog != tc.prog {
+			t.Errorf("Invalid program found in %v: %q (expected %q)", tc.args, prog, tc.prog)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		exp  int
+	}{
+		{"4.0.1", "4.0.1", 0},
+		{"5.0.0", "4.0.1", 1},
+		{"3.99.1+snapshot", "4.0.1", -1},
+		{"4.0.10", "4.0.9", 1},
+		{"4.0", "4.0.1", -1},
+		{"4.0.1", "4.0+snapshot", 1},
+		{"", "1.0", -1},
+	} {
+		if res := compareVersions(tc.a, tc.b); res != tc.exp {
+			t.Errorf("compareVersions(%q, %q) = %d, expected %d", tc.a, tc.b, res, tc.exp)
+		}
+	}
+}
+
+func TestGdbXdsAutoSelectSdk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xds-gdb-elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	prog := filepath.Join(dir, "prog")
+	writeTestElf(t, prog, elf.ELFCLASS64, elf.EM_AARCH64, 0)
+
+	a := newFakeAgent(t)
+	defer a.Close()
+	a.sdks[0][0].Version = "4.0.1"
+	a.sdks[0][1].Version = "6.0.0"
+	a.sdks[0] = append(a.sdks[0],
+		xaapiv1.SDK{ID: "ef23c1b7-0000", Name: "poky-agl_aarch64_5.0.0", Arch: "aarch64", Version: "5.0.0"})
+
+	initGdb := func(sdkID string, args ...string) *GdbXds {
+		g := newTestGdbXds(t, a, map[string]string{"sdkID": sdkID})
+		g.aargs = args
+		if code, err := g.Init(); code != 0 || err != nil {
+			t.Fatalf("Init failed: code=%d err=%v", code, err)
+		}
+		return g
+	}
+
+	// newest matching SDK
+	if g := initGdb("", "--interpreter=mi2", prog); g.sdkID != "ef23c1b7-0000" {
+		t.Errorf("Invalid SDK selected from ELF header: %s", g.sdkID)
+	}
+
+	// explicit SDK wins
+	if g := initGdb(a.sdks[0][1].ID, prog); g.sdkID != a.sdks[0][1].ID {
+		t.Errorf("Explicit SDK not honoured: %s", g.sdkID)
+	}
+
+	// no program, no default SDK: no SDK selected
+	if g := initGdb("", "--interpreter=mi2"); g.sdkID != "" {
+		t.Errorf("Unexpected SDK selected: %s", g.sdkID)
+	}
+
+	// project default SDK is preferred when it matches program
+	a.projects[0].DefaultSdk = a.sdks[0][0].ID
+	if g := initGdb("", prog); g.sdkID != a.sdks[0][0].ID {
+		t.Errorf("Project default SDK not selected: %s", g.sdkID)
+	}
+	if g := initGdb(""); g.sdkID != a.sdks[0][0].ID {
+		t.Errorf("Project default SDK not selected without program: %s", g.sdkID)
+	}
+
+	// but not when it doesn't match program
+	a.projects[0].DefaultSdk = a.sdks[0][1].ID
+	if g := initGdb("", prog); g.sdkID != "ef23c1b7-0000" {
+		t.Errorf("Invalid SDK selected with mismatching default SDK: %s", g.sdkID)
+	}
+}
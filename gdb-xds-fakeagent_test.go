@@ -0,0 +1,720 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	socketio "github.com/googollee/go-socket.io"
+	"github.com/gorilla/websocket"
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+// fakeAgentTimeout - Max duration to wait an event sent by xds-gdb
+const fakeAgentTimeout = 5 * time.Second
+
+// fakeAgent - In-process XDS agent used to test GdbXds without a live xds-agent.
+// It serves REST API used by xds-gdb and exec events on io.socket, and it
+// can be scripted to inject gdb output, disconnections and exit codes.
+type fakeAgent struct {
+	t      *testing.T
+	srv    *httptest.Server
+	sioSrv *socketio.Server
+	sid    string
+	token  string // token required by agent when set
+
+	mutex       sync.Mutex
+	version     xaapiv1.XDSVersion
+	config      xaapiv1.APIConfig
+	projects    []xaapiv1.ProjectConfig
+	sdks        map[int][]xaapiv1.SDK
+	failures    map[string]int
+	cmdID       string
+	cmdCount    int
+	socket      socketio.Socket
+	ws          *websocket.Conn // events channel of websocket transport
+	wsWrite     sync.Mutex
+	execArgs    []xaapiv1.ExecArgs
+	signals     []xaapiv1.ExecSignalArgs
+	events      []xaapiv1.EventRegisterArgs
+	configPosts []xaapiv1.APIConfig
+
+	// output of current command, buffered to be sent again on reattach
+	// (output is only buffered while command is detached by a disconnection)
+	outputs   []fakeOutput
+	exitMsg   *xaapiv1.ExecExitMsg
+	detached  bool
+	reattachs []reattachArgs
+
+	// optional features advertised on version request (see SetCapabilities)
+	capabilities []string
+
+	// io.socket pings are not acknowledged till closed (see StallEvents)
+	stalled chan struct{}
+
+	// OnExec is called when a command is started (eg. to send gdb banner)
+	OnExec func(args xaapiv1.ExecArgs)
+
+	stdin         chan string
+	inferiorStdin chan string
+	sockets       chan socketio.Socket
+	wsConns       chan *websocket.Conn
+	signalsChan   chan xaapiv1.ExecSignalArgs
+}
+
+// fakeOutput - Output event of a command
+type fakeOutput struct {
+	event string
+	msg   xaapiv1.ExecOutMsg
+}
+
+// newFakeAgent creates and starts a new fake agent connected to one XDS server
+func newFakeAgent(t *testing.T) *fakeAgent {
+	a := newFakeAgentUnstarted(t)
+	a.srv.Start()
+	return a
+}
+
+// newFakeAgentTLS creates and starts a new fake agent serving https and wss
+// (a client certificate signed by clientCAs is required when set)
+func newFakeAgentTLS(t *testing.T, clientCAs *x509.CertPool) *fakeAgent {
+	a := newFakeAgentUnstarted(t)
+	if clientCAs != nil {
+		a.srv.TLS = &tls.Config{ClientCAs: clientCAs, ClientAuth: tls.RequireAndVerifyClientCert}
+	}
+	a.srv.StartTLS()
+	return a
+}
+
+// newFakeAgentUnstarted creates a new fake agent (server is not started)
+func newFakeAgentUnstarted(t *testing.T) *fakeAgent {
+	a := &fakeAgent{
+		t:   t,
+		sid: "fake-agent-sid-1234",
+		version: xaapiv1.XDSVersion{
+			Client: xaapiv1.XDSVersionDetails{ID: "fake-agent", Version: "1.0.0", APIVersion: "1"},
+			Server: []xaapiv1.XDSVersionDetails{
+				xaapiv1.XDSVersionDetails{ID: "fake-server", Version: "1.0.0", APIVersion: "1"},
+			},
+		},
+		config: xaapiv1.APIConfig{
+			Servers: []xaapiv1.ServerCfg{
+				xaapiv1.ServerCfg{ID: "fake-server", URL: "http://localhost:8000", Connected: true},
+			},
+		},
+		projects: []xaapiv1.ProjectConfig{
+			xaapiv1.ProjectConfig{
+				ID:         "0a1b2c3d-4e5f-6789-abcd-ef0123456789",
+				ServerID:   "fake-server",
+				Label:      "helloworld",
+				ClientPath: "/home/user/xds-workspace/helloworld",
+				ServerPath: "/home/devel/xds-workspace/helloworld",
+				Type:       xaapiv1.TypeCloudSync,
+				Status:     "Enable",
+			},
+			xaapiv1.ProjectConfig{
+				ID:         "1a2b3c4d-5e6f-7890-bcde-f01234567890",
+				ServerID:   "fake-server",
+				Label:      "another-prj",
+				ClientPath: "/home/user/xds-workspace/another",
+				ServerPath: "/home/user/xds-workspace/another",
+				Type:       xaapiv1.TypePathMap,
+				Status:     "Enable",
+			},
+		},
+		sdks: map[int][]xaapiv1.SDK{
+			0: []xaapiv1.SDK{
+				xaapiv1.SDK{ID: "ef23c1b7-ad38-5e36-a7f9-1e5e3d6c3c83", Name: "poky-agl_aarch64_4.0.1", Arch: "aarch64"},
+				xaapiv1.SDK{ID: "a8c4d0e2-9f51-5b7e-8c1d-3f7a2b6e9d04", Name: "poky-agl_corei7-64_4.0.1", Arch: "corei7-64"},
+			},
+		},
+		capabilities:  []string{capEventPing, capReattach},
+		failures:      make(map[string]int),
+		stdin:         make(chan string, 100),
+		inferiorStdin: make(chan string, 100),
+		sockets:       make(chan socketio.Socket, 10),
+		wsConns:       make(chan *websocket.Conn, 10),
+		signalsChan:   make(chan xaapiv1.ExecSignalArgs, 10),
+	}
+
+	sioSrv, err := socketio.NewServer(nil)
+	if err != nil {
+		t.Fatalf("Cannot create io.socket server: %v", err)
+	}
+	a.sioSrv = sioSrv
+	a.sioSrv.On("connection", a.onConnection)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/socket.io/", func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorized(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		a.sioSrv.ServeHTTP(w, r)
+	})
+	mux.HandleFunc("/api/v1/ws", a.serveWebSocket)
+	mux.HandleFunc("/api/v1/", a.serveAPI)
+	a.srv = httptest.NewUnstartedServer(mux)
+
+	return a
+}
+
+// URL returns the url to use as agentURL
+func (a *fakeAgent) URL() string {
+	return a.srv.URL
+}
+
+// Close stops the fake agent
+func (a *fakeAgent) Close() {
+	a.mutex.Lock()
+	so := a.socket
+	a.socket = nil
+	ws := a.ws
+	a.ws = nil
+	if a.stalled != nil {
+		close(a.stalled)
+		a.stalled = nil
+	}
+	a.mutex.Unlock()
+	if so != nil {
+		so.Disconnect()
+	}
+	if ws != nil {
+		ws.Close()
+	}
+	a.srv.Close()
+}
+
+// RequireToken requires token on HTTP requests and io.socket handshake
+func (a *fakeAgent) RequireToken(token string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.token = token
+}
+
+// authorized returns true when request includes required token
+func (a *fakeAgent) authorized(r *http.Request) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.token == "" || r.Header.Get("Authorization") == "Bearer "+a.token
+}
+
+// FailRequest forces an HTTP error status on next requests of url (eg. "/exec"),
+// status 0 restores normal processing
+func (a *fakeAgent) FailRequest(url string, status int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if status == 0 {
+		delete(a.failures, url)
+		return
+	}
+	a.failures[url] = status
+}
+
+// AddServer adds a new XDS server (and its SDKs) to agent config
+func (a *fakeAgent) AddServer(svr xaapiv1.ServerCfg, sdks []xaapiv1.SDK) int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.config.Servers = append(a.config.Servers, svr)
+	idx := len(a.config.Servers) - 1
+	a.sdks[idx] = sdks
+	return idx
+}
+
+// Output sends gdb output of current command
+func (a *fakeAgent) Output(stdout, stderr string) {
+	a.output(xaapiv1.ExecOutEvent, stdout, stderr)
+}
+
+// InferiorOutput sends output of debugged program
+func (a *fakeAgent) InferiorOutput(stdout, stderr string) {
+	a.output(xaapiv1.ExecInferiorOutEvent, stdout, stderr)
+}
+
+// Exit sends exit event of current command (sent on reattach when
+// command is detached)
+func (a *fakeAgent) Exit(code int) {
+	a.mutex.Lock()
+	msg := xaapiv1.ExecExitMsg{
+		CmdID:     a.cmdID,
+		Timestamp: time.Now().String(),
+		Code:      code,
+	}
+	detached := a.detached
+	if detached {
+		a.exitMsg = &msg
+	}
+	a.mutex.Unlock()
+	if !detached {
+		a.emit(xaapiv1.ExecExitEvent, msg)
+	}
+}
+
+// ServerConnected sends a server config event with the new connection status
+func (a *fakeAgent) ServerConnected(connected bool) {
+	a.mutex.Lock()
+	a.config.Servers[0].Connected = connected
+	svrCfg := a.config.Servers[0]
+	a.mutex.Unlock()
+
+	a.emit(xaapiv1.EVTServerConfig, xaapiv1.EventMsg{
+		Time: time.Now().String(),
+		Type: xaapiv1.EVTServerConfig,
+		Data: svrCfg,
+	})
+}
+
+// Disconnect closes the io.socket or websocket connection (IOW simulates a
+// network failure), output of current command is buffered till reattach
+func (a *fakeAgent) Disconnect() {
+	a.mutex.Lock()
+	a.detached = a.cmdID != ""
+	so := a.socket
+	a.socket = nil
+	ws := a.ws
+	a.ws = nil
+	if a.stalled != nil {
+		close(a.stalled)
+		a.stalled = nil
+	}
+	a.mutex.Unlock()
+	switch {
+	case so != nil:
+		so.Disconnect()
+	case ws != nil:
+		ws.Close()
+	default:
+		a.t.Fatalf("Disconnect: no io.socket connected")
+	}
+}
+
+// SetCapabilities sets optional features supported by agent, none means an
+// agent that only implements base API
+func (a *fakeAgent) SetCapabilities(caps ...string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.capabilities = caps
+}
+
+// supports returns true when agent supports capability
+func (a *fakeAgent) supports(capability string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.hasCapability(capability)
+}
+
+// hasCapability is supports with mutex locked
+func (a *fakeAgent) hasCapability(capability string) bool {
+	for _, c := range a.capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// StallEvents stalls io.socket events channel: pings are no longer
+// acknowledged while REST requests are still served (half-dead link)
+func (a *fakeAgent) StallEvents() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.stalled == nil {
+		a.stalled = make(chan struct{})
+	}
+}
+
+// CmdID returns ID of last started command
+func (a *fakeAgent) CmdID() string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.cmdID
+}
+
+// ExecArgs returns the list of arguments received by /exec
+func (a *fakeAgent) ExecArgs() []xaapiv1.ExecArgs {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return append([]xaapiv1.ExecArgs{}, a.execArgs...)
+}
+
+// Reattachs returns the list of reattach requests
+func (a *fakeAgent) Reattachs() []reattachArgs {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return append([]reattachArgs{}, a.reattachs...)
+}
+
+// Events returns the list of events registered using /events/register
+func (a *fakeAgent) Events() []xaapiv1.EventRegisterArgs {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return append([]xaapiv1.EventRegisterArgs{}, a.events...)
+}
+
+// ConfigPosts returns the list of config sent using POST /config
+func (a *fakeAgent) ConfigPosts() []xaapiv1.APIConfig {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return append([]xaapiv1.APIConfig{}, a.configPosts...)
+}
+
+// WaitSocket waits an io.socket connection
+func (a *fakeAgent) WaitSocket() socketio.Socket {
+	select {
+	case so := <-a.sockets:
+		return so
+	case <-time.After(fakeAgentTimeout):
+		a.t.Fatalf("Timeout while waiting io.socket connection")
+	}
+	return nil
+}
+
+// WaitWebSocket waits a connection of websocket transport
+func (a *fakeAgent) WaitWebSocket() *websocket.Conn {
+	select {
+	case ws := <-a.wsConns:
+		return ws
+	case <-time.After(fakeAgentTimeout):
+		a.t.Fatalf("Timeout while waiting websocket connection")
+	}
+	return nil
+}
+
+// WaitStdin waits data written into gdb stdin
+func (a *fakeAgent) WaitStdin() string {
+	return a.wait(a.stdin, "gdb stdin")
+}
+
+// WaitInferiorStdin waits data written into stdin of debugged program
+func (a *fakeAgent) WaitInferiorStdin() string {
+	return a.wait(a.inferiorStdin, "inferior stdin")
+}
+
+// WaitSignal waits a signal sent using /signal
+func (a *fakeAgent) WaitSignal() xaapiv1.ExecSignalArgs {
+	select {
+	case sig := <-a.signalsChan:
+		return sig
+	case <-time.After(fakeAgentTimeout):
+		a.t.Fatalf("Timeout while waiting signal")
+	}
+	return xaapiv1.ExecSignalArgs{}
+}
+
+//***** Private functions *****
+
+func (a *fakeAgent) wait(c chan string, what string) string {
+	select {
+	case s := <-c:
+		return s
+	case <-time.After(fakeAgentTimeout):
+		a.t.Fatalf("Timeout while waiting data on %s", what)
+	}
+	return ""
+}
+
+func (a *fakeAgent) output(event, stdout, stderr string) {
+	a.mutex.Lock()
+	o := fakeOutput{
+		event: event,
+		msg: xaapiv1.ExecOutMsg{
+			CmdID:     a.cmdID,
+			Timestamp: time.Now().String(),
+			Stdout:    stdout,
+			Stderr:    stderr,
+		},
+	}
+	a.outputs = append(a.outputs, o)
+	detached := a.detached
+	a.mutex.Unlock()
+	if !detached {
+		a.emit(o.event, o.msg)
+	}
+}
+
+// attach attaches detached command to the new events channel when agent
+// doesn't support reattach (IOW output emitted meanwhile is lost), called
+// with mutex locked
+func (a *fakeAgent) attach() {
+	if a.detached && !a.hasCapability(capReattach) {
+		a.detached = false
+		a.exitMsg = nil
+	}
+}
+
+// replay sends output not received before disconnection, then exit event
+// when command exited, and attaches command again
+func (a *fakeAgent) replay(args reattachArgs) {
+	offsets := map[string]int{
+		xaapiv1.ExecOutEvent:         args.OutOffset,
+		xaapiv1.ExecInferiorOutEvent: args.InferiorOffset,
+	}
+	for i := 0; ; i++ {
+		a.mutex.Lock()
+		if i >= len(a.outputs) {
+			a.detached = false
+			exitMsg := a.exitMsg
+			a.mutex.Unlock()
+			if exitMsg != nil {
+				a.waitLink()
+				a.emit(xaapiv1.ExecExitEvent, *exitMsg)
+			}
+			return
+		}
+		o := a.outputs[i]
+		a.mutex.Unlock()
+
+		if offsets[o.event] > 0 {
+			offsets[o.event]--
+			continue
+		}
+		a.waitLink()
+		a.emit(o.event, o.msg)
+	}
+}
+
+// waitLink waits that events channel is connected (connection is only
+// registered when io.socket handshake is completed)
+func (a *fakeAgent) waitLink() {
+	for start := time.Now(); time.Since(start) < fakeAgentTimeout; time.Sleep(10 * time.Millisecond) {
+		a.mutex.Lock()
+		connected := a.socket != nil || a.ws != nil
+		a.mutex.Unlock()
+		if connected {
+			return
+		}
+	}
+}
+
+func (a *fakeAgent) emit(event string, data interface{}) {
+	a.mutex.Lock()
+	so := a.socket
+	ws := a.ws
+	a.mutex.Unlock()
+	if ws != nil && so == nil {
+		raw, _ := json.Marshal(data)
+		a.wsWrite.Lock()
+		defer a.wsWrite.Unlock()
+		if err := ws.WriteJSON(wsMessage{Event: event, Data: raw}); err != nil {
+			a.t.Fatalf("Cannot emit %s: %v", event, err)
+		}
+		return
+	}
+	if so == nil {
+		a.t.Fatalf("Cannot emit %s: no io.socket connected", event)
+	}
+	if err := so.Emit(event, data); err != nil {
+		a.t.Fatalf("Cannot emit %s: %v", event, err)
+	}
+}
+
+func (a *fakeAgent) onConnection(so socketio.Socket) {
+	if sid := so.Request().Header.Get("XDS-AGENT-SID"); sid != a.sid {
+		a.t.Errorf("io.socket connection with invalid session ID: %q", sid)
+	}
+	so.On(xaapiv1.ExecInEvent, func(stdin string) {
+		a.stdin <- stdin
+	})
+	so.On(xaapiv1.ExecInferiorInEvent, func(stdin string) {
+		a.inferiorStdin <- stdin
+	})
+	if a.supports(capEventPing) {
+		so.On(sioPingEvent, func() string {
+			a.mutex.Lock()
+			stalled := a.stalled
+			a.mutex.Unlock()
+			if stalled != nil {
+				<-stalled
+			}
+			return "pong"
+		})
+	}
+
+	a.mutex.Lock()
+	a.socket = so
+	a.attach()
+	a.mutex.Unlock()
+	a.sockets <- so
+}
+
+func (a *fakeAgent) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+	if sid := r.Header.Get("XDS-AGENT-SID"); sid != a.sid {
+		a.t.Errorf("websocket connection with invalid session ID: %q", sid)
+	}
+	ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		a.t.Errorf("Cannot upgrade websocket connection: %v", err)
+		return
+	}
+	a.mutex.Lock()
+	a.ws = ws
+	a.attach()
+	a.mutex.Unlock()
+	a.wsConns <- ws
+
+	for {
+		msg := wsMessage{}
+		if err := ws.ReadJSON(&msg); err != nil {
+			return
+		}
+		var stdin string
+		json.Unmarshal(msg.Data, &stdin)
+		switch msg.Event {
+		case xaapiv1.ExecInEvent:
+			a.stdin <- stdin
+		case xaapiv1.ExecInferiorInEvent:
+			a.inferiorStdin <- stdin
+		}
+	}
+}
+
+func (a *fakeAgent) serveAPI(w http.ResponseWriter, r *http.Request) {
+	url := strings.TrimPrefix(r.URL.Path, "/api/v1")
+
+	a.mutex.Lock()
+	status, fail := a.failures[url]
+	a.mutex.Unlock()
+	if fail {
+		http.Error(w, fmt.Sprintf("Fake agent error on %s", url), status)
+		return
+	}
+	if !a.authorized(r) {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	// Session ID is returned on every request
+	w.Header().Set("Xds-Agent-Sid", a.sid)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	switch {
+	case url == "/version":
+		w.Header().Set(capabilitiesHeader, strings.Join(a.capabilities, ", "))
+		a.reply(w, a.version)
+
+	case url == "/config" && r.Method == "GET":
+		a.reply(w, a.config)
+
+	case url == "/config" && r.Method == "POST":
+		cfg := xaapiv1.APIConfig{}
+		if !a.decode(w, r, &cfg) {
+			return
+		}
+		a.configPosts = append(a.configPosts, cfg)
+		a.config = cfg
+		for i := range a.config.Servers {
+			if a.config.Servers[i].ID == "" {
+				a.config.Servers[i].ID = fmt.Sprintf("fake-server-%d", i)
+			}
+			a.config.Servers[i].Connected = true
+		}
+		a.reply(w, a.config)
+
+	case url == "/projects":
+		a.reply(w, a.projects)
+
+	case strings.HasPrefix(url, "/servers/") && strings.HasSuffix(url, "/sdks"):
+		idx, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(url, "/servers/"), "/sdks"))
+		if err != nil || idx < 0 || idx >= len(a.config.Servers) {
+			http.Error(w, "Invalid server index", http.StatusBadRequest)
+			return
+		}
+		a.reply(w, a.sdks[idx])
+
+	case url == "/exec" && r.Method == "POST":
+		args := xaapiv1.ExecArgs{}
+		if !a.decode(w, r, &args) {
+			return
+		}
+		a.cmdCount++
+		a.cmdID = fmt.Sprintf("fake-cmd-%d", a.cmdCount)
+		a.execArgs = append(a.execArgs, args)
+		a.outputs = []fakeOutput{}
+		a.exitMsg = nil
+		a.detached = false
+		a.reply(w, xaapiv1.ExecResult{Status: "OK", CmdID: a.cmdID})
+		if a.OnExec != nil {
+			go a.OnExec(args)
+		}
+
+	case url == reattachURL && r.Method == "POST" && a.hasCapability(capReattach):
+		args := reattachArgs{}
+		if !a.decode(w, r, &args) {
+			return
+		}
+		a.reattachs = append(a.reattachs, args)
+		if args.CmdID != a.cmdID {
+			http.Error(w, "Unknown command "+args.CmdID, http.StatusNotFound)
+			return
+		}
+		a.reply(w, xaapiv1.ExecResult{Status: "OK", CmdID: a.cmdID})
+		go a.replay(args)
+
+	case url == "/signal" && r.Method == "POST":
+		sig := xaapiv1.ExecSignalArgs{}
+		if !a.decode(w, r, &sig) {
+			return
+		}
+		a.signals = append(a.signals, sig)
+		a.signalsChan <- sig
+		a.reply(w, struct{}{})
+
+	case url == "/events/register" && r.Method == "POST":
+		ev := xaapiv1.EventRegisterArgs{}
+		if !a.decode(w, r, &ev) {
+			return
+		}
+		a.events = append(a.events, ev)
+		a.reply(w, struct{}{})
+
+	default:
+		http.Error(w, "Unsupported request "+r.Method+" "+url, http.StatusNotFound)
+	}
+}
+
+func (a *fakeAgent) decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, "Invalid body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (a *fakeAgent) reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		a.t.Errorf("Cannot encode reply: %v", err)
+	}
+}
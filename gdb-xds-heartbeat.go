@@ -0,0 +1,215 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Default heartbeat settings (XDS_HEARTBEAT_INTERVAL, XDS_HEARTBEAT_MAX_MISSED
+// and XDS_LATENCY_THRESHOLD)
+const (
+	defaultHeartbeatInterval  = 10 * time.Second
+	defaultHeartbeatMaxMissed = 3
+	defaultLatencyThreshold   = 1000 * time.Millisecond
+)
+
+// linkStats - Round-trip time statistics of the link to xds-agent
+type linkStats struct {
+	beats  int // number of answered heartbeats
+	missed int // number of missed heartbeats
+	last   time.Duration
+	min    time.Duration
+	max    time.Duration
+	total  time.Duration
+	slow   bool // latency is above threshold (IOW warning already reported)
+}
+
+// add records the round-trip time of an answered heartbeat
+func (s *linkStats) add(rtt time.Duration) {
+	if s.beats == 0 || rtt < s.min {
+		s.min = rtt
+	}
+	if rtt > s.max {
+		s.max = rtt
+	}
+	s.beats++
+	s.last = rtt
+	s.total += rtt
+}
+
+func (s linkStats) String() string {
+	if s.beats == 0 {
+		return fmt.Sprintf("no answered heartbeat (%d missed)", s.missed)
+	}
+	return fmt.Sprintf("last %v, min %v, avg %v, max %v over %d heartbeats (%d missed)",
+		roundRtt(s.last), roundRtt(s.min), roundRtt(s.total/time.Duration(s.beats)), roundRtt(s.max), s.beats, s.missed)
+}
+
+// isMIMode returns true when gdb arguments select MI interpreter
+func isMIMode(args []string) bool {
+	for i, a := range args {
+		if strings.HasPrefix(a, "--interpreter=mi") || strings.HasPrefix(a, "-i=mi") {
+			return true
+		}
+		if (a == "--interpreter" || a == "-i") && i+1 < len(args) && strings.HasPrefix(args[i+1], "mi") {
+			return true
+		}
+	}
+	return false
+}
+
+//***** Private functions *****
+
+// roundRtt rounds a round-trip time for display
+func roundRtt(d time.Duration) time.Duration {
+	if d < time.Millisecond {
+		return d - d%time.Microsecond
+	}
+	return d - d%(100*time.Microsecond)
+}
+
+// startHeartbeat starts periodic measurement of xds-agent round-trip time
+// (disabled when heartbeat interval is 0)
+func (g *GdbXds) startHeartbeat() {
+	if g.heartbeatInterval == 0 {
+		return
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.heartbeatStop != nil {
+		return
+	}
+	g.heartbeatStop = make(chan struct{})
+	g.log.Infof("Start heartbeat every %v (max %d missed, latency threshold %v)",
+		g.heartbeatInterval, g.heartbeatMaxMissed, g.latencyThreshold)
+	go g.heartbeat(g.heartbeatStop)
+}
+
+// stopHeartbeat stops heartbeat and reports latency statistics
+func (g *GdbXds) stopHeartbeat() {
+	g.mutex.Lock()
+	stop := g.heartbeatStop
+	g.heartbeatStop = nil
+	stats := g.stats
+	g.mutex.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+
+	g.log.Infof("XDS agent latency: %v", stats)
+	if stats.beats > 0 || stats.missed > 0 {
+		g.notify("XDS agent latency: " + stats.String())
+	}
+}
+
+// heartbeat pings xds-agent every heartbeat interval, the link is declared
+// lost when max missed heartbeats is reached
+func (g *GdbXds) heartbeat(stop chan struct{}) {
+	ticker := time.NewTicker(g.heartbeatInterval)
+	defer ticker.Stop()
+
+	missed := 0
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		// Link down is handled by reconnection
+		g.mutex.Lock()
+		state := g.linkState
+		link := g.link
+		g.mutex.Unlock()
+		if state != linkConnected || link == nil {
+			missed = 0
+			continue
+		}
+
+		start := time.Now()
+		err := link.Ping(g.heartbeatInterval)
+		rtt := time.Since(start)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		if err != nil {
+			missed++
+			g.mutex.Lock()
+			g.stats.missed++
+			g.mutex.Unlock()
+			g.log.Warnf("Heartbeat missed (%d/%d): %v", missed, g.heartbeatMaxMissed, err)
+			if missed >= g.heartbeatMaxMissed {
+				g.linkLost(fmt.Errorf("XDS agent not responding, %d heartbeats missed (%v)", missed, err))
+				return
+			}
+			continue
+		}
+		missed = 0
+
+		g.mutex.Lock()
+		g.stats.add(rtt)
+		wasSlow := g.stats.slow
+		g.stats.slow = g.latencyThreshold > 0 && rtt > g.latencyThreshold
+		isSlow := g.stats.slow
+		g.mutex.Unlock()
+
+		g.log.Debugf("Heartbeat: XDS agent latency %v", roundRtt(rtt))
+		if isSlow && !wasSlow {
+			g.log.Warnf("XDS agent latency %v exceeds %v", roundRtt(rtt), g.latencyThreshold)
+			g.notify(fmt.Sprintf("Warning: XDS agent latency %v exceeds %v", roundRtt(rtt), g.latencyThreshold))
+		} else if wasSlow && !isSlow {
+			g.log.Infof("XDS agent latency back to %v", roundRtt(rtt))
+		}
+	}
+}
+
+// linkLost closes a link that doesn't answer heartbeats anymore
+func (g *GdbXds) linkLost(cause error) {
+	g.mutex.Lock()
+	state := g.linkState
+	link := g.link
+	g.mutex.Unlock()
+	if state != linkConnected {
+		return
+	}
+	g.log.Errorf("%v", cause)
+	link.Close()
+	g.disconnected(cause)
+}
+
+// notify reports a message to user: as an MI log stream record within gdb
+// output when MI interpreter is used (IOW displayed by IDE), else on stderr
+func (g *GdbXds) notify(msg string) {
+	g.outMutex.Lock()
+	defer g.outMutex.Unlock()
+	if g.miMode && g.outLineStart && g.cbRead != nil {
+		g.cbRead(time.Now().String(), "&"+miQuote(msg+"\n")+"\n", "")
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\n%s\n", msg)
+}
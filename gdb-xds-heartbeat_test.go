@@ -0,0 +1,258 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+// startMemGdbXds starts gdb using an in-memory transport
+func startMemGdbXds(t *testing.T, a *fakeAgent, m *memTransport, config map[string]string) *GdbXds {
+	xdsTransports["memory"] = func(conf transportConfig) (xdsTransport, error) { return m, nil }
+	conf := map[string]string{"transport": "memory", "agentURL": "memory", "reconnectTimeout": "0"}
+	for k, v := range config {
+		conf[k] = v
+	}
+	g := newTestGdbXds(t, a, conf)
+	if code, err := g.Init(); code != 0 || err != nil {
+		t.Fatalf("Init failed: code=%d err=%v", code, err)
+	}
+	return g
+}
+
+func TestGdbXdsHeartbeatLatency(t *testing.T) {
+	a := newFakeAgentUnstarted(t)
+	defer a.Close()
+	defer delete(xdsTransports, "memory")
+	m := newMemTransport(a)
+	m.setPing(30*time.Millisecond, nil)
+
+	g := startMemGdbXds(t, a, m, map[string]string{"heartbeatInterval": "50ms", "latencyThreshold": "20"})
+	defer g.Close()
+	outC := make(chan string, 10)
+	g.Read(func(timestamp, stdout, stderr string) { outC <- stdout })
+	if code, err := g.Start(false); code != 0 || err != nil {
+		t.Fatalf("Start failed: code=%d err=%v", code, err)
+	}
+
+	select {
+	case out := <-outC:
+		if !strings.HasPrefix(out, `&"Warning: XDS agent latency `) || !strings.Contains(out, "exceeds 20ms") {
+			t.Errorf("Unexpected latency warning: %q", out)
+		}
+	case <-time.After(fakeAgentTimeout):
+		t.Fatal("Timeout while waiting latency warning")
+	}
+
+	// warning is only reported once while latency stays above threshold
+	m.setPing(0, nil)
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case out := <-outC:
+		t.Errorf("Unexpected output: %q", out)
+	default:
+	}
+
+	// latency is reported at exit
+	m.deliver(xaapiv1.ExecExitEvent, xaapiv1.ExecExitMsg{CmdID: a.CmdID(), Code: 0})
+	select {
+	case out := <-outC:
+		if !strings.Contains(out, "XDS agent latency: last ") || !strings.Contains(out, "(0 missed)") {
+			t.Errorf("Unexpected latency report: %q", out)
+		}
+	case <-time.After(fakeAgentTimeout):
+		t.Fatal("Timeout while waiting latency report")
+	}
+	g.mutex.Lock()
+	stats := g.stats
+	g.mutex.Unlock()
+	if stats.beats < 2 || stats.max < 30*time.Millisecond || stats.min > stats.max {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestGdbXdsHeartbeatMissed(t *testing.T) {
+	a := newFakeAgentUnstarted(t)
+	defer a.Close()
+	defer delete(xdsTransports, "memory")
+	m := newMemTransport(a)
+
+	g := startMemGdbXds(t, a, m, map[string]string{"heartbeatInterval": "50ms", "heartbeatMaxMissed": "2"})
+	discC := make(chan error, 1)
+	g.OnDisconnect(func(err error) { discC <- err })
+	if code, err := g.Start(false); code != 0 || err != nil {
+		t.Fatalf("Start failed: code=%d err=%v", code, err)
+	}
+
+	// half-dead link: no disconnection event but pings are not answered
+	m.setPing(time.Second, nil)
+	select {
+	case err := <-discC:
+		if err == nil || !strings.Contains(err.Error(), "2 heartbeats missed") {
+			t.Errorf("Unexpected disconnection error: %v", err)
+		}
+	case <-time.After(fakeAgentTimeout):
+		t.Fatal("Timeout while waiting disconnection")
+	}
+	if m.handler() != nil {
+		t.Errorf("Transport not closed")
+	}
+	if err := g.Write("-exec-next\n"); err == nil {
+		t.Errorf("Write must fail once link is lost")
+	}
+}
+
+func TestGdbXdsHeartbeatEventsStalled(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+
+	g := startTestGdbXds(t, a, map[string]string{"heartbeatInterval": "50ms", "heartbeatMaxMissed": "2", "reconnectTimeout": "0"})
+	discC := make(chan error, 1)
+	g.OnDisconnect(func(err error) { discC <- err })
+
+	// REST API still answers but events channel is stalled
+	a.StallEvents()
+	ver := xaapiv1.XDSVersion{}
+	if err := g.link.Request("GET", "/version", nil, &ver); err != nil {
+		t.Fatalf("REST request failed: %v", err)
+	}
+	select {
+	case err := <-discC:
+		if err == nil || !strings.Contains(err.Error(), "2 heartbeats missed") {
+			t.Errorf("Unexpected disconnection error: %v", err)
+		}
+	case <-time.After(fakeAgentTimeout):
+		t.Fatal("Timeout while waiting disconnection")
+	}
+}
+
+func TestGdbXdsHeartbeatNoEventPing(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+
+	// agent that doesn't acknowledge ping events: heartbeat uses REST API
+	a.SetCapabilities()
+	g := startTestGdbXds(t, a, map[string]string{"heartbeatInterval": "50ms", "heartbeatMaxMissed": "2", "reconnectTimeout": "0"})
+	discC := make(chan error, 1)
+	g.OnDisconnect(func(err error) { discC <- err })
+	if g.link.Supports(capEventPing) {
+		t.Errorf("Ping event should not be supported")
+	}
+
+	select {
+	case err := <-discC:
+		t.Fatalf("Session lost: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+	g.mutex.Lock()
+	stats := g.stats
+	g.mutex.Unlock()
+	if stats.beats == 0 || stats.missed != 0 {
+		t.Errorf("Unexpected heartbeats: %d answered, %d missed", stats.beats, stats.missed)
+	}
+}
+
+func TestHTTPTransportPingCancel(t *testing.T) {
+	canceledC := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		canceledC <- struct{}{}
+	}))
+	defer srv.Close()
+
+	tlog := logrus.New()
+	tlog.Out = ioutil.Discard
+	ht := &httpTransport{client: &http.Client{}, url: srv.URL, log: tlog}
+	if err := ht.Ping(50 * time.Millisecond); err == nil || !strings.Contains(err.Error(), "no answer") {
+		t.Errorf("Unexpected ping result of hung agent: %v", err)
+	}
+	select {
+	case <-canceledC:
+	case <-time.After(fakeAgentTimeout):
+		t.Fatal("Ping request not canceled")
+	}
+}
+
+func TestTransportPing(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+
+	for _, name := range []string{"socketio", "websocket"} {
+		g := newTestGdbXds(t, a, map[string]string{"transport": name})
+		if code, err := g.Init(); code != 0 || err != nil {
+			t.Fatalf("Init failed with %s: code=%d err=%v", name, code, err)
+		}
+		if name == "websocket" {
+			a.WaitWebSocket()
+		} else {
+			a.WaitSocket()
+		}
+		if err := g.link.Ping(time.Second); err != nil {
+			t.Errorf("Ping failed with %s: %v", name, err)
+		}
+		g.Close()
+		if err := g.link.Ping(time.Second); err == nil {
+			t.Errorf("Ping of closed %s transport should fail", name)
+		}
+	}
+}
+
+func TestIsMIMode(t *testing.T) {
+	for args, exp := range map[string]bool{
+		"--interpreter=mi2 prog": true,
+		"-i=mi prog":             true,
+		"--interpreter mi3 prog": true,
+		"-i mi":                  true,
+		"--interpreter=console":  false,
+		"-x cmds prog":           false,
+		"-i":                     false,
+	} {
+		if isMIMode(strings.Fields(args)) != exp {
+			t.Errorf("isMIMode(%q) != %v", args, exp)
+		}
+	}
+}
+
+func TestLinkStats(t *testing.T) {
+	s := linkStats{}
+	if str := s.String(); str != "no answered heartbeat (0 missed)" {
+		t.Errorf("Unexpected empty stats: %q", str)
+	}
+	for _, ms := range []int{3, 1, 8} {
+		s.add(time.Duration(ms) * time.Millisecond)
+	}
+	s.missed = 1
+	if str, exp := s.String(), "last 8ms, min 1ms, avg 4ms, max 8ms over 3 heartbeats (1 missed)"; str != exp {
+		t.Errorf("Unexpected stats: %q (expected %q)", str, exp)
+	}
+	if d := roundRtt(1234567 * time.Nanosecond); d != 1200*time.Microsecond {
+		t.Errorf("Unexpected rounding: %v", d)
+	}
+	if d := roundRtt(1500 * time.Nanosecond); d != time.Microsecond {
+		t.Errorf("Unexpected rounding: %v", d)
+	}
+}
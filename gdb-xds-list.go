@@ -0,0 +1,230 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	"gopkg.in/yaml.v2"
+)
+
+// Supported output formats of projects list
+var listFormats = []string{"table", "json", "yaml"}
+
+// XdsList - Servers, projects and SDKs printed by --list option
+type XdsList struct {
+	Servers  []XdsListServer  `json:"servers" yaml:"servers"`
+	Projects []XdsListProject `json:"projects" yaml:"projects"`
+	Sdks     []XdsListSdk     `json:"sdks" yaml:"sdks"`
+}
+
+// XdsListServer - XDS server description
+type XdsListServer struct {
+	ID        string `json:"id" yaml:"id"`
+	URL       string `json:"url" yaml:"url"`
+	Connected bool   `json:"connected" yaml:"connected"`
+}
+
+// XdsListProject - XDS project description
+type XdsListProject struct {
+	ID         string `json:"id" yaml:"id"`
+	ShortID    string `json:"shortId" yaml:"shortId"`
+	Label      string `json:"label" yaml:"label"`
+	ClientPath string `json:"clientPath" yaml:"clientPath"`
+	ServerPath string `json:"serverPath" yaml:"serverPath"`
+	Type       string `json:"type" yaml:"type"`
+	Status     string `json:"status" yaml:"status"`
+	ServerID   string `json:"serverId" yaml:"serverId"`
+}
+
+// XdsListSdk - Cross SDK description
+type XdsListSdk struct {
+	ID       string `json:"id" yaml:"id"`
+	ShortID  string `json:"shortId" yaml:"shortId"`
+	Name     string `json:"name" yaml:"name"`
+	Arch     string `json:"arch" yaml:"arch"`
+	Version  string `json:"version" yaml:"version"`
+	ServerID string `json:"serverId" yaml:"serverId"`
+}
+
+// isListFormat returns true when format is a supported output format
+func isListFormat(format string) bool {
+	for _, f := range listFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// printProjectsList prints servers, projects and SDKs (only the selected
+// server ones when server is set) using table, json or yaml output format
+func (g *GdbXds) printProjectsList() (int, error) {
+	list, err := g.getList()
+	if err != nil {
+		return int(syscallEBADE), err
+	}
+
+	switch g.listFmt {
+	case "json":
+		data, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return int(syscall.EINVAL), err
+		}
+		fmt.Fprintln(os.Stdout, string(data))
+	case "yaml":
+		data, err := yaml.Marshal(list)
+		if err != nil {
+			return int(syscall.EINVAL), err
+		}
+		fmt.Fprint(os.Stdout, string(data))
+	case "", "table":
+		printListTable(os.Stdout, list)
+	default:
+		return int(syscall.EINVAL), fmt.Errorf("Unsupported list format %s (supported: table, json, yaml)", g.listFmt)
+	}
+	return 0, nil
+}
+
+//***** Private functions *****
+
+// getList retrieves servers, projects and SDKs lists
+func (g *GdbXds) getList() (*XdsList, error) {
+	list := &XdsList{
+		Servers:  []XdsListServer{},
+		Projects: []XdsListProject{},
+		Sdks:     []XdsListSdk{},
+	}
+
+	// Only list selected server when defined
+	servers := []int{}
+	for i := range g.servers {
+		if g.svrIdx == -1 || g.svrIdx == i {
+			servers = append(servers, i)
+		}
+	}
+	for _, i := range servers {
+		list.Servers = append(list.Servers, XdsListServer{
+			ID:        g.servers[i].ID,
+			URL:       g.servers[i].URL,
+			Connected: g.servers[i].Connected,
+		})
+	}
+
+	// Projects and SDKs may be referenced by the shortest unique prefix of their ID
+	ids := []string{}
+	for _, f := range g.projects {
+		ids = append(ids, f.ID)
+	}
+	prjPrefixes := shortestPrefixes(ids)
+	for _, f := range g.projects {
+		if g.svrIdx != -1 && f.ServerID != g.servers[g.svrIdx].ID {
+			continue
+		}
+		list.Projects = append(list.Projects, XdsListProject{
+			ID:         f.ID,
+			ShortID:    prjPrefixes[f.ID],
+			Label:      f.Label,
+			ClientPath: f.ClientPath,
+			ServerPath: f.ServerPath,
+			Type:       string(f.Type),
+			Status:     f.Status,
+			ServerID:   f.ServerID,
+		})
+	}
+
+	for _, i := range servers {
+		if !g.servers[i].Connected {
+			continue
+		}
+		svrSdks := []xaapiv1.SDK{}
+		if err := g.link.Request("GET", "/servers/"+strconv.Itoa(i)+"/sdks", nil, &svrSdks); err != nil {
+			return nil, err
+		}
+		ids := []string{}
+		for _, s := range svrSdks {
+			ids = append(ids, s.ID)
+		}
+		sdkPrefixes := shortestPrefixes(ids)
+		for _, s := range svrSdks {
+			list.Sdks = append(list.Sdks, XdsListSdk{
+				ID:       s.ID,
+				ShortID:  sdkPrefixes[s.ID],
+				Name:     s.Name,
+				Arch:     s.Arch,
+				Version:  s.Version,
+				ServerID: g.servers[i].ID,
+			})
+		}
+	}
+	return list, nil
+}
+
+// printListTable prints list as tables with usage examples
+func printListTable(out io.Writer, list *XdsList) {
+	writer := new(tabwriter.Writer)
+	writer.Init(out, 0, 8, 0, '\t', 0)
+
+	fmt.Fprintln(writer, "List of XDS servers (use: export XDS_SERVER_ID=<< ID >>):")
+	fmt.Fprintln(writer, "ID \t URL \t Status")
+	for _, s := range list.Servers {
+		status := "connected"
+		if !s.Connected {
+			status = "not connected"
+		}
+		fmt.Fprintf(writer, " %s \t  %s \t  %s\n", s.ID, s.URL, status)
+	}
+
+	if len(list.Projects) > 0 {
+		fmt.Fprintln(writer, "\nList of existing projects (use: export XDS_PROJECT_ID=<< ID, Short ID or Label >>):")
+		fmt.Fprintln(writer, "ID \t Short ID \t Label \t Server")
+		for _, f := range list.Projects {
+			fmt.Fprintf(writer, " %s \t  %s \t  %s \t  %s\n", f.ID, f.ShortID, f.Label, f.ServerID)
+		}
+	}
+
+	fmt.Fprintln(writer, "\nList of installed cross SDKs (use: export XDS_SDK_ID=<< ID, Short ID or Name >>):")
+	fmt.Fprintln(writer, "ID \t Short ID \t Name \t Server")
+	for _, s := range list.Sdks {
+		fmt.Fprintf(writer, " %s \t  %s \t  %s \t  %s\n", s.ID, s.ShortID, s.Name, s.ServerID)
+	}
+
+	if len(list.Projects) > 0 && len(list.Sdks) > 0 {
+		fmt.Fprintln(writer, "")
+		fmt.Fprintln(writer, "For example: ")
+		if runtime.GOOS == "windows" {
+			fmt.Fprintf(writer, "  SET XDS_PROJECT_ID=%s && SET XDS_SDK_ID=%s &&  %s -x myGdbConf.ini\n",
+				list.Projects[0].ShortID, list.Sdks[0].ShortID, AppName)
+		} else {
+			fmt.Fprintf(writer, "  XDS_PROJECT_ID=%s XDS_SDK_ID=%s  %s -x myGdbConf.ini\n",
+				list.Projects[0].ShortID, list.Sdks[0].ShortID, AppName)
+		}
+	}
+	fmt.Fprintln(writer, "")
+	fmt.Fprintln(writer, "Or define settings within gdb configuration file (see help and :XDS-ENV: tag)")
+	writer.Flush()
+}
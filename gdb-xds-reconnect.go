@@ -0,0 +1,337 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+// linkState - State of the connection between xds-gdb and XDS agent/server
+//
+//	linkConnected --(agent disconnection)--> linkReconnecting --(socket reopened)--> linkConnected
+//	linkConnected --(server disconnection)--> linkServerLost --(server connected)--> linkConnected
+//	linkReconnecting / linkServerLost --(timeout)--> linkClosed (OnDisconnect / OnExit called)
+type linkState int
+
+const (
+	linkConnected linkState = iota
+	linkReconnecting
+	linkServerLost
+	linkClosed
+)
+
+const (
+	// default max duration to wait agent or server connection back (XDS_RECONNECT_TIMEOUT)
+	defaultReconnectTimeout = 120 * time.Second
+
+	reconnectMinDelay = 500 * time.Millisecond
+	reconnectMaxDelay = 10 * time.Second
+)
+
+// XDS agent request used to reattach to the running command after a
+// reconnection: agent sends again output emitted while link was down.
+// It is only sent when XDS agent advertises capReattach, otherwise events
+// channel is just reopened (IOW output emitted while link was down is lost).
+const reattachURL = "/exec/reattach"
+
+// pendingWrite - Data sent to gdb or inferior stdin while link was down
+type pendingWrite struct {
+	event string
+	args  []interface{}
+}
+
+// reattachArgs - Arguments of reattach request, offsets are the number of
+// output messages of each stream already received (IOW not to send again)
+type reattachArgs struct {
+	CmdID          string `json:"cmdID"`
+	OutOffset      int    `json:"outOffset"`
+	InferiorOffset int    `json:"inferiorOutOffset"`
+}
+
+// reattachError - Reattach refused by XDS agent (unsupported request or
+// unknown command), IOW command cannot be resumed
+type reattachError struct {
+	msg string
+}
+
+func (e *reattachError) Error() string {
+	return e.msg
+}
+
+func (s linkState) String() string {
+	switch s {
+	case linkConnected:
+		return "connected"
+	case linkReconnecting:
+		return "reconnecting"
+	case linkServerLost:
+		return "server-lost"
+	case linkClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+//***** Private functions *****
+
+func (g *GdbXds) setLinkState(st linkState) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.linkState != st {
+		g.log.Debugf("Link state: %v -> %v", g.linkState, st)
+	}
+	g.linkState = st
+}
+
+// isCmdEvent returns true when event belongs to current command
+// (IOW ignore events of other commands after a reconnection)
+func (g *GdbXds) isCmdEvent(cmdID string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.cmdID == "" || cmdID == "" || cmdID == g.cmdID
+}
+
+// outputReceived counts output messages of current command (IOW resume
+// offsets used to reattach to command)
+func (g *GdbXds) outputReceived(event string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if event == xaapiv1.ExecInferiorOutEvent {
+		g.inferiorOutCount++
+	} else {
+		g.outCount++
+	}
+}
+
+// resume resumes command cmdID after a reconnection: it is reattached when
+// XDS agent supports it
+func (g *GdbXds) resume(cmdID string) error {
+	if !g.link.Supports(capReattach) {
+		g.log.Warnf("XDS agent doesn't support %s, output emitted while disconnected is lost", capReattach)
+		return nil
+	}
+	return g.reattach(cmdID)
+}
+
+// reattach reattaches to command cmdID on XDS agent, output emitted since
+// last received message is sent again on the new events channel
+func (g *GdbXds) reattach(cmdID string) error {
+	g.mutex.Lock()
+	args := reattachArgs{CmdID: cmdID, OutOffset: g.outCount, InferiorOffset: g.inferiorOutCount}
+	g.mutex.Unlock()
+
+	g.log.Debugf("POST %s %v", reattachURL, args)
+	res := xaapiv1.ExecResult{}
+	if err := g.link.Request("POST", reattachURL, args, &res); err != nil {
+		if code := httpStatus(err); code != 0 && !isUnavailableStatus(code) {
+			return &reattachError{fmt.Sprintf("XDS agent cannot reattach to command %s (%v)", cmdID, err)}
+		}
+		return err
+	}
+	if res.CmdID != cmdID {
+		return &reattachError{fmt.Sprintf("Cannot reattach to command %s (agent returned '%s')", cmdID, res.CmdID)}
+	}
+	return nil
+}
+
+// emit sends data on events channel or buffers it when link is down
+func (g *GdbXds) emit(event string, args ...interface{}) error {
+	g.mutex.Lock()
+	switch g.linkState {
+	case linkReconnecting, linkServerLost:
+		g.pendingWrites = append(g.pendingWrites, pendingWrite{event: event, args: args})
+		g.mutex.Unlock()
+		g.log.Debugf("Link down, buffer %s %v", event, args)
+		return nil
+	case linkClosed:
+		g.mutex.Unlock()
+		return fmt.Errorf("connection closed")
+	}
+	link := g.link
+	g.mutex.Unlock()
+
+	if link == nil {
+		return fmt.Errorf("not connected")
+	}
+	return link.Send(event, args...)
+}
+
+// flushPendingWrites replays data buffered while link was down
+func (g *GdbXds) flushPendingWrites() {
+	g.mutex.Lock()
+	pending := g.pendingWrites
+	g.pendingWrites = []pendingWrite{}
+	g.mutex.Unlock()
+
+	for _, w := range pending {
+		g.log.Debugf("Replay %s %v", w.event, w.args)
+		if err := g.emit(w.event, w.args...); err != nil {
+			g.log.Errorf("Error while replaying buffered data: %v", err)
+		}
+	}
+}
+
+// reconnect tries to reopen events channel (using same session) and to
+// reattach to the running command with exponential backoff till
+// reconnectTimeout is reached
+func (g *GdbXds) reconnect(cause error) {
+	g.mutex.Lock()
+	if g.linkState == linkReconnecting || g.linkState == linkClosed {
+		g.mutex.Unlock()
+		return
+	}
+	g.linkState = linkReconnecting
+	cmdID := g.cmdID
+	g.mutex.Unlock()
+
+	if g.reconnectTimeout == 0 || cmdID == "" {
+		g.disconnected(cause)
+		return
+	}
+
+	g.log.Warnf("XDS-Agent disconnected (%v), try to reconnect during %v", cause, g.reconnectTimeout)
+	fmt.Fprintf(os.Stderr, "\nXDS-Agent disconnected, trying to reconnect...\n")
+
+	delay := reconnectMinDelay
+	deadline := time.Now().Add(g.reconnectTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(delay)
+
+		g.mutex.Lock()
+		closed := g.linkState == linkClosed
+		g.mutex.Unlock()
+		if closed {
+			return
+		}
+
+		// Check that agent is back and still knows our session
+		ver := xaapiv1.XDSVersion{}
+		err := g.link.Request("GET", "/version", nil, &ver)
+		if err == nil {
+			err = g.connectEvents()
+		}
+		if err == nil {
+			err = g.resume(cmdID)
+		}
+		if err == nil {
+			g.log.Infof("XDS-Agent reconnected, resume command %s", cmdID)
+			fmt.Fprintf(os.Stderr, "XDS-Agent reconnected\n")
+			g.setLinkState(linkConnected)
+			g.flushPendingWrites()
+			return
+		}
+		g.link.Close()
+		if _, ok := err.(*reattachError); ok {
+			// retrying cannot help
+			g.log.Errorf("Reconnection failed: %v", err)
+			g.disconnected(err)
+			return
+		}
+		g.log.Debugf("Reconnection failed: %v (next retry in %v)", err, delay)
+
+		if delay *= 2; delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+
+	g.disconnected(cause)
+}
+
+// disconnected definitively closes the link, data buffered while link was
+// down is dropped
+func (g *GdbXds) disconnected(cause error) {
+	g.setLinkState(linkClosed)
+	g.stopHeartbeat()
+	g.mutex.Lock()
+	cb := g.cbOnDisconnect
+	lost := len(g.pendingWrites)
+	g.pendingWrites = []pendingWrite{}
+	g.mutex.Unlock()
+	if lost > 0 {
+		g.log.Warnf("%d writes buffered while XDS-Agent was disconnected are lost", lost)
+		fmt.Fprintf(os.Stderr, "XDS-Agent disconnected, %d inputs typed meanwhile are lost\n", lost)
+	}
+	if cb != nil {
+		cb(cause)
+	}
+}
+
+// serverConnectionChanged handles XDS server connection status change
+func (g *GdbXds) serverConnectionChanged(connected bool) {
+	g.mutex.Lock()
+	state := g.linkState
+	g.mutex.Unlock()
+
+	if connected {
+		if state == linkServerLost {
+			g.mutex.Lock()
+			if g.serverLostTimer != nil {
+				g.serverLostTimer.Stop()
+				g.serverLostTimer = nil
+			}
+			g.mutex.Unlock()
+			g.log.Infof("XDS Server reconnected")
+			fmt.Fprintf(os.Stderr, "XDS Server reconnected\n")
+			g.setLinkState(linkConnected)
+			g.flushPendingWrites()
+		}
+		return
+	}
+
+	if state != linkConnected {
+		return
+	}
+	if g.reconnectTimeout == 0 {
+		g.serverLost()
+		return
+	}
+
+	g.log.Warnf("XDS Server disconnected, wait reconnection during %v", g.reconnectTimeout)
+	fmt.Fprintf(os.Stderr, "\nXDS Server disconnected, waiting reconnection...\n")
+	g.setLinkState(linkServerLost)
+	g.mutex.Lock()
+	g.serverLostTimer = time.AfterFunc(g.reconnectTimeout, func() {
+		g.mutex.Lock()
+		stillLost := g.linkState == linkServerLost
+		g.mutex.Unlock()
+		if stillLost {
+			g.serverLost()
+		}
+	})
+	g.mutex.Unlock()
+}
+
+// serverLost reports a definitive XDS server disconnection
+func (g *GdbXds) serverLost() {
+	g.setLinkState(linkClosed)
+	g.stopHeartbeat()
+	g.mutex.Lock()
+	cb := g.cbOnExit
+	g.mutex.Unlock()
+	if cb != nil {
+		cb(-1, fmt.Errorf("XDS Server disconnected"))
+	} else {
+		fmt.Printf("XDS Server disconnected")
+		os.Exit(-1)
+	}
+}
@@ -0,0 +1,199 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+// resolveProject returns the project referenced by prjID (within selected
+// server if any): exact ID, else unique label, else unique ID prefix
+func (g *GdbXds) resolveProject() (*xaapiv1.ProjectConfig, error) {
+	candidates := []int{}
+	labels := []int{}
+	matches := []int{}
+	for i, p := range g.projects {
+		if g.svrIdx >= 0 && g.svrIdx < len(g.servers) && p.ServerID != g.servers[g.svrIdx].ID {
+			continue
+		}
+		candidates = append(candidates, i)
+		if p.ID == g.prjID {
+			return &g.projects[i], nil
+		}
+		if strings.EqualFold(p.Label, g.prjID) {
+			labels = append(labels, i)
+		} else if strings.HasPrefix(p.ID, g.prjID) {
+			matches = append(matches, i)
+		}
+	}
+
+	// an exact label takes precedence over ID prefixes
+	if len(labels) > 0 {
+		matches = labels
+	}
+	if len(matches) == 1 {
+		return &g.projects[matches[0]], nil
+	}
+	desc := func(idx []int) string {
+		lst := ""
+		for _, i := range idx {
+			lst += fmt.Sprintf("\n  %s (label: %s, server: %s)", g.projects[i].ID, g.projects[i].Label, g.projects[i].ServerID)
+		}
+		return lst
+	}
+	if len(matches) > 1 {
+		return nil, fmt.Errorf("Ambiguous project '%s', matching projects:%s", g.prjID, desc(matches))
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("Unknown project '%s' (no project defined)", g.prjID)
+	}
+	return nil, fmt.Errorf("Unknown project '%s', existing projects:%s", g.prjID, desc(candidates))
+}
+
+// resolveSdk returns the SDK referenced by sdkID: exact ID, else unique
+// name, else unique ID prefix or name/version/arch/profile terms
+// (eg. "aarch64 4.0.1")
+func resolveSdk(sdkID string, sdks []xaapiv1.SDK) (*xaapiv1.SDK, error) {
+	names := []int{}
+	matches := []int{}
+	for i, s := range sdks {
+		if s.ID == sdkID {
+			return &sdks[i], nil
+		}
+		if strings.EqualFold(s.Name, sdkID) {
+			names = append(names, i)
+		} else if strings.HasPrefix(s.ID, sdkID) || sdkMatchTerms(s, sdkID) {
+			matches = append(matches, i)
+		}
+	}
+
+	// an exact name takes precedence over other matches
+	if len(names) > 0 {
+		matches = names
+	}
+	if len(matches) == 1 {
+		return &sdks[matches[0]], nil
+	}
+	desc := func(idx []int) string {
+		lst := ""
+		for _, i := range idx {
+			lst += fmt.Sprintf("\n  %s (name: %s, version: %s, arch: %s)", sdks[i].ID, sdks[i].Name, sdks[i].Version, sdks[i].Arch)
+		}
+		return lst
+	}
+	if len(matches) > 1 {
+		return nil, fmt.Errorf("Ambiguous SDK '%s', matching SDKs:%s", sdkID, desc(matches))
+	}
+	all := []int{}
+	for i := range sdks {
+		all = append(all, i)
+	}
+	if len(all) == 0 {
+		return nil, fmt.Errorf("Unknown SDK '%s' (no SDK installed)", sdkID)
+	}
+	return nil, fmt.Errorf("Unknown SDK '%s', installed SDKs:%s", sdkID, desc(all))
+}
+
+// sdkMatchTerms returns true when all terms of query (separated by space,
+// comma or slash) match SDK name, version, arch or profile
+func sdkMatchTerms(s xaapiv1.SDK, query string) bool {
+	terms := strings.FieldsFunc(query, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '/'
+	})
+	for _, t := range terms {
+		if !strings.EqualFold(t, s.Name) && !strings.EqualFold(t, s.Version) &&
+			!strings.EqualFold(t, s.Arch) && !strings.EqualFold(t, s.Profile) {
+			return false
+		}
+	}
+	return len(terms) > 0
+}
+
+// pickProject interactively selects project (within selected server if any)
+func (g *GdbXds) pickProject() error {
+	ids := []string{}
+	for _, p := range g.projects {
+		ids = append(ids, p.ID)
+	}
+	prefixes := shortestPrefixes(ids)
+
+	candidates := []int{}
+	rows := [][]string{}
+	for i, p := range g.projects {
+		if g.svrIdx >= 0 && g.svrIdx < len(g.servers) && p.ServerID != g.servers[g.svrIdx].ID {
+			continue
+		}
+		candidates = append(candidates, i)
+		rows = append(rows, []string{prefixes[p.ID], p.Label, p.ServerID, p.ClientPath})
+	}
+	n, err := g.picker.Pick("project", []string{"ID", "Label", "Server", "Path"}, rows)
+	if err != nil {
+		return err
+	}
+	g.prjID = g.projects[candidates[n]].ID
+	return nil
+}
+
+// pickSdk interactively selects SDK
+func (g *GdbXds) pickSdk(sdks []xaapiv1.SDK) error {
+	ids := []string{}
+	for _, s := range sdks {
+		ids = append(ids, s.ID)
+	}
+	prefixes := shortestPrefixes(ids)
+
+	rows := [][]string{}
+	for _, s := range sdks {
+		rows = append(rows, []string{prefixes[s.ID], s.Name, s.Version, s.Arch})
+	}
+	n, err := g.picker.Pick("SDK", []string{"ID", "Name", "Version", "Arch"}, rows)
+	if err != nil {
+		return err
+	}
+	g.sdkID = sdks[n].ID
+	return nil
+}
+
+// shortestPrefixes returns the shortest unique prefix of each ID
+func shortestPrefixes(ids []string) map[string]string {
+	prefixes := make(map[string]string)
+	for _, id := range ids {
+		l := 1
+		for _, other := range ids {
+			if other == id {
+				continue
+			}
+			common := 0
+			for common < len(id) && common < len(other) && id[common] == other[common] {
+				common++
+			}
+			if common+1 > l {
+				l = common + 1
+			}
+		}
+		if l > len(id) {
+			l = len(id)
+		}
+		prefixes[id] = id[:l]
+	}
+	return prefixes
+}
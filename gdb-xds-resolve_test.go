@@ -0,0 +1,143 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+func TestGdbXdsResolveProject(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+	a.projects = append(a.projects, xaapiv1.ProjectConfig{ID: "0a1bffff-0000", ServerID: "fake-server", Label: "HelloWorld"})
+	a.projects = append(a.projects, xaapiv1.ProjectConfig{ID: "cafe0000-0000", ServerID: "fake-server", Label: "1a2b"})
+
+	for prjID, exp := range map[string]string{
+		a.projects[1].ID: a.projects[1].ID,
+		"1":              a.projects[1].ID,
+		"another-prj":    a.projects[1].ID,
+		"0a1b2":          a.projects[0].ID,
+		"0a1bffff-0000":  "0a1bffff-0000",
+		"1a2b":           "cafe0000-0000",
+	} {
+		g := newTestGdbXds(t, a, map[string]string{"prjID": prjID})
+		if code, err := g.Init(); code != 0 || err != nil {
+			t.Errorf("Init failed for project %s: code=%d err=%v", prjID, code, err)
+			continue
+		}
+		if g.prjID != exp || g.project == nil || g.project.ID != exp {
+			t.Errorf("Invalid project resolved from %s: %s", prjID, g.prjID)
+		}
+	}
+
+	for prjID, exp := range map[string]string{
+		"0a1b":       "Ambiguous project '0a1b', matching projects:\n  " + a.projects[0].ID,
+		"helloworld": "Ambiguous project 'helloworld'",
+		"unknown":    "Unknown project 'unknown', existing projects:\n  " + a.projects[0].ID + " (label: helloworld, server: fake-server)",
+	} {
+		g := newTestGdbXds(t, a, map[string]string{"prjID": prjID})
+		if code, err := g.Init(); code != int(syscall.EINVAL) || err == nil || !strings.Contains(err.Error(), exp) {
+			t.Errorf("Unexpected result for project %s: code=%d err=%v", prjID, code, err)
+		}
+	}
+}
+
+func TestGdbXdsResolveSdk(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+	a.sdks[0][0].Version = "4.0.1"
+	a.sdks[0][1].Version = "4.0.1"
+	a.sdks[0] = append(a.sdks[0], xaapiv1.SDK{ID: "ef23c1b7-0000", Name: "poky-agl_aarch64_5.0.0", Arch: "aarch64", Version: "5.0.0"})
+	a.sdks[0] = append(a.sdks[0], xaapiv1.SDK{ID: "c0ffee00-0000", Name: "poky-agl_aarch64_5.0.0", Arch: "aarch64-dup", Version: "5.0.0-dup"})
+
+	for sdkID, exp := range map[string]string{
+		a.sdks[0][1].ID:          a.sdks[0][1].ID,
+		"a8":                     a.sdks[0][1].ID,
+		"POKY-AGL_aarch64_4.0.1": a.sdks[0][0].ID,
+		"corei7-64":              a.sdks[0][1].ID,
+		"aarch64 4.0.1":          a.sdks[0][0].ID,
+		"5.0.0/aarch64":          "ef23c1b7-0000",
+	} {
+		g := newTestGdbXds(t, a, map[string]string{"sdkID": sdkID})
+		if code, err := g.Init(); code != 0 || err != nil {
+			t.Errorf("Init failed for SDK %s: code=%d err=%v", sdkID, code, err)
+			continue
+		}
+		if g.sdkID != exp {
+			t.Errorf("Invalid SDK resolved from %s: %s", sdkID, g.sdkID)
+		}
+	}
+
+	for sdkID, exp := range map[string]string{
+		"ef23":                   "Ambiguous SDK 'ef23', matching SDKs:\n  " + a.sdks[0][0].ID + " (name: poky-agl_aarch64_4.0.1, version: 4.0.1, arch: aarch64)",
+		"aarch64":                "Ambiguous SDK 'aarch64'",
+		"poky-agl_aarch64_5.0.0": "Ambiguous SDK 'poky-agl_aarch64_5.0.0', matching SDKs:\n  ef23c1b7-0000 (name: poky-agl_aarch64_5.0.0, version: 5.0.0, arch: aarch64)\n  c0ffee00-0000",
+		"x86_64":                 "Unknown SDK 'x86_64', installed SDKs:",
+	} {
+		g := newTestGdbXds(t, a, map[string]string{"sdkID": sdkID})
+		if code, err := g.Init(); code != int(syscall.EINVAL) || err == nil || !strings.Contains(err.Error(), exp) {
+			t.Errorf("Unexpected result for SDK %s: code=%d err=%v", sdkID, code, err)
+		}
+	}
+}
+
+func TestShortestPrefixes(t *testing.T) {
+	p := shortestPrefixes([]string{"abcd", "abef", "b123", "abcd-long"})
+	for id, exp := range map[string]string{"abcd": "abcd", "abef": "abe", "b123": "b", "abcd-long": "abcd-"} {
+		if p[id] != exp {
+			t.Errorf("Invalid prefix of %s: %s (expected %s)", id, p[id], exp)
+		}
+	}
+}
+
+func TestGdbXdsPicker(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+
+	var out bytes.Buffer
+	g := newTestGdbXds(t, a, map[string]string{"prjID": "", "sdkID": ""})
+	g.SetConfig("picker", NewPicker(strings.NewReader("another\n1\ncorei7\n1\n\n"), &out, nil))
+	if code, err := g.Init(); code != 0 || err != nil {
+		t.Fatalf("Init failed: code=%d err=%v\n%s", code, err, out.String())
+	}
+	if g.prjID != a.projects[1].ID || g.sdkID != a.sdks[0][1].ID {
+		t.Errorf("Invalid selection: project=%s sdk=%s", g.prjID, g.sdkID)
+	}
+
+	// Abort selection
+	g = newTestGdbXds(t, a, map[string]string{"prjID": ""})
+	g.SetConfig("picker", NewPicker(strings.NewReader("\n"), &out, nil))
+	if code, err := g.Init(); code != int(syscall.EINVAL) || err == nil {
+		t.Errorf("Unexpected result: code=%d err=%v", code, err)
+	}
+
+	// List mode never uses picker
+	g = newTestGdbXds(t, a, map[string]string{"prjID": ""})
+	g.SetConfig("listProject", true)
+	g.SetConfig("picker", NewPicker(strings.NewReader("1\n"), &out, nil))
+	captureStdout(t, func() { g.Init() })
+	if g.prjID != "" {
+		t.Errorf("Project must not be selected in list mode")
+	}
+}
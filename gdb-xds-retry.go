@@ -0,0 +1,110 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Delays between attempts of requests to xds-agent: doubled after each
+// failure (exponential backoff) and capped
+const (
+	retryMinDelay = 250 * time.Millisecond
+	retryMaxDelay = 5 * time.Second
+)
+
+// isTransientError returns true when err may be caused by an xds-agent that
+// is not ready yet (eg. still starting or not yet connected to xds-server):
+// 502, 503, 504 HTTP status, connection failure or timeout
+func isTransientError(err error) bool {
+	if err == nil || isTLSError(err) {
+		return false
+	}
+	if code := httpStatus(err); code != 0 {
+		return isUnavailableStatus(code)
+	}
+	err = netError(err)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
+	}
+	_, ok := err.(*net.OpError)
+	return ok
+}
+
+// isUnprocessedError returns true when err is a transient error where request
+// was not processed by xds-agent, IOW it can be sent again without side effect
+// (connection failure or 502, 503, 504 HTTP status)
+func isUnprocessedError(err error) bool {
+	if err == nil || isTLSError(err) {
+		return false
+	}
+	if code := httpStatus(err); code != 0 {
+		return isUnavailableStatus(code)
+	}
+	oe, ok := netError(err).(*net.OpError)
+	return ok && oe.Op == "dial"
+}
+
+//***** Private functions *****
+
+// retry calls f until it succeeds or returns an error that is not retryable,
+// for at most retry timeout (XDS_RETRY_TIMEOUT, 0 means a single attempt)
+func (g *GdbXds) retry(what string, retryable func(error) bool, f func() error) error {
+	start := time.Now()
+	delay := retryMinDelay
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || !retryable(err) {
+			return err
+		}
+		if time.Since(start)+delay > g.retryTimeout {
+			if attempt > 1 {
+				g.log.Warnf("%s failed after %d attempts in %v", what, attempt, time.Since(start))
+			}
+			return err
+		}
+		g.log.Infof("%s failed (%v), retry in %v", what, err, delay)
+		time.Sleep(delay)
+		if delay *= 2; delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+}
+
+// isUnavailableStatus returns true when HTTP status code means that xds-agent
+// (or a proxy) cannot serve requests for now
+func isUnavailableStatus(code int) bool {
+	return code == http.StatusBadGateway || code == http.StatusServiceUnavailable || code == http.StatusGatewayTimeout
+}
+
+// netError returns error of the connection used by a request (IOW error
+// wrapped by HTTP client)
+func netError(err error) error {
+	if ue, ok := err.(*url.Error); ok {
+		return ue.Err
+	}
+	return err
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsTransientError(t *testing.T) {
+	dialErr := func(network string, errno syscall.Errno) error {
+		return &url.Error{Op: "Get", URL: "http://localhost:8800/api/v1/version",
+			Err: &net.OpError{Op: "dial", Net: network, Err: &os.SyscallError{Syscall: "connect", Err: errno}}}
+	}
+	for _, tc := range []struct {
+		err                    error
+		transient, unprocessed bool
+	}{
+		{dialErr("tcp", syscall.ECONNREFUSED), true, true},
+		{dialErr("unix", syscall.ENOENT), true, true},
+		{&httpStatusError{code: 503, msg: "HTTP status 503 Service Unavailable: server not connected"}, true, true},
+		{&url.Error{Op: "Get", Err: &net.DNSError{Err: "timeout", Name: "agent", IsTimeout: true}}, true, false},
+		{&net.OpError{Op: "read", Net: "tcp", Err: &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}}, true, false},
+		{&url.Error{Op: "Post", Err: io.EOF}, true, false},
+		{&httpStatusError{code: 401, msg: "HTTP status 401 Unauthorized: connection refused"}, false, false},
+		{&httpStatusError{code: 500, msg: "HTTP status 500 Internal Server Error: 503"}, false, false},
+		{&url.Error{Op: "Get", Err: x509.UnknownAuthorityError{}}, false, false},
+		{fmt.Errorf("dial tcp 127.0.0.1:8800: connect: connection refused"), false, false},
+		{nil, false, false},
+	} {
+		if isTransientError(tc.err) != tc.transient || isUnprocessedError(tc.err) != tc.unprocessed {
+			t.Errorf("Unexpected result for %v: transient=%v unprocessed=%v", tc.err, isTransientError(tc.err), isUnprocessedError(tc.err))
+		}
+	}
+}
+
+func TestGdbXdsRetryInit(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "xds-gdb-test")
+	defer os.RemoveAll(dir)
+	sock := path.Join(dir, "xds-agent.sock")
+
+	// agent is started while Init waits for it
+	a := newFakeAgentUnstarted(t)
+	defer a.Close()
+	g := newTestGdbXds(t, a, map[string]string{"agentURL": "unix://" + sock, "retryTimeout": "10"})
+	res := make(chan error)
+	go func() {
+		code, err := g.Init()
+		if code != 0 && err == nil {
+			err = fmt.Errorf("code=%d", code)
+		}
+		res <- err
+	}()
+
+	time.Sleep(600 * time.Millisecond)
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.srv.Listener.Close()
+	a.srv.Listener = l
+	a.srv.Start()
+	if err := <-res; err != nil {
+		t.Fatalf("Init failed while agent is starting: %v", err)
+	}
+	a.WaitSocket()
+
+	// retries stop after retry timeout
+	g = newTestGdbXds(t, a, map[string]string{"agentURL": "http://localhost:1", "retryTimeout": "1"})
+	start := time.Now()
+	if code, err := g.Init(); code != int(syscallEBADE) || err == nil {
+		t.Errorf("Init should fail: code=%d err=%v", code, err)
+	}
+	if d := time.Since(start); d < 500*time.Millisecond || d > 2*time.Second {
+		t.Errorf("Unexpected retry duration %v", d)
+	}
+}
+
+func TestGdbXdsRetryStart(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+
+	g := newTestGdbXds(t, a, map[string]string{"retryTimeout": "10"})
+	defer g.Close()
+	if code, err := g.Init(); code != 0 || err != nil {
+		t.Fatalf("Init failed: code=%d err=%v", code, err)
+	}
+	a.WaitSocket()
+
+	a.FailRequest("/exec", http.StatusServiceUnavailable)
+	time.AfterFunc(400*time.Millisecond, func() { a.FailRequest("/exec", 0) })
+	if code, err := g.Start(false); code != 0 || err != nil {
+		t.Fatalf("Start failed: code=%d err=%v", code, err)
+	}
+	if n := len(a.ExecArgs()); n != 1 {
+		t.Errorf("Command executed %d times", n)
+	}
+
+	// request that may have been processed is not sent again
+	a.FailRequest("/exec", http.StatusInternalServerError)
+	start := time.Now()
+	if code, err := g.Start(false); code != int(syscall.EAGAIN) || err == nil {
+		t.Errorf("Start should fail: code=%d err=%v", code, err)
+	}
+	if d := time.Since(start); d > retryMinDelay {
+		t.Errorf("Start error retried (%v)", d)
+	}
+}
+
+func TestGdbXdsProxy(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+
+	var hits int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		http.Error(w, "Proxy error", http.StatusBadGateway)
+	}))
+	defer proxy.Close()
+
+	g := newTestGdbXds(t, a, map[string]string{"proxy": proxy.URL})
+	if code, err := g.Init(); code != int(syscallEBADE) || err == nil {
+		t.Errorf("Init should fail through proxy: code=%d err=%v", code, err)
+	}
+	if atomic.LoadInt32(&hits) == 0 {
+		t.Errorf("Proxy not used")
+	}
+
+	g = newTestGdbXds(t, a, map[string]string{"proxy": "none"})
+	if code, err := g.Init(); code != 0 || err != nil {
+		t.Fatalf("Init failed without proxy: code=%d err=%v", code, err)
+	}
+	a.WaitSocket()
+
+	if err := g.SetConfig("proxy", "http://"); err == nil {
+		t.Errorf("Invalid proxy not reported")
+	}
+	if err := g.SetConfig("requestTimeout", "-1"); err == nil {
+		t.Errorf("Invalid timeout not reported")
+	}
+}
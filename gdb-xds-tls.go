@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+)
+
+// agentTLS - TLS settings of connections to xds-agent (https and wss)
+type agentTLS struct {
+	caFile   string // CA bundle used to verify agent certificate (default: system CAs)
+	certFile string // client certificate
+	keyFile  string // client certificate key
+	insecure bool   // skip verification of agent certificate (lab setups only)
+}
+
+// isSet returns true when at least one TLS setting is defined
+func (t *agentTLS) isSet() bool {
+	return t.caFile != "" || t.certFile != "" || t.keyFile != "" || t.insecure
+}
+
+// config returns the TLS client config built from settings
+func (t *agentTLS) config() (*tls.Config, error) {
+	conf := &tls.Config{InsecureSkipVerify: t.insecure}
+
+	if t.caFile != "" {
+		data, err := ioutil.ReadFile(t.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read CA bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("No valid PEM certificate found in CA bundle %s", t.caFile)
+		}
+		conf.RootCAs = pool
+	}
+
+	if t.certFile != "" || t.keyFile != "" {
+		if t.certFile == "" || t.keyFile == "" {
+			return nil, fmt.Errorf("Both client certificate (XDS_TLS_CERT_FILE) and key (XDS_TLS_KEY_FILE) must be set")
+		}
+		cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot load client certificate %s: %v", t.certFile, err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// isTLSError returns true when err is a TLS handshake or certificate error
+func isTLSError(err error) bool {
+	for err != nil {
+		switch e := err.(type) {
+		case x509.UnknownAuthorityError, x509.CertificateInvalidError, x509.HostnameError, tls.RecordHeaderError:
+			return true
+		case *url.Error:
+			err = e.Err
+		case *net.OpError:
+			// alert sent by agent during handshake (eg. client certificate rejected)
+			if e.Op == "remote error" {
+				return true
+			}
+			err = e.Err
+		case interface {
+			Unwrap() error
+		}:
+			// eg. tls.CertificateVerificationError of recent Go versions
+			err = e.Unwrap()
+		default:
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeTestClientCert writes a self-signed client certificate and its key
+func writeTestClientCert(t *testing.T, dir string) (string, string, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "xds-gdb-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	certFile := writeTestFile(t, dir, "client.crt", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	keyFile := writeTestFile(t, dir, "client.key", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})))
+	return certFile, keyFile, pool
+}
+
+func TestGdbXdsTLS(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "xds-gdb-test")
+	defer os.RemoveAll(dir)
+	certFile, keyFile, clientCAs := writeTestClientCert(t, dir)
+
+	a := newFakeAgentTLS(t, nil)
+	defer a.Close()
+	caFile := writeTestFile(t, dir, "ca.pem",
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: a.srv.TLS.Certificates[0].Certificate[0]})))
+
+	for _, conf := range []map[string]string{
+		{"tlsCAFile": caFile},
+		{"tlsInsecure": "true"},
+		{"agentURL": strings.Replace(a.URL(), "https://", "wss://", 1), "tlsCAFile": caFile},
+	} {
+		g := newTestGdbXds(t, a, conf)
+		if code, err := g.Init(); code != 0 || err != nil {
+			t.Errorf("Init failed with %v: code=%d err=%v", conf, code, err)
+			continue
+		}
+		a.WaitSocket()
+	}
+
+	for _, tc := range []struct {
+		conf map[string]string
+		code int
+		err  string
+	}{
+		{map[string]string{}, int(syscallEBADE), "check XDS_TLS_CA_FILE"},
+		{map[string]string{"tlsCAFile": certFile + ".missing"}, int(syscall.EINVAL), "Cannot read CA bundle"},
+		{map[string]string{"tlsCAFile": keyFile}, int(syscall.EINVAL), "No valid PEM certificate"},
+		{map[string]string{"tlsCAFile": caFile, "tlsCertFile": certFile}, int(syscall.EINVAL), "Both client certificate"},
+		{map[string]string{"tlsCAFile": caFile, "tlsCertFile": certFile, "tlsKeyFile": caFile}, int(syscall.EINVAL), "Cannot load client certificate"},
+	} {
+		g := newTestGdbXds(t, a, tc.conf)
+		if code, err := g.Init(); code != tc.code || err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("Unexpected result with %v: code=%d err=%v", tc.conf, code, err)
+		}
+	}
+
+	// client certificate required by agent
+	a2 := newFakeAgentTLS(t, clientCAs)
+	defer a2.Close()
+	caFile2 := writeTestFile(t, dir, "ca2.pem",
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: a2.srv.TLS.Certificates[0].Certificate[0]})))
+	g := newTestGdbXds(t, a2, map[string]string{"tlsCAFile": caFile2})
+	if code, err := g.Init(); code != int(syscallEBADE) || err == nil {
+		t.Errorf("Init should fail without client certificate: code=%d err=%v", code, err)
+	}
+	g = newTestGdbXds(t, a2, map[string]string{"tlsCAFile": caFile2, "tlsCertFile": certFile, "tlsKeyFile": keyFile})
+	if code, err := g.Init(); code != 0 || err != nil {
+		t.Fatalf("Init failed with client certificate: code=%d err=%v", code, err)
+	}
+	so := a2.WaitSocket()
+	if so.Request().TLS == nil || len(so.Request().TLS.PeerCertificates) == 0 {
+		t.Errorf("io.socket connection doesn't use client certificate")
+	}
+
+	if err := g.SetConfig("tlsInsecure", "maybe"); err == nil {
+		t.Errorf("Invalid tlsInsecure value not reported")
+	}
+}
+
+func TestIsTLSError(t *testing.T) {
+	for _, tc := range []struct {
+		err error
+		tls bool
+	}{
+		{&url.Error{Op: "Get", Err: x509.UnknownAuthorityError{}}, true},
+		{&url.Error{Op: "Get", Err: x509.HostnameError{Host: "agent"}}, true},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: x509.CertificateInvalidError{Reason: x509.Expired}}, true},
+		{tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, true},
+		{&url.Error{Op: "Get", Err: &net.OpError{Op: "remote error", Err: fmt.Errorf("bad certificate")}}, true},
+		{&url.Error{Op: "Get", Err: &net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")}}, false},
+		{fmt.Errorf("x509: certificate signed by unknown authority"), false},
+		{nil, false},
+	} {
+		if isTLSError(tc.err) != tc.tls {
+			t.Errorf("Unexpected result for %v: %v", tc.err, !tc.tls)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+// memTransport - In-memory transport: REST requests are served by fake agent
+// handlers without network, data sent is queued on sent channel, events
+// are injected using deliver and ping answer is set using setPing
+type memTransport struct {
+	a    *fakeAgent
+	sent chan wsMessage
+
+	mutex     sync.Mutex
+	h         *transportHandler
+	pingDelay time.Duration
+	pingErr   error
+}
+
+func newMemTransport(a *fakeAgent) *memTransport {
+	return &memTransport{a: a, sent: make(chan wsMessage, 10)}
+}
+
+func (m *memTransport) SessionID() string {
+	return m.a.sid
+}
+
+func (m *memTransport) Request(method, url string, in, out interface{}) error {
+	body, _ := json.Marshal(in)
+	rec := httptest.NewRecorder()
+	m.a.serveAPI(rec, httptest.NewRequest(method, "/api/v1"+url, bytes.NewReader(body)))
+	if rec.Code != 200 {
+		return &httpStatusError{code: rec.Code, msg: fmt.Sprintf("HTTP status %d: %s", rec.Code, rec.Body.String())}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(rec.Body.Bytes(), out)
+}
+
+func (m *memTransport) Connect(h transportHandler) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.h = &h
+	return nil
+}
+
+func (m *memTransport) Subscribe(event string) error {
+	return m.Request("POST", "/events/register", xaapiv1.EventRegisterArgs{Name: event}, nil)
+}
+
+func (m *memTransport) Send(event string, args ...interface{}) error {
+	data, _ := json.Marshal(args[0])
+	m.sent <- wsMessage{Event: event, Data: data}
+	return nil
+}
+
+func (m *memTransport) Signal(args xaapiv1.ExecSignalArgs) error {
+	return m.Request("POST", "/signal", args, nil)
+}
+
+func (m *memTransport) Supports(capability string) bool {
+	return m.a.supports(capability)
+}
+
+func (m *memTransport) Ping(timeout time.Duration) error {
+	m.mutex.Lock()
+	delay, err := m.pingDelay, m.pingErr
+	m.mutex.Unlock()
+	if delay > timeout {
+		time.Sleep(timeout)
+		return fmt.Errorf("no answer within %v", timeout)
+	}
+	time.Sleep(delay)
+	return err
+}
+
+func (m *memTransport) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.h = nil
+	return nil
+}
+
+// handler returns callbacks of events channel (nil when not connected)
+func (m *memTransport) handler() *transportHandler {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.h
+}
+
+// setPing sets delay and result of next pings
+func (m *memTransport) setPing(delay time.Duration, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.pingDelay = delay
+	m.pingErr = err
+}
+
+// deliver injects an event as if it was received from xds-agent
+func (m *memTransport) deliver(event string, data interface{}) {
+	raw, _ := json.Marshal(data)
+	if h := m.handler(); h != nil {
+		h.onEvent(event, raw)
+	}
+}
+
+func TestGdbXdsMemoryTransport(t *testing.T) {
+	a := newFakeAgentUnstarted(t)
+	defer a.Close()
+	m := newMemTransport(a)
+	xdsTransports["memory"] = func(conf transportConfig) (xdsTransport, error) { return m, nil }
+	defer delete(xdsTransports, "memory")
+
+	g := newTestGdbXds(t, a, map[string]string{"transport": "memory", "agentURL": "memory", "reconnectTimeout": "0"})
+	if code, err := g.Init(); code != 0 || err != nil {
+		t.Fatalf("Init failed: code=%d err=%v", code, err)
+	}
+	if code, err := g.Start(false); code != 0 || err != nil {
+		t.Fatalf("Start failed: code=%d err=%v", code, err)
+	}
+
+	g.Write("-exec-run\n")
+	if msg := <-m.sent; msg.Event != xaapiv1.ExecInEvent || string(msg.Data) != `"-exec-run\n"` {
+		t.Errorf("Unexpected data sent: %s %s", msg.Event, msg.Data)
+	}
+	if err := g.SendSignal(syscall.SIGINT); err != nil {
+		t.Errorf("SendSignal failed: %v", err)
+	}
+
+	var out string
+	g.Read(func(timestamp, stdout, stderr string) { out += stdout })
+	m.deliver(xaapiv1.ExecOutEvent, xaapiv1.ExecOutMsg{CmdID: a.CmdID(), Stdout: "^running\n"})
+	m.deliver(xaapiv1.ExecOutEvent, xaapiv1.ExecOutMsg{CmdID: "other-cmd", Stdout: "ignored\n"})
+	if out != "^running\n" {
+		t.Errorf("Unexpected gdb output: %q", out)
+	}
+
+	discC := make(chan error, 1)
+	g.OnDisconnect(func(err error) { discC <- err })
+	m.handler().onDisconnect(fmt.Errorf("link lost"))
+	select {
+	case err := <-discC:
+		if err == nil {
+			t.Errorf("Disconnection error not reported")
+		}
+	case <-time.After(fakeAgentTimeout):
+		t.Fatal("Timeout while waiting disconnection")
+	}
+}
@@ -0,0 +1,324 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Event emitted by heartbeat on io.socket, acknowledged by xds-agent when
+// it advertises capEventPing
+const sioPingEvent = "heartbeat:ping"
+
+// Path of io.socket endpoint, only websocket transport of engine.io protocol
+// version 3 is used (IOW protocol of io.socket 1.x and 2.x)
+const sioPath = "/socket.io/?EIO=3&transport=websocket"
+
+// Engine.io packet types (first char of websocket messages)
+const (
+	eioOpen    = '0'
+	eioClose   = '1'
+	eioPing    = '2'
+	eioPong    = '3'
+	eioMessage = '4'
+)
+
+// Socket.io packet types (char following engine.io message type)
+const (
+	sioConnect    = '0'
+	sioDisconnect = '1'
+	sioEvent      = '2'
+	sioAck        = '3'
+	sioError      = '4'
+)
+
+// sioHandshake - Engine.io open packet sent by server
+type sioHandshake struct {
+	Sid          string `json:"sid"`
+	PingInterval int    `json:"pingInterval"` // in milliseconds
+	PingTimeout  int    `json:"pingTimeout"`  // in milliseconds
+}
+
+// sioTransport - Transport using io.socket for events channel and HTTP for
+// REST requests (protocol of current xds-agent).
+// Websocket connection of io.socket is opened by the transport itself (IOW
+// not using an io.socket client library) to use the dialer of transport
+// (TLS, unix socket, proxy and timeouts) and to be able to close it.
+type sioTransport struct {
+	*httpTransport
+	url    string
+	header http.Header
+	dialer *websocket.Dialer
+
+	mutex  sync.Mutex
+	wmutex sync.Mutex // only one concurrent writer is supported by websocket
+	conn   *websocket.Conn
+	ackID  int
+	acks   map[int]chan json.RawMessage // pending acknowledgements by packet ID
+}
+
+// newSioTransport creates an io.socket transport
+func newSioTransport(conf transportConfig) (xdsTransport, error) {
+	ht, err := newHTTPTransport(conf)
+	if err != nil {
+		return nil, err
+	}
+	url := "ws" + strings.TrimPrefix(conf.baseURL, "http") + sioPath
+	return &sioTransport{httpTransport: ht, url: url, header: conf.header, dialer: conf.dialer}, nil
+}
+
+// Connect opens a new io.socket connection, previous one (if any) is closed
+func (t *sioTransport) Connect(h transportHandler) error {
+	header := http.Header{}
+	for k, v := range t.header {
+		header[k] = v
+	}
+	header.Set("XDS-AGENT-SID", t.SessionID())
+
+	conn, resp, err := t.dialer.Dial(t.url, header)
+	if err != nil {
+		if resp != nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			return &httpStatusError{
+				code: resp.StatusCode,
+				msg:  fmt.Sprintf("IO.socket connection error: HTTP status %s: %s", resp.Status, strings.TrimSpace(string(body))),
+			}
+		}
+		return fmt.Errorf("IO.socket connection error: %v", err)
+	}
+
+	// First message is engine.io handshake (IOW ping settings)
+	timeout := t.dialer.HandshakeTimeout
+	if timeout == 0 {
+		timeout = defaultConnectTimeout
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	hs := sioHandshake{}
+	_, data, err := conn.ReadMessage()
+	if err == nil && (len(data) == 0 || data[0] != eioOpen) {
+		err = fmt.Errorf("unexpected packet %q", data)
+	}
+	if err == nil {
+		err = json.Unmarshal(data[1:], &hs)
+	}
+	if err == nil && hs.PingInterval <= 0 {
+		err = fmt.Errorf("invalid ping interval %d", hs.PingInterval)
+	}
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("IO.socket handshake error: %v", err)
+	}
+
+	t.Close()
+	t.mutex.Lock()
+	t.conn = conn
+	t.acks = make(map[int]chan json.RawMessage)
+	t.mutex.Unlock()
+
+	events := make(map[string]bool)
+	for _, ev := range h.events {
+		events[ev] = true
+	}
+	interval := time.Duration(hs.PingInterval) * time.Millisecond
+	timeout = time.Duration(hs.PingTimeout) * time.Millisecond
+	go t.readLoop(conn, interval+timeout, events, h)
+	go t.pingLoop(conn, interval)
+	return nil
+}
+
+// Send emits an event on io.socket
+func (t *sioTransport) Send(event string, args ...interface{}) error {
+	return t.emit(0, event, args...)
+}
+
+// Ping emits a ping event acknowledged by xds-agent (IOW checks events
+// channel itself rather than REST API) when xds-agent supports it, otherwise
+// sends a version request
+func (t *sioTransport) Ping(timeout time.Duration) error {
+	if !t.Supports(capEventPing) {
+		return t.httpTransport.Ping(timeout)
+	}
+
+	t.mutex.Lock()
+	t.ackID++
+	id := t.ackID
+	ackC := make(chan json.RawMessage, 1)
+	if t.acks != nil {
+		t.acks[id] = ackC
+	}
+	t.mutex.Unlock()
+	defer func() {
+		t.mutex.Lock()
+		delete(t.acks, id)
+		t.mutex.Unlock()
+	}()
+
+	if err := t.emit(id, sioPingEvent); err != nil {
+		return err
+	}
+	select {
+	case <-ackC:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("no ping acknowledgement within %v", timeout)
+	}
+}
+
+// Close closes io.socket connection
+func (t *sioTransport) Close() error {
+	t.mutex.Lock()
+	conn := t.conn
+	t.conn = nil
+	t.acks = nil
+	t.mutex.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+//***** Private functions *****
+
+// emit sends an event packet, an acknowledgement is requested when id is
+// not 0
+func (t *sioTransport) emit(id int, event string, args ...interface{}) error {
+	data, err := json.Marshal(append([]interface{}{event}, args...))
+	if err != nil {
+		return err
+	}
+	msg := string([]byte{eioMessage, sioEvent})
+	if id != 0 {
+		msg += strconv.Itoa(id)
+	}
+	return t.write(msg + string(data))
+}
+
+// write sends a message on current connection
+func (t *sioTransport) write(msg string) error {
+	t.mutex.Lock()
+	conn := t.conn
+	t.mutex.Unlock()
+	if conn == nil {
+		return fmt.Errorf("not connected")
+	}
+	t.wmutex.Lock()
+	defer t.wmutex.Unlock()
+	return conn.WriteMessage(websocket.TextMessage, []byte(msg))
+}
+
+// pingLoop sends engine.io pings (required by server to keep connection
+// open) till connection is closed
+func (t *sioTransport) pingLoop(conn *websocket.Conn, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		t.mutex.Lock()
+		closed := t.conn != conn
+		t.mutex.Unlock()
+		if closed || t.write(string(eioPing)) != nil {
+			return
+		}
+	}
+}
+
+// readLoop dispatches received events till connection is lost or closed,
+// connection is lost when nothing (not even a pong) is received within
+// timeout
+func (t *sioTransport) readLoop(conn *websocket.Conn, timeout time.Duration, events map[string]bool, h transportHandler) {
+	for {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		_, data, err := conn.ReadMessage()
+		if err == nil && len(data) > 0 && data[0] == eioClose {
+			err = fmt.Errorf("connection closed by server")
+		}
+		if err == nil && len(data) > 1 && data[0] == eioMessage && data[1] == sioDisconnect {
+			err = fmt.Errorf("disconnected by server")
+		}
+		if err != nil {
+			t.mutex.Lock()
+			closed := t.conn != conn
+			if !closed {
+				t.conn = nil
+				t.acks = nil
+			}
+			t.mutex.Unlock()
+			if !closed {
+				conn.Close()
+				h.onDisconnect(err)
+			}
+			return
+		}
+		if len(data) < 2 || data[0] != eioMessage {
+			// pong or other engine.io packets
+			continue
+		}
+
+		switch data[1] {
+		case sioEvent:
+			_, payload := sioPacketID(data[2:])
+			args := []json.RawMessage{}
+			if err := json.Unmarshal(payload, &args); err != nil || len(args) == 0 {
+				h.onError(fmt.Errorf("Invalid IO.socket event %q", data))
+				continue
+			}
+			event := ""
+			json.Unmarshal(args[0], &event)
+			if events[event] {
+				var arg json.RawMessage
+				if len(args) > 1 {
+					arg = args[1]
+				}
+				h.onEvent(event, arg)
+			}
+		case sioAck:
+			id, payload := sioPacketID(data[2:])
+			t.mutex.Lock()
+			ackC := t.acks[id]
+			t.mutex.Unlock()
+			if ackC != nil {
+				select {
+				case ackC <- json.RawMessage(payload):
+				default:
+				}
+			}
+		case sioError:
+			h.onError(fmt.Errorf("IO.socket error: %s", data[2:]))
+		}
+	}
+}
+
+// sioPacketID splits ID (0 when not set) and JSON payload of a packet
+func sioPacketID(data []byte) (int, []byte) {
+	i := 0
+	for i < len(data) && data[i] >= '0' && data[i] <= '9' {
+		i++
+	}
+	id, _ := strconv.Atoi(string(data[:i]))
+	return id, data[i:]
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Path of events channel of websocket transport (relative to API prefix)
+const wsEventsPath = "/ws"
+
+// wsMessage - JSON message exchanged on events channel of websocket transport
+type wsMessage struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// wsTransport - Transport using a plain websocket for events channel (one
+// JSON message per frame, see wsMessage) and HTTP for REST requests
+type wsTransport struct {
+	*httpTransport
+	url    string
+	header http.Header
+	dialer *websocket.Dialer
+
+	mutex  sync.Mutex
+	wmutex sync.Mutex // only one concurrent writer is supported by websocket
+	conn   *websocket.Conn
+	pongs  chan string // payloads of received pongs
+}
+
+// newWsTransport creates a websocket transport
+func newWsTransport(conf transportConfig) (xdsTransport, error) {
+	ht, err := newHTTPTransport(conf)
+	if err != nil {
+		return nil, err
+	}
+	url := "ws" + strings.TrimPrefix(conf.baseURL, "http") + conf.apiPrefix + wsEventsPath
+	return &wsTransport{httpTransport: ht, url: url, header: conf.header, dialer: conf.dialer, pongs: make(chan string, 1)}, nil
+}
+
+// Connect opens a new websocket connection, previous one (if any) is closed
+func (t *wsTransport) Connect(h transportHandler) error {
+	header := http.Header{}
+	for k, v := range t.header {
+		header[k] = v
+	}
+	header.Set("XDS-AGENT-SID", t.SessionID())
+
+	conn, resp, err := t.dialer.Dial(t.url, header)
+	if err != nil {
+		if resp != nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			return &httpStatusError{
+				code: resp.StatusCode,
+				msg:  fmt.Sprintf("WebSocket connection error: HTTP status %s: %s", resp.Status, strings.TrimSpace(string(body))),
+			}
+		}
+		return fmt.Errorf("WebSocket connection error: %v", err)
+	}
+
+	conn.SetPongHandler(func(data string) error {
+		select {
+		case t.pongs <- data:
+		default:
+		}
+		return nil
+	})
+
+	t.Close()
+	t.mutex.Lock()
+	t.conn = conn
+	t.mutex.Unlock()
+
+	events := make(map[string]bool)
+	for _, ev := range h.events {
+		events[ev] = true
+	}
+	go t.readLoop(conn, events, h)
+	return nil
+}
+
+// Send sends an event, data is the single argument or the list of arguments
+func (t *wsTransport) Send(event string, args ...interface{}) error {
+	t.mutex.Lock()
+	conn := t.conn
+	t.mutex.Unlock()
+	if conn == nil {
+		return fmt.Errorf("not connected")
+	}
+
+	var data interface{} = args
+	if len(args) == 1 {
+		data = args[0]
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	t.wmutex.Lock()
+	defer t.wmutex.Unlock()
+	return conn.WriteJSON(wsMessage{Event: event, Data: raw})
+}
+
+// Ping sends a websocket ping and waits the pong (IOW checks events channel
+// itself rather than REST API)
+func (t *wsTransport) Ping(timeout time.Duration) error {
+	t.mutex.Lock()
+	conn := t.conn
+	t.mutex.Unlock()
+	if conn == nil {
+		return fmt.Errorf("not connected")
+	}
+
+	deadline := time.Now().Add(timeout)
+	payload := strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := conn.WriteControl(websocket.PingMessage, []byte(payload), deadline); err != nil {
+		return err
+	}
+	for {
+		select {
+		case data := <-t.pongs:
+			if data == payload {
+				return nil
+			}
+			// pong of a previous (timed out) ping
+		case <-time.After(deadline.Sub(time.Now())):
+			return fmt.Errorf("no pong within %v", timeout)
+		}
+	}
+}
+
+// Close closes websocket connection
+func (t *wsTransport) Close() error {
+	t.mutex.Lock()
+	conn := t.conn
+	t.conn = nil
+	t.mutex.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+//***** Private functions *****
+
+// readLoop dispatches received events till connection is lost or closed
+func (t *wsTransport) readLoop(conn *websocket.Conn, events map[string]bool, h transportHandler) {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.mutex.Lock()
+			closed := t.conn != conn
+			if !closed {
+				t.conn = nil
+			}
+			t.mutex.Unlock()
+			if !closed {
+				h.onDisconnect(err)
+			}
+			return
+		}
+		msg := wsMessage{}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			h.onError(fmt.Errorf("Invalid WebSocket message: %v", err))
+			continue
+		}
+		if events[msg.Event] {
+			h.onEvent(msg.Event, msg.Data)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGdbXdsWebSocketTransport(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+	a.RequireToken("s3cret")
+
+	g := newTestGdbXds(t, a, map[string]string{"transport": "websocket", "agentToken": "s3cret", "reconnectTimeout": "10"})
+	defer g.Close()
+	if code, err := g.Init(); code != 0 || err != nil {
+		t.Fatalf("Init failed: code=%d err=%v", code, err)
+	}
+	a.WaitWebSocket()
+	if code, err := g.Start(false); code != 0 || err != nil {
+		t.Fatalf("Start failed: code=%d err=%v", code, err)
+	}
+
+	if err := g.Write("-exec-run\n"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if in := a.WaitStdin(); in != "-exec-run\n" {
+		t.Errorf("Unexpected gdb stdin: %q", in)
+	}
+	g.InferiorWrite("hello\n")
+	if in := a.WaitInferiorStdin(); in != "hello\n" {
+		t.Errorf("Unexpected inferior stdin: %q", in)
+	}
+
+	outC := make(chan string, 1)
+	g.Read(func(timestamp, stdout, stderr string) { outC <- stdout })
+	a.Output("^running\n", "")
+	select {
+	case out := <-outC:
+		if out != "^running\n" {
+			t.Errorf("Unexpected gdb output: %q", out)
+		}
+	case <-time.After(fakeAgentTimeout):
+		t.Fatal("Timeout while waiting gdb output")
+	}
+
+	// websocket is reopened after a network failure
+	a.Disconnect()
+	time.Sleep(100 * time.Millisecond)
+	g.Write("-exec-next\n")
+	a.WaitWebSocket()
+	if in := a.WaitStdin(); in != "-exec-next\n" {
+		t.Errorf("Unexpected gdb stdin after reconnection: %q", in)
+	}
+
+	exitC := make(chan int, 1)
+	g.OnExit(func(code int, err error) { exitC <- code })
+	a.Exit(3)
+	select {
+	case code := <-exitC:
+		if code != 3 {
+			t.Errorf("Unexpected exit code %d", code)
+		}
+	case <-time.After(fakeAgentTimeout):
+		t.Fatal("Timeout while waiting exit")
+	}
+}
+
+func TestValidateTransport(t *testing.T) {
+	for _, val := range []string{"", "socketio", "websocket"} {
+		if err := validateTransport(val); err != nil {
+			t.Errorf("validateTransport(%q) failed: %v", val, err)
+		}
+	}
+	if err := validateTransport("grpc"); err == nil {
+		t.Errorf("Unsupported transport not reported")
+	}
+}
@@ -0,0 +1,460 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/websocket"
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+// xdsTransport - Transport of the link between xds-gdb and xds-agent: REST
+// requests, events channel (exec output and agent events) and sending of
+// stdin and signals. Implementations are registered in xdsTransports.
+type xdsTransport interface {
+	// SessionID returns the ID of the session opened on xds-agent
+	SessionID() string
+	// Request sends a REST request (GET or POST) and decodes JSON response into out (when not nil)
+	Request(method, url string, in, out interface{}) error
+	// Connect opens (or reopens) the events channel
+	Connect(h transportHandler) error
+	// Subscribe requests xds-agent to send an event (eg. EVTServerConfig)
+	Subscribe(event string) error
+	// Send sends data on events channel (eg. ExecInEvent to write on gdb stdin)
+	Send(event string, args ...interface{}) error
+	// Signal sends a signal to the running command
+	Signal(args xaapiv1.ExecSignalArgs) error
+	// Supports returns true when xds-agent advertises an optional feature (see capabilitiesHeader)
+	Supports(capability string) bool
+	// Ping checks that xds-agent answers within timeout (see gdb-xds-heartbeat.go)
+	Ping(timeout time.Duration) error
+	// Close closes events channel
+	Close() error
+}
+
+// transportHandler - Callbacks of the events channel
+type transportHandler struct {
+	events       []string // names of events to receive
+	onEvent      func(event string, data json.RawMessage)
+	onError      func(err error)
+	onDisconnect func(err error)
+}
+
+// transportConfig - Settings used to create a transport
+type transportConfig struct {
+	baseURL   string            // url of xds-agent (IOW without API prefix)
+	apiPrefix string            // prefix of REST API (eg. /api/v1)
+	header    http.Header       // additional headers of requests and events channel (eg. authentication)
+	client    *http.Client      // client of REST requests
+	dialer    *websocket.Dialer // dialer of events channel
+	log       *logrus.Logger
+}
+
+// transportFactory creates a transport, IOW opens REST session
+type transportFactory func(conf transportConfig) (xdsTransport, error)
+
+// Default transport (XDS_TRANSPORT)
+const defaultXdsTransport = "socketio"
+
+// Supported transports by name (XDS_TRANSPORT)
+var xdsTransports = map[string]transportFactory{
+	"socketio":  newSioTransport,
+	"websocket": newWsTransport,
+}
+
+// transportNames returns sorted names of supported transports
+func transportNames() []string {
+	names := []string{}
+	for n := range xdsTransports {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateTransport checks transport name
+func validateTransport(val string) error {
+	if _, ok := xdsTransports[val]; val != "" && !ok {
+		return fmt.Errorf("unsupported transport %s (supported: %s)", val, strings.Join(transportNames(), ", "))
+	}
+	return nil
+}
+
+// httpTransport - REST part shared by transports: requests, events
+// subscription and signals are sent using HTTP
+type httpTransport struct {
+	client *http.Client // dedicated to the transport (see agentTransport.clients)
+	url    string       // url of REST API (IOW including API prefix)
+	header http.Header
+	log    *logrus.Logger
+	sid    string
+	caps   map[string]bool // optional features supported by xds-agent
+}
+
+// httpStatusError - Error of a request answered by xds-agent with an error
+// status (see httpStatus)
+type httpStatusError struct {
+	code int // HTTP status code
+	msg  string
+}
+
+func (e *httpStatusError) Error() string {
+	return e.msg
+}
+
+// httpStatus returns HTTP status code of a request answered with an error
+// status, 0 for other errors (eg. connection failure)
+func httpStatus(err error) int {
+	if e, ok := err.(*httpStatusError); ok {
+		return e.code
+	}
+	return 0
+}
+
+// Header of requests and responses holding session ID
+const sessionHeader = "Xds-Agent-Sid"
+
+// Header of version response listing optional features supported by
+// xds-agent (comma separated list), features that are not listed are not used
+const capabilitiesHeader = "Xds-Agent-Capabilities"
+
+// Optional features of xds-agent (see capabilitiesHeader)
+const (
+	// io.socket event sioPingEvent is acknowledged (IOW heartbeat may
+	// check events channel rather than REST API)
+	capEventPing = "event-ping"
+	// running command may be reattached after a reconnection, output
+	// emitted while link was down is sent again (see gdb-xds-reconnect.go)
+	capReattach = "exec-reattach"
+)
+
+// newHTTPTransport opens REST session (first request returns session ID)
+func newHTTPTransport(conf transportConfig) (*httpTransport, error) {
+	t := &httpTransport{
+		client: conf.client,
+		url:    conf.baseURL + conf.apiPrefix,
+		header: conf.header,
+		log:    conf.log,
+	}
+	ver := xaapiv1.XDSVersion{}
+	respHeader, err := t.request(context.Background(), "GET", "/version", nil, &ver)
+	if err != nil {
+		return nil, err
+	}
+	if t.sid = respHeader.Get(sessionHeader); t.sid == "" {
+		return nil, fmt.Errorf("Failed to get device ID")
+	}
+	t.caps = make(map[string]bool)
+	for _, c := range strings.Split(respHeader.Get(capabilitiesHeader), ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			t.caps[c] = true
+		}
+	}
+	return t, nil
+}
+
+// SessionID returns the session ID allocated by xds-agent
+func (t *httpTransport) SessionID() string {
+	return t.sid
+}
+
+// Supports returns true when xds-agent advertises capability
+func (t *httpTransport) Supports(capability string) bool {
+	return t.caps[capability]
+}
+
+// Request sends a REST request
+func (t *httpTransport) Request(method, url string, in, out interface{}) error {
+	if method != "GET" && method != "POST" {
+		return fmt.Errorf("Unsupported request method %s", method)
+	}
+	_, err := t.request(context.Background(), method, url, in, out)
+	return err
+}
+
+// Subscribe registers an event on xds-agent
+func (t *httpTransport) Subscribe(event string) error {
+	return t.Request("POST", "/events/register", xaapiv1.EventRegisterArgs{Name: event}, nil)
+}
+
+// Signal sends a signal to the running command
+func (t *httpTransport) Signal(args xaapiv1.ExecSignalArgs) error {
+	return t.Request("POST", "/signal", args, nil)
+}
+
+// Ping sends a version request, request is canceled when xds-agent doesn't
+// answer within timeout
+func (t *httpTransport) Ping(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ver := xaapiv1.XDSVersion{}
+	_, err := t.request(ctx, "GET", "/version", nil, &ver)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("no answer within %v", timeout)
+	}
+	return err
+}
+
+// request sends a JSON request and decodes JSON response into out (when not
+// nil), response headers are returned on success
+func (t *httpTransport) request(ctx context.Context, method, url string, in, out interface{}) (http.Header, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, t.url+url, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range t.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.sid != "" {
+		req.Header.Set(sessionHeader, t.sid)
+	}
+
+	t.log.Debugf("XDSAGENT: %s %s", method, url)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &httpStatusError{
+			code: resp.StatusCode,
+			msg:  fmt.Sprintf("HTTP status %s: %s", resp.Status, strings.TrimSpace(string(data))),
+		}
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return nil, fmt.Errorf("Invalid response of %s %s: %v", method, url, err)
+		}
+	}
+	return resp.Header, nil
+}
+
+// Url used by HTTP and io.socket clients when xds-agent is reached through a
+// unix socket (host is ignored, requests are sent on socket)
+const unixSocketBaseURL = "http://localhost"
+
+// Default connection timeout to xds-agent (XDS_CONNECT_TIMEOUT)
+const defaultConnectTimeout = 30 * time.Second
+
+// Proxy value used to disable proxy (XDS_PROXY)
+const noProxy = "none"
+
+// agentTransport - Settings of the transport used to reach xds-agent
+type agentTransport struct {
+	tls            *tls.Config   // TLS config (https url)
+	sockPath       string        // unix socket path (unix url)
+	proxy          string        // proxy url, "none" or empty to use HTTP_PROXY, HTTPS_PROXY and NO_PROXY env vars
+	connectTimeout time.Duration // connection timeout (0: default)
+	requestTimeout time.Duration // max time to wait for response headers (0: no timeout)
+}
+
+// clients returns the HTTP client (REST requests) and the websocket dialer
+// (events channel) configured with these settings. They are dedicated to a
+// transport, IOW default HTTP transport and websocket dialer are untouched and
+// connections established using a previous config are never reused.
+func (t *agentTransport) clients() (*http.Client, *websocket.Dialer, error) {
+	// Unix socket is local, IOW never reached through a proxy
+	var proxy func(*http.Request) (*url.URL, error)
+	if t.sockPath == "" {
+		var err error
+		if proxy, err = proxyFunc(t.proxy); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	connectTimeout := t.connectTimeout
+	if connectTimeout == 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+	dialer := &net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	wsDialer := *websocket.DefaultDialer
+	wsDialer.TLSClientConfig = t.tls
+	wsDialer.Proxy = proxy
+	if t.requestTimeout != 0 {
+		// handshake includes connection and response to upgrade request
+		wsDialer.HandshakeTimeout = connectTimeout + t.requestTimeout
+	}
+
+	dial := dialer.DialContext
+	if t.sockPath != "" {
+		sockPath := t.sockPath
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", sockPath)
+		}
+		wsDialer.NetDial = func(network, addr string) (net.Conn, error) {
+			return dialer.Dial("unix", sockPath)
+		}
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 proxy,
+			DialContext:           dial,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+			ResponseHeaderTimeout: t.requestTimeout,
+			TLSClientConfig:       t.tls,
+		},
+	}
+	return client, &wsDialer, nil
+}
+
+// proxyFunc returns the function selecting proxy of requests: proxy url,
+// "none" (direct connection) or empty to use HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY env vars (note that requests to localhost are never proxied)
+func proxyFunc(proxy string) (func(*http.Request) (*url.URL, error), error) {
+	switch proxy {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case noProxy:
+		return nil, nil
+	}
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("Invalid proxy url %s", proxy)
+	}
+	return http.ProxyURL(u), nil
+}
+
+// parseTimeout parses a timeout in seconds or a duration (eg. 500ms), empty
+// value returns 0
+func parseTimeout(val string) (time.Duration, error) {
+	if val == "" {
+		return 0, nil
+	}
+	if tmo, err := strconv.Atoi(val); err == nil && tmo >= 0 {
+		return time.Duration(tmo) * time.Second, nil
+	}
+	if d, err := time.ParseDuration(val); err == nil && d >= 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("invalid timeout %s, must be a positive number of seconds or a duration (eg. 500ms)", val)
+}
+
+// validateTimeout checks a timeout (see parseTimeout)
+func validateTimeout(val string) error {
+	_, err := parseTimeout(val)
+	return err
+}
+
+// parseAgentURL returns the normalized url of xds-agent that may be set as a
+// port number, host:port or url: http or https (ws and wss schemes are
+// respectively converted into http and https) or unix socket
+// (eg. unix:///run/user/1000/xds-agent.sock)
+func parseAgentURL(val string) (string, error) {
+	val = strings.TrimSpace(val)
+	if match, _ := regexp.MatchString("^[0-9]+$", val); match {
+		val = "localhost:" + val
+	}
+	if !strings.Contains(val, "://") {
+		val = "http://" + val
+	}
+
+	u, err := url.Parse(val)
+	if err != nil {
+		return "", fmt.Errorf("Invalid XDS agent url %s: %v", val, err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	case "unix":
+		if u.Host+u.Path == "" {
+			return "", fmt.Errorf("Missing socket path in XDS agent url %s", val)
+		}
+		return "unix://" + u.Host + u.Path, nil
+	default:
+		return "", fmt.Errorf("Unsupported scheme %s in XDS agent url %s (supported: http, https, ws, wss, unix)", u.Scheme, val)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("Missing host in XDS agent url %s", val)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return u.String(), nil
+}
+
+// agentSocketPath returns path of unix socket of a unix url (empty when url
+// is not a unix url)
+func agentSocketPath(agentURL string) string {
+	if !strings.HasPrefix(agentURL, "unix://") {
+		return ""
+	}
+	return strings.TrimPrefix(agentURL, "unix://")
+}
+
+// validateAgentURL checks url of xds-agent (see parseAgentURL)
+func validateAgentURL(val string) error {
+	if strings.HasPrefix(val, "unix://") {
+		if val == "unix://" {
+			return fmt.Errorf("missing socket path in url %s", val)
+		}
+		return nil
+	}
+	if strings.HasPrefix(val, "ws://") || strings.HasPrefix(val, "wss://") {
+		val = "http" + val[2:]
+	}
+	return validateURL(val)
+}
+
+// validateProxy checks proxy setting (see proxyFunc)
+func validateProxy(val string) error {
+	_, err := proxyFunc(val)
+	return err
+}
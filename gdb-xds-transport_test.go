@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestParseAgentURL(t *testing.T) {
+	for val, exp := range map[string]string{
+		"8800":                 "http://localhost:8800",
+		"localhost:8800":       "http://localhost:8800",
+		"http://agent:8800":    "http://agent:8800",
+		"https://agent:8800/":  "https://agent:8800",
+		"wss://agent":          "https://agent",
+		"ws://agent:8800":      "http://agent:8800",
+		"unix:///run/xds.sock": "unix:///run/xds.sock",
+		"unix://xds.sock":      "unix://xds.sock",
+	} {
+		if u, err := parseAgentURL(val); err != nil || u != exp {
+			t.Errorf("parseAgentURL(%q) = %q, %v (expected %q)", val, u, err, exp)
+		}
+		if err := validateAgentURL(val); err != nil {
+			t.Errorf("validateAgentURL(%q) failed: %v", val, err)
+		}
+	}
+	for val, exp := range map[string]string{
+		"ftp://agent": "Unsupported scheme ftp",
+		"unix://":     "Missing socket path",
+		"http://":     "Missing host",
+		"":            "Missing host",
+	} {
+		if _, err := parseAgentURL(val); err == nil || !strings.Contains(err.Error(), exp) {
+			t.Errorf("Unexpected error for %q: %v", val, err)
+		}
+	}
+}
+
+func TestGdbXdsPlainURL(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+
+	// port number only and TLS settings ignored with http url
+	port := a.URL()[strings.LastIndex(a.URL(), ":")+1:]
+	g := newTestGdbXds(t, a, map[string]string{"agentURL": port, "tlsCAFile": path.Join("missing", "ca.pem")})
+	if code, err := g.Init(); code != 0 || err != nil {
+		t.Fatalf("Init failed with port number: code=%d err=%v", code, err)
+	}
+	a.WaitSocket()
+	if g.baseURL != "http://localhost:"+port {
+		t.Errorf("Invalid base url: %s", g.baseURL)
+	}
+}
+
+func TestGdbXdsUnixSocket(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "xds-gdb-test")
+	defer os.RemoveAll(dir)
+	sock := path.Join(dir, "xds-agent.sock")
+
+	a := newFakeAgentUnstarted(t)
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("Cannot listen on unix socket: %v", err)
+	}
+	a.srv.Listener.Close()
+	a.srv.Listener = l
+	a.srv.Start()
+	defer a.Close()
+
+	defaultTransport := http.DefaultTransport
+	g := startTestGdbXds(t, a, map[string]string{"agentURL": "unix://" + sock})
+	if g.baseURL != unixSocketBaseURL {
+		t.Errorf("Invalid base url: %s", g.baseURL)
+	}
+	if http.DefaultTransport != defaultTransport || websocket.DefaultDialer.NetDial != nil {
+		t.Errorf("Default HTTP transport or websocket dialer modified")
+	}
+	if err := g.Write("-exec-run\n"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if in := a.WaitStdin(); in != "-exec-run\n" {
+		t.Errorf("Invalid gdb input: %q", in)
+	}
+
+	// unknown socket
+	g = newTestGdbXds(t, a, map[string]string{"agentURL": "unix://" + sock + ".missing"})
+	if code, err := g.Init(); code != int(syscallEBADE) || err == nil || !strings.Contains(err.Error(), "Cannot connection to unix://"+sock+".missing") {
+		t.Errorf("Unexpected result with unknown socket: code=%d err=%v", code, err)
+	}
+}
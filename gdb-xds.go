@@ -21,18 +21,17 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
 	"regexp"
-	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
-	"text/tabwriter"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/iotbzh/xds-agent/lib/xaapiv1"
-	common "github.com/iotbzh/xds-common/golib"
-	sio_client "github.com/sebd71/go-socket.io-client"
 )
 
 // GdbXds - Implementation of IGDB used to interfacing XDS
@@ -43,17 +42,53 @@ type GdbXds struct {
 	eenv      []string
 	agentURL  string
 	serverURL string
+	serverID  string
 	prjID     string
 	sdkID     string
 	rPath     string
 	listPrj   bool
+	listFmt   string
+	tls       agentTLS
+	transport agentTransport
+	auth      agentAuth
+	token     string
 	cmdID     string
 	xGdbPid   string
 
-	httpCli *common.HTTPClient
-	ioSock  *sio_client.Client
-
-	projects []xaapiv1.ProjectConfig
+	link     xdsTransport // transport of link to xds-agent (see gdb-xds-transport.go)
+	linkName string       // transport name (XDS_TRANSPORT)
+	linkGen  int          // generation of events channel (incremented on each connection)
+	baseURL  string
+
+	// connection state (see gdb-xds-reconnect.go)
+	mutex            sync.Mutex
+	linkState        linkState
+	reconnectTimeout time.Duration
+	retryTimeout     time.Duration // max duration of retries in Init and Start (see gdb-xds-retry.go)
+	pendingWrites    []pendingWrite
+	serverLostTimer  *time.Timer
+	outCount         int // output messages received for cmdID (resume offsets of reattach)
+	inferiorOutCount int
+
+	// heartbeat (see gdb-xds-heartbeat.go)
+	heartbeatInterval  time.Duration
+	heartbeatMaxMissed int
+	latencyThreshold   time.Duration
+	heartbeatStop      chan struct{}
+	stats              linkStats
+
+	// gdb output (serialized to insert notifications between output lines)
+	outMutex     sync.Mutex
+	outLineStart bool
+	miMode       bool
+
+	servers    []xaapiv1.ServerCfg
+	svrIdx     int // index of selected server (-1 when not selected)
+	projects   []xaapiv1.ProjectConfig
+	project    *xaapiv1.ProjectConfig // project resolved from prjID
+	picker     *Picker                // interactive selection of project and SDK
+	miParser   *MIParser
+	pathMapper *PathMapper
 
 	// callbacks
 	cbOnError      func(error)
@@ -61,40 +96,157 @@ type GdbXds struct {
 	cbRead         func(timestamp, stdout, stderr string)
 	cbInferiorRead func(timestamp, stdout, stderr string)
 	cbOnExit       func(code int, err error)
+	cbOnMIRecord   func(rec *MIRecord)
 }
 
 // NewGdbXds creates a new instance of GdbXds
 func NewGdbXds(log *logrus.Logger, args []string, env []string) *GdbXds {
 	return &GdbXds{
-		log:     log,
-		ccmd:    "exec $GDB", // var set by environment-setup-xxx script
-		aargs:   args,
-		eenv:    env,
-		httpCli: nil,
-		ioSock:  nil,
-		xGdbPid: strconv.Itoa(os.Getpid()),
+		log:      log,
+		ccmd:     "exec $GDB", // var set by environment-setup-xxx script
+		aargs:    args,
+		eenv:     env,
+		link:     nil,
+		linkName: defaultXdsTransport,
+		xGdbPid:  strconv.Itoa(os.Getpid()),
+		svrIdx:   -1,
+		miParser: NewMIParser(),
+
+		pathMapper: NewPathMapper(),
+
+		reconnectTimeout: defaultReconnectTimeout,
+
+		heartbeatInterval:  defaultHeartbeatInterval,
+		heartbeatMaxMissed: defaultHeartbeatMaxMissed,
+		latencyThreshold:   defaultLatencyThreshold,
+		outLineStart:       true,
+		miMode:             isMIMode(args),
 	}
 }
 
 // SetConfig set additional config fields
 func (g *GdbXds) SetConfig(name string, value interface{}) error {
-	var val string
-	if name != "listProject" {
-		val = strings.TrimSpace(value.(string))
+	val, isString := value.(string)
+	if !isString && name != "listProject" && name != "picker" {
+		return fmt.Errorf("Invalid type of %s field value (%T)", name, value)
 	}
+	val = strings.TrimSpace(val)
 	switch name {
 	case "agentURL":
 		g.agentURL = val
 	case "serverURL":
 		g.serverURL = val
+	case "serverID":
+		g.serverID = val
 	case "prjID":
 		g.prjID = val
 	case "sdkID":
 		g.sdkID = val
 	case "rPath":
 		g.rPath = val
+	case "tlsCAFile":
+		g.tls.caFile = val
+	case "tlsCertFile":
+		g.tls.certFile = val
+	case "tlsKeyFile":
+		g.tls.keyFile = val
+	case "tlsInsecure":
+		g.tls.insecure = false
+		if val != "" {
+			b, err := parseBool(val)
+			if err != nil {
+				return fmt.Errorf("Invalid %s value: %v", name, err)
+			}
+			g.tls.insecure = b
+		}
+	case "proxy":
+		if err := validateProxy(val); err != nil {
+			return err
+		}
+		g.transport.proxy = val
+	case "connectTimeout", "requestTimeout", "retryTimeout":
+		tmo, err := parseTimeout(val)
+		if err != nil {
+			return fmt.Errorf("Invalid %s value: %v", name, err)
+		}
+		switch name {
+		case "connectTimeout":
+			g.transport.connectTimeout = tmo
+		case "requestTimeout":
+			g.transport.requestTimeout = tmo
+		default:
+			g.retryTimeout = tmo
+		}
+	case "transport":
+		if err := validateTransport(val); err != nil {
+			return fmt.Errorf("Invalid %s value: %v", name, err)
+		}
+		if val != "" {
+			g.linkName = val
+		}
+	case "heartbeatInterval":
+		if val != "" {
+			tmo, err := parseTimeout(val)
+			if err != nil {
+				return fmt.Errorf("Invalid %s value: %v", name, err)
+			}
+			g.heartbeatInterval = tmo
+		}
+	case "heartbeatMaxMissed":
+		if val != "" {
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("Invalid %s value: %s (must be greater than 0)", name, val)
+			}
+			g.heartbeatMaxMissed = n
+		}
+	case "latencyThreshold":
+		if val != "" {
+			ms, err := strconv.Atoi(val)
+			if err != nil || ms < 0 {
+				return fmt.Errorf("Invalid %s value: %s (must be a positive number of milliseconds)", name, val)
+			}
+			g.latencyThreshold = time.Duration(ms) * time.Millisecond
+		}
+	case "agentToken":
+		g.auth.token = val
+	case "agentTokenFile":
+		g.auth.file = val
+	case "agentTokenHelper":
+		g.auth.helper = val
+	case "listFormat":
+		if val != "" && !isListFormat(val) {
+			return fmt.Errorf("Unsupported list format %s (supported: %s)", val, strings.Join(listFormats, ", "))
+		}
+		g.listFmt = val
 	case "listProject":
-		g.listPrj = value.(bool)
+		b, isBool := value.(bool)
+		if !isBool {
+			return fmt.Errorf("Invalid type of %s field value (%T)", name, value)
+		}
+		g.listPrj = b
+	case "picker":
+		p, isPicker := value.(*Picker)
+		if !isPicker {
+			return fmt.Errorf("Invalid type of %s field value (%T)", name, value)
+		}
+		g.picker = p
+	case "reconnectTimeout":
+		if val != "" {
+			tmo, err := strconv.Atoi(val)
+			if err != nil || tmo < 0 {
+				return fmt.Errorf("Invalid reconnect timeout value: %s", val)
+			}
+			g.reconnectTimeout = time.Duration(tmo) * time.Second
+		}
+	case "pathMap":
+		maps, err := ParsePathMapList(val)
+		if err != nil {
+			return err
+		}
+		for _, m := range maps {
+			g.pathMapper.Add(m.Client, m.Server)
+		}
 	default:
 		return fmt.Errorf("Unknown %s field", name)
 	}
@@ -107,35 +259,71 @@ func (g *GdbXds) Init() (int, error) {
 	// Reset command ID (also used to enable sending of signals)
 	g.cmdID = ""
 
-	// Define HTTP and WS url
-	baseURL := g.agentURL
+	// Define HTTP and WS url (port number, host:port, url or unix socket)
+	agentURL, err := parseAgentURL(g.agentURL)
+	if err != nil {
+		return int(syscall.EINVAL), err
+	}
+	baseURL := agentURL
+	sockPath := agentSocketPath(agentURL)
+	if sockPath != "" {
+		baseURL = unixSocketBaseURL
+	}
 
-	// Allow to only set port number
-	if match, _ := regexp.MatchString("^([0-9]+)$", baseURL); match {
-		baseURL = "http://localhost:" + g.agentURL
+	// Setup TLS, unix socket, proxy and timeouts of HTTP and WS clients
+	transport := g.transport
+	transport.sockPath = sockPath
+	if strings.HasPrefix(baseURL, "https://") {
+		if transport.tls, err = g.tls.config(); err != nil {
+			return int(syscall.EINVAL), err
+		}
+		if g.tls.insecure {
+			g.log.Warnf("Verification of XDS agent certificate is disabled")
+		}
+	} else if g.tls.isSet() {
+		g.log.Warnf("TLS settings ignored, XDS agent url %s is not https", agentURL)
 	}
-	// Add http prefix if missing
-	if baseURL != "" && !strings.HasPrefix(g.agentURL, "http://") {
-		baseURL = "http://" + g.agentURL
+	client, dialer, err := transport.clients()
+	if err != nil {
+		return int(syscall.EINVAL), err
 	}
 
-	// Create HTTP client
-	g.log.Infoln("Connect HTTP client on ", baseURL)
-	conf := common.HTTPClientConfig{
-		URLPrefix:           "/api/v1",
-		HeaderClientKeyName: "Xds-Agent-Sid",
-		CsrfDisable:         true,
-		LogOut:              g.log.Out,
-		LogPrefix:           "XDSAGENT: ",
-		LogLevel:            common.HTTPLogLevelDebug,
+	// Get authentication token (not set when xds-agent doesn't require it)
+	token, err := g.auth.load()
+	if err != nil {
+		return int(syscall.EACCES), err
+	}
+	g.token = token
+
+	// Create transport (IOW open REST session)
+	g.log.Infof("Connect %s transport on %s", g.linkName, agentURL)
+	tconf := transportConfig{
+		baseURL:   baseURL,
+		apiPrefix: "/api/v1",
+		header:    http.Header{},
+		client:    client,
+		dialer:    dialer,
+		log:       g.log,
+	}
+	if token != "" {
+		g.log.Infof("Authenticate using token of %s", g.auth.source)
+		tconf.header.Set(agentAuthHeader, g.auth.header(token))
+	}
+	var link xdsTransport
+	err = g.retry("Connection to XDS agent "+agentURL, isTransientError, func() error {
+		var errC error
+		link, errC = xdsTransports[g.linkName](tconf)
+		return errC
+	})
+	if isAuthError(err) {
+		return int(syscall.EACCES), g.auth.authError(agentURL, err)
 	}
-	c, err := common.HTTPNewClient(baseURL, conf)
 	if err != nil {
 		errmsg := err.Error()
-		m, err := regexp.MatchString("Get http.?://", errmsg)
-		if (m && err == nil) || strings.Contains(errmsg, "Failed to get device ID") {
+		m, errRe := regexp.MatchString("Get \"?http.?://", errmsg)
+		if (m && errRe == nil) || strings.Contains(errmsg, "Failed to get device ID") {
 			i := strings.LastIndex(errmsg, ":")
-			newErr := "Cannot connection to " + baseURL
+			newErr := "Cannot connection to " + agentURL
 			if i > 0 {
 				newErr += " (" + strings.TrimSpace(errmsg[i+1:]) + ")"
 			} else {
@@ -143,42 +331,50 @@ func (g *GdbXds) Init() (int, error) {
 			}
 			errmsg = newErr
 		}
+		if isTLSError(err) {
+			errmsg += ", check XDS_TLS_CA_FILE, XDS_TLS_CERT_FILE and XDS_TLS_KEY_FILE settings"
+		}
 		return int(syscallEBADE), fmt.Errorf(errmsg)
 	}
-	g.httpCli = c
-	g.httpCli.SetLogLevel(g.log.Level.String())
-	g.log.Infoln("HTTP session ID:", g.httpCli.GetClientID())
+	g.mutex.Lock()
+	g.link = link
+	g.mutex.Unlock()
+	g.log.Infoln("Session ID:", g.link.SessionID())
 
 	// First call to check that xds-agent and server are alive
 	ver := xaapiv1.XDSVersion{}
-	if err := g.httpCli.Get("/version", &ver); err != nil {
+	err = g.retry("GET /version", isTransientError, func() error {
+		return g.link.Request("GET", "/version", nil, &ver)
+	})
+	if err != nil {
+		if isAuthError(err) {
+			return int(syscall.EACCES), g.auth.authError(agentURL, err)
+		}
 		return int(syscallEBADE), err
 	}
 	g.log.Infoln("XDS agent & server version:", ver)
 
-	// Get current config and update connection to server when needed
+	// Get current config and select server (update connection to server when needed)
 	xdsConf := xaapiv1.APIConfig{}
-	if err := g.httpCli.Get("/config", &xdsConf); err != nil {
+	if err := g.link.Request("GET", "/config", nil, &xdsConf); err != nil {
 		return int(syscallEBADE), err
 	}
-	// FIXME: add multi-servers support
-	idx := 0
-	svrCfg := xdsConf.Servers[idx]
-	if g.serverURL != "" && (svrCfg.URL != g.serverURL || !svrCfg.Connected) {
-		svrCfg.URL = g.serverURL
-		svrCfg.ConnRetry = 10
-		newCfg := xaapiv1.APIConfig{}
-		if err := g.httpCli.Post("/config", xdsConf, &newCfg); err != nil {
+	if len(xdsConf.Servers) == 0 {
+		return int(syscallEBADE), fmt.Errorf("No XDS server defined in xds-agent configuration")
+	}
+	g.svrIdx = -1
+	if g.serverID != "" || g.serverURL != "" {
+		idx, err := g.selectServer(&xdsConf)
+		if err != nil {
 			return int(syscallEBADE), err
 		}
-
-	} else if !svrCfg.Connected {
-		return int(syscallEBADE), fmt.Errorf("XDS server not connected (url=%s)", svrCfg.URL)
+		g.svrIdx = idx
 	}
+	g.servers = xdsConf.Servers
 
 	// Get XDS projects list
-	var data []byte
-	if err := g.httpCli.HTTPGet("/projects", &data); err != nil {
+	var data json.RawMessage
+	if err := g.link.Request("GET", "/projects", nil, &data); err != nil {
 		return int(syscallEBADE), err
 	}
 
@@ -189,94 +385,87 @@ func (g *GdbXds) Init() (int, error) {
 		g.log.Errorf("Cannot decode projects configuration: %s", errMar.Error())
 	}
 
+	// Interactively select project when not set (only when run from a terminal)
+	picked := make(map[string]string)
+	if g.prjID == "" && !g.listPrj && g.picker != nil && len(g.projects) > 0 {
+		if err := g.pickProject(); err != nil {
+			return int(syscall.EINVAL), err
+		}
+		picked["XDS_PROJECT_ID"] = g.prjID
+	}
+
 	// Check mandatory args
-	if g.prjID == "" || g.listPrj {
+	if g.listPrj {
 		return g.printProjectsList()
 	}
-
-	// Create io Websocket client
-	g.log.Infoln("Connecting IO.socket client on ", baseURL)
-
-	opts := &sio_client.Options{
-		Transport: "websocket",
-		Header:    make(map[string][]string),
+	if g.prjID == "" {
+		if code, err := g.printProjectsList(); err != nil {
+			return code, err
+		}
+		return int(syscall.EINVAL), fmt.Errorf("XDS_PROJECT_ID must be set")
 	}
-	opts.Header["XDS-AGENT-SID"] = []string{c.GetClientID()}
 
-	iosk, err := sio_client.NewClient(baseURL, opts)
+	// Resolve project (by ID, ID prefix or label)
+	project, err := g.resolveProject()
 	if err != nil {
-		e := fmt.Sprintf("IO.socket connection error: " + err.Error())
-		return int(syscall.ECONNABORTED), fmt.Errorf(e)
+		return int(syscall.EINVAL), err
 	}
-	g.ioSock = iosk
-
-	iosk.On("error", func(err error) {
-		if g.cbOnError != nil {
-			g.cbOnError(err)
+	g.project = project
+	g.prjID = project.ID
+	g.log.Infof("Use project %s (label=%s)", project.ID, project.Label)
+
+	// Server is automatically selected from project when not set
+	if g.svrIdx == -1 {
+		if g.svrIdx = g.serverIndex(project.ServerID); g.svrIdx == -1 {
+			g.svrIdx = 0
 		}
-	})
-
-	iosk.On("disconnection", func(err error) {
-		if g.cbOnDisconnect != nil {
-			g.cbOnDisconnect(err)
-		}
-	})
-
-	// SEB gdbPid := ""
-	iosk.On(xaapiv1.ExecOutEvent, func(ev xaapiv1.ExecOutMsg) {
-		if g.cbRead != nil {
-			g.cbRead(ev.Timestamp, ev.Stdout, ev.Stderr)
-			/*
-				stdout := ev.Stdout
-				// SEB
-				//New Thread 15139
-				if strings.Contains(stdout, "pid = ") {
-					re := regexp.MustCompile("pid = ([0-9]+)")
-					if res := re.FindAllStringSubmatch(stdout, -1); len(res) > 0 {
-						gdbPid = res[0][1]
-					}
-					g.log.Errorf("SEB FOUND THREAD in '%s' => gdbPid=%s", stdout, gdbPid)
-				}
-				if gdbPid != "" && g.xGdbPid != "" && strings.Contains(stdout, gdbPid) {
-					g.log.Errorf("SEB THREAD REPLACE 1 stdout=%s", stdout)
-					stdout = strings.Replace(stdout, gdbPid, g.xGdbPid, -1)
-					g.log.Errorf("SEB THREAD REPLACE 2 stdout=%s", stdout)
-				}
+	}
+	svrCfg := g.servers[g.svrIdx]
+	g.log.Infof("Use XDS server %s (url=%s)", svrCfg.ID, svrCfg.URL)
+	if !svrCfg.Connected {
+		return int(syscallEBADE), fmt.Errorf("XDS server not connected (url=%s)", svrCfg.URL)
+	}
 
-				g.cbRead(ev.Timestamp, stdout, ev.Stderr)
-			*/
+	// Resolve SDK (by ID, ID prefix, name, version or arch) within server SDKs,
+	// when not set SDK is selected from project default SDK or from debugged
+	// program ELF header, else interactively
+	sdks := []xaapiv1.SDK{}
+	if err := g.link.Request("GET", "/servers/"+strconv.Itoa(g.svrIdx)+"/sdks", nil, &sdks); err != nil {
+		return int(syscallEBADE), err
+	}
+	if g.sdkID == "" {
+		if sdk, why := g.autoSelectSdk(sdks); sdk != nil {
+			g.log.Infof("Auto-select SDK %s: %s", sdk.Name, why)
+			g.sdkID = sdk.ID
+		} else if g.picker != nil {
+			if err := g.pickSdk(sdks); err != nil {
+				return int(syscall.EINVAL), err
+			}
+			picked["XDS_SDK_ID"] = g.sdkID
 		}
-	})
-
-	iosk.On(xaapiv1.ExecInferiorOutEvent, func(ev xaapiv1.ExecOutMsg) {
-		if g.cbInferiorRead != nil {
-			g.cbInferiorRead(ev.Timestamp, ev.Stdout, ev.Stderr)
+	}
+	if g.sdkID != "" {
+		sdk, err := resolveSdk(g.sdkID, sdks)
+		if err != nil {
+			return int(syscall.EINVAL), err
 		}
-	})
-
-	iosk.On(xaapiv1.ExecExitEvent, func(ev xaapiv1.ExecExitMsg) {
-		if g.cbOnExit != nil {
-			g.cbOnExit(ev.Code, ev.Error)
+		g.sdkID = sdk.ID
+		g.log.Infof("Use SDK %s (name=%s)", sdk.ID, sdk.Name)
+	}
+	if len(picked) > 0 {
+		if err := g.picker.Save(picked); err != nil {
+			g.log.Errorf("Cannot save selection: %v", err)
 		}
-	})
+	}
 
-	// Monitor XDS server configuration changes (and specifically connected status)
-	iosk.On(xaapiv1.EVTServerConfig, func(ev xaapiv1.EventMsg) {
-		svrCfg, err := ev.DecodeServerCfg()
-		if err == nil && !svrCfg.Connected {
-			// TODO: should wait that server will be connected back
-			if g.cbOnExit != nil {
-				g.cbOnExit(-1, fmt.Errorf("XDS Server disconnected"))
-			} else {
-				fmt.Printf("XDS Server disconnected")
-				os.Exit(-1)
-			}
+	// Open events channel
+	g.baseURL = baseURL
+	g.setLinkState(linkConnected)
+	if err := g.connectEvents(); err != nil {
+		if isAuthError(err) {
+			return int(syscall.EACCES), g.auth.authError(agentURL, err)
 		}
-	})
-
-	args := xaapiv1.EventRegisterArgs{Name: xaapiv1.EVTServerConfig}
-	if err := g.httpCli.Post("/events/register", args, nil); err != nil {
-		return 0, err
+		return int(syscall.ECONNABORTED), err
 	}
 
 	return 0, nil
@@ -284,12 +473,21 @@ func (g *GdbXds) Init() (int, error) {
 
 // Close frees allocated objects and close opened connections
 func (g *GdbXds) Close() error {
+	g.mutex.Lock()
 	g.cbOnDisconnect = nil
 	g.cbOnError = nil
 	g.cbOnExit = nil
 	g.cbRead = nil
 	g.cbInferiorRead = nil
+	g.cbOnMIRecord = nil
 	g.cmdID = ""
+	link := g.link
+	g.mutex.Unlock()
+	g.setLinkState(linkClosed)
+	g.stopHeartbeat()
+	if link != nil {
+		link.Close()
+	}
 
 	return nil
 }
@@ -297,16 +495,9 @@ func (g *GdbXds) Close() error {
 // Start sends a request to start remotely gdb within xds-server
 func (g *GdbXds) Start(inferiorTTY bool) (int, error) {
 	var err error
-	var project *xaapiv1.ProjectConfig
 
-	// Retrieve the project definition
-	for _, f := range g.projects {
-		// check as prefix to support short/partial id name
-		if strings.HasPrefix(f.ID, g.prjID) {
-			project = &f
-			break
-		}
-	}
+	// Project resolved by Init
+	project := g.project
 
 	// Auto setup rPath if needed
 	if g.rPath == "" && project != nil {
@@ -324,8 +515,14 @@ func (g *GdbXds) Start(inferiorTTY bool) (int, error) {
 		}
 	}
 
+	// Translate paths between client and server project directories
+	if project != nil {
+		g.pathMapper.Add(project.ClientPath, project.ServerPath)
+	}
+	g.log.Debugf("Path mappings: %v", g.pathMapper)
+
 	// Enable workaround about inferior output with gdbserver connection
-	// except if XDS_GDBSERVER_OUTPUT_NOFIX is defined
+	// except if XDS_GDBSERVER_OUTPUT_NOFIX is defined (whatever its value)
 	_, gdbserverNoFix := os.LookupEnv("XDS_GDBSERVER_OUTPUT_NOFIX")
 
 	// SDK ID must be set else $GDB cannot be resolved
@@ -347,14 +544,22 @@ func (g *GdbXds) Start(inferiorTTY bool) (int, error) {
 
 	g.log.Infof("POST %s/exec %v", g.agentURL, args)
 	res := xaapiv1.ExecResult{}
-	err = g.httpCli.Post("/exec", args, &res)
+	err = g.retry("POST /exec", isUnprocessedError, func() error {
+		return g.link.Request("POST", "/exec", args, &res)
+	})
 	if err != nil {
 		return int(syscall.EAGAIN), err
 	}
 	if res.CmdID == "" {
 		return int(syscallEBADE), fmt.Errorf("null CmdID")
 	}
+	g.mutex.Lock()
 	g.cmdID = res.CmdID
+	g.outCount = 0
+	g.inferiorOutCount = 0
+	g.mutex.Unlock()
+
+	g.startHeartbeat()
 
 	return 0, nil
 }
@@ -381,11 +586,15 @@ func (g *GdbXds) OnError(f func(error)) {
 
 // OnDisconnect is called when WebSocket disconnection
 func (g *GdbXds) OnDisconnect(f func(error)) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
 	g.cbOnDisconnect = f
 }
 
 // OnExit calls when exit event is received
 func (g *GdbXds) OnExit(f func(code int, err error)) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
 	g.cbOnExit = f
 }
 
@@ -399,64 +608,239 @@ func (g *GdbXds) InferiorRead(f func(timestamp, stdout, stderr string)) {
 	g.cbInferiorRead = f
 }
 
+// OnMIRecord calls when a complete GDB/MI record has been parsed from gdb stdout
+func (g *GdbXds) OnMIRecord(f func(rec *MIRecord)) {
+	g.cbOnMIRecord = f
+}
+
 // Write writes message/string into gdb stdin
 func (g *GdbXds) Write(args ...interface{}) error {
-	return g.ioSock.Emit(xaapiv1.ExecInEvent, args...)
+	// Translate client paths into server paths
+	if !g.pathMapper.Empty() && len(args) == 1 {
+		if s, ok := args[0].(string); ok && strings.HasSuffix(s, "\n") {
+			cmd := ParseMICommand(strings.TrimSuffix(s, "\n"))
+			if g.pathMapper.CommandToServer(cmd) {
+				g.log.Debugf("Path mapping: <%s> -> <%s>", strings.TrimSuffix(s, "\n"), cmd.String())
+				args[0] = cmd.String() + "\n"
+			}
+		}
+	}
+	return g.emit(xaapiv1.ExecInEvent, args...)
+}
+
+// InferiorWrite writes message/string into stdin of the debugged program (IOW inferior)
+func (g *GdbXds) InferiorWrite(args ...interface{}) error {
+	return g.emit(xaapiv1.ExecInferiorInEvent, args...)
 }
 
 // SendSignal is used to send a signal to remote process/gdb
 func (g *GdbXds) SendSignal(sig os.Signal) error {
-	if g.cmdID == "" {
+	g.mutex.Lock()
+	cmdID := g.cmdID
+	g.mutex.Unlock()
+	if cmdID == "" {
 		return fmt.Errorf("cmdID not set")
 	}
 
 	sigArg := xaapiv1.ExecSignalArgs{
-		CmdID:  g.cmdID,
+		CmdID:  cmdID,
 		Signal: sig.String(),
 	}
 	g.log.Debugf("POST /signal %v", sigArg)
-	return g.httpCli.Post("/signal", sigArg, nil)
+	return g.link.Signal(sigArg)
 }
 
 //***** Private functions *****
 
-func (g *GdbXds) printProjectsList() (int, error) {
-	writer := new(tabwriter.Writer)
-	writer.Init(os.Stdout, 0, 8, 0, '\t', 0)
-	msg := ""
-	if len(g.projects) > 0 {
-		fmt.Fprintln(writer, "List of existing projects (use: export XDS_PROJECT_ID=<< ID >>):")
-		fmt.Fprintln(writer, "ID \t Label")
-		for _, f := range g.projects {
-			fmt.Fprintf(writer, " %s \t  %s\n", f.ID, f.Label)
+// connectEvents opens the events channel of transport and registers events
+func (g *GdbXds) connectEvents() error {
+	g.log.Infof("Connecting %s events channel", g.linkName)
+
+	// Events of a previous (disconnected) channel must be ignored
+	g.mutex.Lock()
+	g.linkGen++
+	gen := g.linkGen
+	g.mutex.Unlock()
+	isCurrent := func() bool {
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+		return gen == g.linkGen
+	}
+
+	h := transportHandler{
+		events: []string{
+			xaapiv1.ExecOutEvent,
+			xaapiv1.ExecInferiorOutEvent,
+			xaapiv1.ExecExitEvent,
+			xaapiv1.EVTServerConfig,
+		},
+		onEvent: func(event string, data json.RawMessage) {
+			if isCurrent() {
+				g.dispatchEvent(event, data)
+			}
+		},
+		onError: func(err error) {
+			if isCurrent() && g.cbOnError != nil {
+				g.cbOnError(err)
+			}
+		},
+		onDisconnect: func(err error) {
+			if isCurrent() {
+				go g.reconnect(err)
+			}
+		},
+	}
+	if err := g.link.Connect(h); err != nil {
+		return err
+	}
+
+	// Monitor XDS server configuration changes (and specifically connected status)
+	if err := g.link.Subscribe(xaapiv1.EVTServerConfig); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// dispatchEvent decodes an event received on events channel and calls callbacks
+func (g *GdbXds) dispatchEvent(event string, data json.RawMessage) {
+	switch event {
+	case xaapiv1.ExecOutEvent, xaapiv1.ExecInferiorOutEvent:
+		ev := xaapiv1.ExecOutMsg{}
+		if err := json.Unmarshal(data, &ev); err != nil {
+			g.log.Errorf("Cannot decode %s event: %v", event, err)
+			return
+		}
+		if !g.isCmdEvent(ev.CmdID) {
+			return
+		}
+		g.outputReceived(event)
+		if event == xaapiv1.ExecInferiorOutEvent {
+			if g.cbInferiorRead != nil {
+				g.cbInferiorRead(ev.Timestamp, ev.Stdout, ev.Stderr)
+			}
+			return
+		}
+		// Translate server paths into client paths
+		if !g.pathMapper.Empty() {
+			ev.Stdout = g.pathMapper.FilterOutput(ev.Stdout)
+		}
+		g.outMutex.Lock()
+		if g.cbRead != nil {
+			g.cbRead(ev.Timestamp, ev.Stdout, ev.Stderr)
+		}
+		if ev.Stdout != "" {
+			g.outLineStart = strings.HasSuffix(ev.Stdout, "\n")
+		}
+		g.outMutex.Unlock()
+		if g.cbOnMIRecord != nil {
+			for _, rec := range g.miParser.Feed(ev.Stdout) {
+				g.cbOnMIRecord(rec)
+			}
+		}
+
+	case xaapiv1.ExecExitEvent:
+		ev := xaapiv1.ExecExitMsg{}
+		if err := json.Unmarshal(data, &ev); err != nil {
+			g.log.Errorf("Cannot decode %s event: %v", event, err)
+			return
+		}
+		if !g.isCmdEvent(ev.CmdID) {
+			return
+		}
+		g.stopHeartbeat()
+		if g.cbOnExit != nil {
+			g.cbOnExit(ev.Code, ev.Error)
+		}
+
+	case xaapiv1.EVTServerConfig:
+		ev := xaapiv1.EventMsg{}
+		if err := json.Unmarshal(data, &ev); err != nil {
+			g.log.Errorf("Cannot decode %s event: %v", event, err)
+			return
+		}
+		if svrCfg, err := ev.DecodeServerCfg(); err == nil {
+			g.serverConnectionChanged(svrCfg.Connected)
 		}
 	}
+}
 
-	// FIXME : support multiple servers
-	sdks := []xaapiv1.SDK{}
-	if err := g.httpCli.Get("/servers/0/sdks", &sdks); err != nil {
-		return int(syscallEBADE), err
+// selectServer returns the index of the server defined by its ID or url.
+// An unknown url replaces the url of the only defined server or, when several
+// servers are defined, is added to xds-agent configuration.
+func (g *GdbXds) selectServer(xdsConf *xaapiv1.APIConfig) (int, error) {
+	if g.serverID != "" {
+		idx := -1
+		ids := []string{}
+		for i, s := range xdsConf.Servers {
+			ids = append(ids, s.ID)
+			if s.ID == g.serverID {
+				return i, nil
+			}
+			// check as prefix to support short/partial id name
+			if strings.HasPrefix(s.ID, g.serverID) {
+				if idx != -1 {
+					return -1, fmt.Errorf("Ambiguous XDS server ID '%s' (matches %s and %s)",
+						g.serverID, xdsConf.Servers[idx].ID, s.ID)
+				}
+				idx = i
+			}
+		}
+		if idx == -1 {
+			return -1, fmt.Errorf("Unknown XDS server ID '%s' (available: %s)", g.serverID, strings.Join(ids, ", "))
+		}
+		return idx, nil
+	}
+
+	idx := -1
+	for i, s := range xdsConf.Servers {
+		if sameURL(s.URL, g.serverURL) {
+			idx = i
+			break
+		}
 	}
-	fmt.Fprintln(writer, "\nList of installed cross SDKs (use: export XDS_SDK_ID=<< ID >>):")
-	fmt.Fprintln(writer, "ID \t Name")
-	for _, s := range sdks {
-		fmt.Fprintf(writer, " %s \t  %s\n", s.ID, s.Name)
+	if idx >= 0 && xdsConf.Servers[idx].Connected {
+		return idx, nil
 	}
 
-	if len(g.projects) > 0 && len(sdks) > 0 {
-		fmt.Fprintln(writer, "")
-		fmt.Fprintln(writer, "For example: ")
-		if runtime.GOOS == "windows" {
-			fmt.Fprintf(writer, "  SET XDS_PROJECT_ID=%s && SET XDS_SDK_ID=%s &&  %s -x myGdbConf.ini\n",
-				g.projects[0].ID[:8], sdks[0].ID[:8], AppName)
-		} else {
-			fmt.Fprintf(writer, "  XDS_PROJECT_ID=%s XDS_SDK_ID=%s  %s -x myGdbConf.ini\n",
-				g.projects[0].ID[:8], sdks[0].ID[:8], AppName)
+	// Update config to add or (re)connect server
+	if idx == -1 && len(xdsConf.Servers) == 1 {
+		idx = 0
+		xdsConf.Servers[idx].URL = g.serverURL
+	} else if idx == -1 {
+		xdsConf.Servers = append(xdsConf.Servers, xaapiv1.ServerCfg{URL: g.serverURL})
+		idx = len(xdsConf.Servers) - 1
+	}
+	xdsConf.Servers[idx].ConnRetry = 10
+	newCfg := xaapiv1.APIConfig{}
+	if err := g.link.Request("POST", "/config", *xdsConf, &newCfg); err != nil {
+		return -1, err
+	}
+	for i, s := range newCfg.Servers {
+		if sameURL(s.URL, g.serverURL) {
+			*xdsConf = newCfg
+			return i, nil
 		}
 	}
-	fmt.Fprintln(writer, "")
-	fmt.Fprintln(writer, "Or define settings within gdb configuration file (see help and :XDS-ENV: tag)")
-	writer.Flush()
+	return -1, fmt.Errorf("Cannot add XDS server %s to xds-agent configuration", g.serverURL)
+}
 
-	return 0, fmt.Errorf(msg)
+// serverIndex returns the index of a server from its ID (-1 when not found)
+func (g *GdbXds) serverIndex(id string) int {
+	for i, s := range g.servers {
+		if s.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// sameURL returns true when both urls reference the same server
+func sameURL(u1, u2 string) bool {
+	trim := func(u string) string {
+		u = strings.TrimSuffix(strings.TrimSpace(u), "/")
+		u = strings.TrimPrefix(u, "http://")
+		return strings.TrimPrefix(u, "https://")
+	}
+	return trim(u1) == trim(u2)
 }
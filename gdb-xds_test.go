@@ -0,0 +1,733 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	"gopkg.in/yaml.v2"
+)
+
+func newTestGdbXds(t *testing.T, a *fakeAgent, config map[string]string) *GdbXds {
+	tlog := logrus.New()
+	tlog.Out = ioutil.Discard
+	tlog.Level = logrus.DebugLevel
+
+	g := NewGdbXds(tlog, []string{"--interpreter=mi2", "helloworld"}, []string{"MYVAR=1"})
+	conf := map[string]string{
+		"agentURL": a.URL(),
+		"prjID":    a.projects[0].ID[:8],
+		"sdkID":    a.sdks[0][0].ID,
+
+		// heartbeat is only enabled by tests checking it
+		"heartbeatInterval": "0",
+	}
+	for k, v := range config {
+		conf[k] = v
+	}
+	for k, v := range conf {
+		if err := g.SetConfig(k, v); err != nil {
+			t.Fatalf("SetConfig %s: %v", k, err)
+		}
+	}
+	return g
+}
+
+// startTestGdbXds initializes GdbXds and starts gdb on fake agent
+func startTestGdbXds(t *testing.T, a *fakeAgent, config map[string]string) *GdbXds {
+	g := newTestGdbXds(t, a, config)
+	if code, err := g.Init(); code != 0 || err != nil {
+		t.Fatalf("Init failed: code=%d err=%v", code, err)
+	}
+	a.WaitSocket()
+	if code, err := g.Start(false); code != 0 || err != nil {
+		t.Fatalf("Start failed: code=%d err=%v", code, err)
+	}
+	return g
+}
+
+// captureStdout returns what f writes on stdout
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	outC := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		outC <- buf.String()
+	}()
+	f()
+	w.Close()
+	return <-outC
+}
+
+func TestGdbXdsInit(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+
+	g := newTestGdbXds(t, a, nil)
+	code, err := g.Init()
+	if code != 0 || err != nil {
+		t.Fatalf("Init failed: code=%d err=%v", code, err)
+	}
+	a.WaitSocket()
+
+	if g.link.SessionID() != a.sid {
+		t.Errorf("Invalid session ID: %q", g.link.SessionID())
+	}
+	if len(g.projects) != len(a.projects) {
+		t.Errorf("Invalid projects list: %v", g.projects)
+	}
+	evs := a.Events()
+	if len(evs) != 1 || evs[0].Name != xaapiv1.EVTServerConfig {
+		t.Errorf("Server config event not registered: %v", evs)
+	}
+	if len(a.ConfigPosts()) != 0 {
+		t.Errorf("Unexpected config update: %v", a.ConfigPosts())
+	}
+}
+
+func TestGdbXdsInitServerNotConnected(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+	a.config.Servers[0].Connected = false
+
+	g := newTestGdbXds(t, a, nil)
+	code, err := g.Init()
+	if code != int(syscallEBADE) || err == nil || !strings.Contains(err.Error(), "XDS server not connected") {
+		t.Errorf("Unexpected result: code=%d err=%v", code, err)
+	}
+}
+
+func TestGdbXdsInitServerURL(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+	a.config.Servers[0].Connected = false
+
+	g := newTestGdbXds(t, a, map[string]string{"serverURL": "http://xds-server:8000"})
+	if code, err := g.Init(); code != 0 || err != nil {
+		t.Fatalf("Init failed: code=%d err=%v", code, err)
+	}
+	if len(a.ConfigPosts()) != 1 {
+		t.Errorf("Config not updated: %v", a.ConfigPosts())
+	}
+}
+
+// addTestServer adds a second XDS server hosting one project
+func addTestServer(a *fakeAgent, connected bool) xaapiv1.ProjectConfig {
+	a.AddServer(xaapiv1.ServerCfg{ID: "other-server", URL: "http://other:8000", Connected: connected},
+		[]xaapiv1.SDK{xaapiv1.SDK{ID: "c7e1f2a3-6b4d-5e8f-9a0b-1c2d3e4f5a6b", Name: "poky-agl_armv7vehf_4.0.1"}})
+	prj := xaapiv1.ProjectConfig{
+		ID:         "2b3c4d5e-6f70-8192-a3b4-c5d6e7f80912",
+		ServerID:   "other-server",
+		Label:      "other-prj",
+		ClientPath: "/home/user/other",
+		ServerPath: "/srv/other",
+	}
+	a.projects = append(a.projects, prj)
+	return prj
+}
+
+func TestGdbXdsServerFromProject(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+	prj := addTestServer(a, true)
+
+	g := newTestGdbXds(t, a, map[string]string{"prjID": prj.ID[:6], "sdkID": "c7e1"})
+	if code, err := g.Init(); code != 0 || err != nil {
+		t.Fatalf("Init failed: code=%d err=%v", code, err)
+	}
+	if g.svrIdx != 1 {
+		t.Errorf("Server of project not selected: %d", g.svrIdx)
+	}
+
+	// Server of project is checked
+	a.config.Servers[0].Connected = false
+	g = newTestGdbXds(t, a, map[string]string{"prjID": prj.ID[:6], "sdkID": "c7e1"})
+	if code, err := g.Init(); code != 0 || err != nil {
+		t.Errorf("Init failed: code=%d err=%v", code, err)
+	}
+}
+
+func TestGdbXdsServerID(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+	prj := addTestServer(a, true)
+
+	g := newTestGdbXds(t, a, map[string]string{"serverID": "other", "prjID": prj.ID[:6], "sdkID": "c7e1"})
+	if code, err := g.Init(); code != 0 || err != nil {
+		t.Fatalf("Init failed: code=%d err=%v", code, err)
+	}
+	if g.svrIdx != 1 {
+		t.Errorf("Invalid selected server: %d", g.svrIdx)
+	}
+
+	// Project of another server cannot be used
+	g = newTestGdbXds(t, a, map[string]string{"serverID": "fake"})
+	if code, err := g.Init(); code != 0 || err != nil {
+		t.Fatalf("Init failed: code=%d err=%v", code, err)
+	}
+	g.SetConfig("prjID", prj.ID[:6])
+	if _, err := g.resolveProject(); err == nil {
+		t.Errorf("Project of another server must not be found")
+	}
+
+	for _, id := range []string{"unknown", "fake-server-"} {
+		g = newTestGdbXds(t, a, map[string]string{"serverID": id})
+		if code, err := g.Init(); code != int(syscallEBADE) || err == nil {
+			t.Errorf("Unexpected result for server %s: code=%d err=%v", id, code, err)
+		}
+	}
+}
+
+func TestGdbXdsServerURLAdded(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+	addTestServer(a, true)
+	a.projects = append(a.projects, xaapiv1.ProjectConfig{ID: "3c4d5e6f", ServerID: "fake-server-2", Label: "third-prj"})
+	a.sdks[2] = []xaapiv1.SDK{xaapiv1.SDK{ID: "d8f2a3b4", Name: "poky-agl_x86-64_4.0.1"}}
+
+	g := newTestGdbXds(t, a, map[string]string{"serverURL": "http://third:8000", "prjID": "third-prj", "sdkID": "d8"})
+	if code, err := g.Init(); code != 0 || err != nil {
+		t.Fatalf("Init failed: code=%d err=%v", code, err)
+	}
+	posts := a.ConfigPosts()
+	if len(posts) != 1 || len(posts[0].Servers) != 3 || posts[0].Servers[2].URL != "http://third:8000" {
+		t.Errorf("Server not added: %v", posts)
+	}
+	if g.svrIdx != 2 {
+		t.Errorf("Invalid selected server: %d", g.svrIdx)
+	}
+}
+
+func TestGdbXdsPrintProjectsListMultiServer(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+	prj := addTestServer(a, true)
+
+	g := newTestGdbXds(t, a, map[string]string{"prjID": ""})
+	out := captureStdout(t, func() { g.Init() })
+	for _, s := range []string{"fake-server", "other-server", "http://other:8000", prj.ID, a.sdks[1][0].ID} {
+		if !strings.Contains(out, s) {
+			t.Errorf("%s not listed:\n%s", s, out)
+		}
+	}
+
+	// Only selected server is listed
+	g = newTestGdbXds(t, a, map[string]string{"prjID": "", "serverID": "other-server"})
+	out = captureStdout(t, func() { g.Init() })
+	if strings.Contains(out, a.projects[0].ID) || strings.Contains(out, a.sdks[0][0].ID) {
+		t.Errorf("Projects or SDKs of other servers listed:\n%s", out)
+	}
+
+	// SDKs of disconnected servers are not listed
+	a.config.Servers[1].Connected = false
+	g = newTestGdbXds(t, a, map[string]string{"prjID": ""})
+	out = captureStdout(t, func() { g.Init() })
+	if strings.Contains(out, a.sdks[1][0].ID) || !strings.Contains(out, "not connected") {
+		t.Errorf("Invalid list with disconnected server:\n%s", out)
+	}
+}
+
+func TestGdbXdsInitAgentDown(t *testing.T) {
+	a := newFakeAgent(t)
+	a.Close()
+
+	g := newTestGdbXds(t, a, nil)
+	code, err := g.Init()
+	if code != int(syscallEBADE) || err == nil || !strings.Contains(err.Error(), "Cannot connection to") {
+		t.Errorf("Unexpected result: code=%d err=%v", code, err)
+	}
+}
+
+func TestGdbXdsInitAPIError(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+	a.FailRequest("/config", 500)
+
+	g := newTestGdbXds(t, a, nil)
+	if code, err := g.Init(); code != int(syscallEBADE) || err == nil {
+		t.Errorf("Unexpected result: code=%d err=%v", code, err)
+	}
+}
+
+func TestGdbXdsPrintProjectsList(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+
+	g := newTestGdbXds(t, a, map[string]string{"prjID": ""})
+	g.SetConfig("listProject", true)
+	var code int
+	var err error
+	out := captureStdout(t, func() {
+		code, err = g.Init()
+	})
+	if code != 0 || err != nil {
+		t.Errorf("Unexpected result: code=%d err=%v", code, err)
+	}
+	for _, p := range a.projects {
+		if !strings.Contains(out, p.ID) || !strings.Contains(out, p.Label) {
+			t.Errorf("Project %s not listed:\n%s", p.ID, out)
+		}
+	}
+	for _, s := range a.sdks[0] {
+		if !strings.Contains(out, s.ID) || !strings.Contains(out, s.Name) {
+			t.Errorf("SDK %s not listed:\n%s", s.ID, out)
+		}
+	}
+	if !strings.Contains(out, "XDS_PROJECT_ID="+a.projects[0].ID[:1]+" XDS_SDK_ID="+a.sdks[0][0].ID[:1]) {
+		t.Errorf("Example not printed:\n%s", out)
+	}
+
+	// List is also printed when project is not set, but it's an error
+	g = newTestGdbXds(t, a, map[string]string{"prjID": ""})
+	out = captureStdout(t, func() {
+		code, err = g.Init()
+	})
+	if code != int(syscall.EINVAL) || err == nil || !strings.Contains(out, a.projects[0].ID) {
+		t.Errorf("Unexpected result without project: code=%d err=%v\n%s", code, err, out)
+	}
+}
+
+func TestGdbXdsPrintProjectsListFormat(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+
+	for _, format := range []string{"json", "yaml"} {
+		g := newTestGdbXds(t, a, map[string]string{"prjID": "", "listFormat": format})
+		g.SetConfig("listProject", true)
+		var code int
+		var err error
+		out := captureStdout(t, func() {
+			code, err = g.Init()
+		})
+		if code != 0 || err != nil {
+			t.Fatalf("Unexpected result: code=%d err=%v", code, err)
+		}
+
+		list := XdsList{}
+		if format == "json" {
+			err = json.Unmarshal([]byte(out), &list)
+		} else {
+			err = yaml.Unmarshal([]byte(out), &list)
+		}
+		if err != nil {
+			t.Fatalf("Cannot decode %s output: %v\n%s", format, err, out)
+		}
+		if len(list.Servers) != 1 || list.Servers[0].URL != a.config.Servers[0].URL || !list.Servers[0].Connected {
+			t.Errorf("Invalid servers: %+v", list.Servers)
+		}
+		p := a.projects[1]
+		if len(list.Projects) != 2 || list.Projects[1] != (XdsListProject{ID: p.ID, ShortID: "1", Label: p.Label,
+			ClientPath: p.ClientPath, ServerPath: p.ServerPath, Type: string(p.Type), Status: p.Status, ServerID: p.ServerID}) {
+			t.Errorf("Invalid projects: %+v", list.Projects)
+		}
+		s := a.sdks[0][0]
+		if len(list.Sdks) != 2 || list.Sdks[0] != (XdsListSdk{ID: s.ID, ShortID: "e", Name: s.Name, Arch: s.Arch,
+			Version: s.Version, ServerID: "fake-server"}) {
+			t.Errorf("Invalid SDKs: %+v", list.Sdks)
+		}
+	}
+
+	g := newTestGdbXds(t, a, nil)
+	if err := g.SetConfig("listFormat", "xml"); err == nil {
+		t.Errorf("Invalid format must be rejected")
+	}
+}
+
+func TestGdbXdsStart(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+
+	g := newTestGdbXds(t, a, map[string]string{"rPath": "build"})
+	if code, err := g.Init(); code != 0 || err != nil {
+		t.Fatalf("Init failed: code=%d err=%v", code, err)
+	}
+	a.WaitSocket()
+
+	outC := make(chan string, 10)
+	recC := make(chan *MIRecord, 10)
+	exitC := make(chan int, 1)
+	g.Read(func(timestamp, stdout, stderr string) { outC <- stdout })
+	g.OnMIRecord(func(rec *MIRecord) { recC <- rec })
+	g.OnExit(func(code int, err error) { exitC <- code })
+
+	if code, err := g.Start(true); code != 0 || err != nil {
+		t.Fatalf("Start failed: code=%d err=%v", code, err)
+	}
+	if g.cmdID != a.CmdID() {
+		t.Errorf("Invalid command ID: %q", g.cmdID)
+	}
+	ea := a.ExecArgs()
+	if len(ea) != 1 {
+		t.Fatalf("Invalid exec requests: %v", ea)
+	}
+	if ea[0].ID != g.prjID || ea[0].SdkID != g.sdkID || ea[0].RPath != "build" || !ea[0].TTY ||
+		ea[0].Cmd != "exec $GDB" || strings.Join(ea[0].Args, " ") != "--interpreter=mi2 helloworld" ||
+		strings.Join(ea[0].Env, " ") != "MYVAR=1" {
+		t.Errorf("Invalid exec arguments: %+v", ea[0])
+	}
+
+	// Server paths must be translated into client paths
+	a.Output("*stopped,frame={fullname=\"/home/devel/xds-workspace/helloworld/main.c\",line=\"3\"}\n(gdb) \n", "")
+	select {
+	case out := <-outC:
+		if !strings.Contains(out, "/home/user/xds-workspace/helloworld/main.c") {
+			t.Errorf("Path not translated: %q", out)
+		}
+	case <-time.After(fakeAgentTimeout):
+		t.Fatal("Timeout while waiting gdb output")
+	}
+	select {
+	case rec := <-recC:
+		if rec.Type != MIRecordExec || rec.Class != "stopped" {
+			t.Errorf("Unexpected MI record: %v", rec)
+		}
+	case <-time.After(fakeAgentTimeout):
+		t.Fatal("Timeout while waiting MI record")
+	}
+
+	a.Exit(3)
+	select {
+	case code := <-exitC:
+		if code != 3 {
+			t.Errorf("Unexpected exit code: %d", code)
+		}
+	case <-time.After(fakeAgentTimeout):
+		t.Fatal("Timeout while waiting exit")
+	}
+}
+
+func TestGdbXdsStartErrors(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+
+	g := newTestGdbXds(t, a, map[string]string{"sdkID": ""})
+	if code, err := g.Init(); code != 0 || err != nil {
+		t.Fatalf("Init failed: code=%d err=%v", code, err)
+	}
+	if code, err := g.Start(false); code != int(syscall.EINVAL) || err == nil {
+		t.Errorf("Unexpected result without sdkID: code=%d err=%v", code, err)
+	}
+
+	g.SetConfig("sdkID", a.sdks[0][0].ID)
+	a.FailRequest("/exec", 500)
+	if code, err := g.Start(false); code != int(syscall.EAGAIN) || err == nil {
+		t.Errorf("Unexpected result on exec error: code=%d err=%v", code, err)
+	}
+}
+
+func TestGdbXdsWrite(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+	g := startTestGdbXds(t, a, nil)
+
+	g.Write("-break-insert /home/user/xds-workspace/helloworld/main.c:3\n")
+	if in := a.WaitStdin(); in != "-break-insert /home/devel/xds-workspace/helloworld/main.c:3\n" {
+		t.Errorf("Unexpected gdb stdin: %q", in)
+	}
+
+	infC := make(chan string, 1)
+	g.InferiorRead(func(timestamp, stdout, stderr string) { infC <- stdout })
+	g.InferiorWrite("hello\n")
+	if in := a.WaitInferiorStdin(); in != "hello\n" {
+		t.Errorf("Unexpected inferior stdin: %q", in)
+	}
+	a.InferiorOutput("world\n", "")
+	select {
+	case out := <-infC:
+		if out != "world\n" {
+			t.Errorf("Unexpected inferior output: %q", out)
+		}
+	case <-time.After(fakeAgentTimeout):
+		t.Fatal("Timeout while waiting inferior output")
+	}
+}
+
+func TestGdbXdsSendSignal(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+
+	g := newTestGdbXds(t, a, nil)
+	if err := g.SendSignal(syscall.SIGINT); err == nil {
+		t.Errorf("Signal must not be sent before Start")
+	}
+
+	g = startTestGdbXds(t, a, nil)
+	if err := g.SendSignal(syscall.SIGINT); err != nil {
+		t.Fatalf("SendSignal failed: %v", err)
+	}
+	sig := a.WaitSignal()
+	if sig.CmdID != a.CmdID() || sig.Signal != syscall.SIGINT.String() {
+		t.Errorf("Unexpected signal: %+v", sig)
+	}
+}
+
+func TestGdbXdsDisconnect(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+	g := startTestGdbXds(t, a, map[string]string{"reconnectTimeout": "0"})
+
+	discC := make(chan error, 1)
+	g.OnDisconnect(func(err error) { discC <- err })
+	a.Disconnect()
+	select {
+	case <-discC:
+	case <-time.After(fakeAgentTimeout):
+		t.Fatal("Timeout while waiting disconnection")
+	}
+	if err := g.Write("-exec-next\n"); err == nil {
+		t.Errorf("Write must fail once disconnected")
+	}
+}
+
+func TestGdbXdsReconnect(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+	g := startTestGdbXds(t, a, map[string]string{"reconnectTimeout": "10"})
+
+	a.Disconnect()
+	time.Sleep(100 * time.Millisecond)
+
+	// Data written while link is down must be sent once reconnected
+	g.Write("-exec-next\n")
+	a.WaitSocket()
+	if in := a.WaitStdin(); in != "-exec-next\n" {
+		t.Errorf("Unexpected gdb stdin: %q", in)
+	}
+
+	outC := make(chan string, 1)
+	g.Read(func(timestamp, stdout, stderr string) { outC <- stdout })
+	a.Output("^done\n", "")
+	select {
+	case out := <-outC:
+		if out != "^done\n" {
+			t.Errorf("Unexpected gdb output: %q", out)
+		}
+	case <-time.After(fakeAgentTimeout):
+		t.Fatal("Timeout while waiting gdb output")
+	}
+}
+
+func TestGdbXdsReconnectReplay(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+	g := startTestGdbXds(t, a, map[string]string{"reconnectTimeout": "10"})
+
+	outC := make(chan string, 10)
+	g.Read(func(timestamp, stdout, stderr string) { outC <- stdout })
+	g.InferiorRead(func(timestamp, stdout, stderr string) { outC <- "inferior:" + stdout })
+	waitOutput := func(exp string) {
+		select {
+		case out := <-outC:
+			if out != exp {
+				t.Errorf("Unexpected output: %q (expected %q)", out, exp)
+			}
+		case <-time.After(fakeAgentTimeout):
+			t.Fatalf("Timeout while waiting output %q", exp)
+		}
+	}
+	a.Output("^running\n", "")
+	a.InferiorOutput("start\n", "")
+	waitOutput("^running\n")
+	waitOutput("inferior:start\n")
+
+	// Output emitted while link is down is received once reattached
+	a.Disconnect()
+	a.Output("*stopped,reason=\"breakpoint-hit\"\n", "")
+	a.InferiorOutput("hello\n", "")
+	a.Output("(gdb)\n", "")
+	a.WaitSocket()
+	waitOutput("*stopped,reason=\"breakpoint-hit\"\n")
+	waitOutput("inferior:hello\n")
+	waitOutput("(gdb)\n")
+
+	reattachs := a.Reattachs()
+	if len(reattachs) != 1 || reattachs[0] != (reattachArgs{CmdID: a.CmdID(), OutOffset: 1, InferiorOffset: 1}) {
+		t.Errorf("Unexpected reattach requests: %+v", reattachs)
+	}
+
+	// Following output is not duplicated
+	a.Output("^done\n", "")
+	waitOutput("^done\n")
+	select {
+	case out := <-outC:
+		t.Errorf("Unexpected output: %q", out)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestGdbXdsReconnectExited(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+	g := startTestGdbXds(t, a, map[string]string{"reconnectTimeout": "10"})
+
+	// gdb exits while link is down
+	exitC := make(chan int, 1)
+	g.OnExit(func(code int, err error) { exitC <- code })
+	a.Disconnect()
+	a.Exit(3)
+	select {
+	case code := <-exitC:
+		if code != 3 {
+			t.Errorf("Unexpected exit code: %d", code)
+		}
+	case <-time.After(fakeAgentTimeout):
+		t.Fatal("Timeout while waiting exit")
+	}
+}
+
+func TestGdbXdsReconnectUnknownCmd(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+	g := startTestGdbXds(t, a, map[string]string{"reconnectTimeout": "30"})
+
+	// agent restarted: command cannot be reattached, reconnection is not
+	// retried till timeout
+	discC := make(chan error, 1)
+	g.OnDisconnect(func(err error) { discC <- err })
+	a.Disconnect()
+	a.mutex.Lock()
+	a.cmdID = "fake-cmd-restarted"
+	a.mutex.Unlock()
+	select {
+	case err := <-discC:
+		if err == nil || !strings.Contains(err.Error(), "cannot reattach to command") {
+			t.Errorf("Unexpected disconnection error: %v", err)
+		}
+	case <-time.After(fakeAgentTimeout):
+		t.Fatal("Timeout while waiting disconnection")
+	}
+	if n := len(a.Reattachs()); n != 1 {
+		t.Errorf("Reattach requested %d times", n)
+	}
+}
+
+func TestGdbXdsReconnectNoReattach(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+
+	// agent that doesn't support reattach: events channel is reopened
+	// but output emitted while link was down is lost
+	a.SetCapabilities()
+	g := startTestGdbXds(t, a, map[string]string{"reconnectTimeout": "10"})
+	outC := make(chan string, 10)
+	g.Read(func(timestamp, stdout, stderr string) { outC <- stdout })
+
+	a.Disconnect()
+	a.Output("lost\n", "")
+	time.Sleep(100 * time.Millisecond)
+	g.Write("-exec-next\n")
+	a.WaitSocket()
+	if in := a.WaitStdin(); in != "-exec-next\n" {
+		t.Errorf("Unexpected gdb stdin: %q", in)
+	}
+	if r := a.Reattachs(); len(r) != 0 {
+		t.Errorf("Unexpected reattach requests: %+v", r)
+	}
+
+	a.Output("^done\n", "")
+	select {
+	case out := <-outC:
+		if out != "^done\n" {
+			t.Errorf("Unexpected gdb output: %q", out)
+		}
+	case <-time.After(fakeAgentTimeout):
+		t.Fatal("Timeout while waiting gdb output")
+	}
+}
+
+func TestGdbXdsServerLost(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+	g := startTestGdbXds(t, a, map[string]string{"reconnectTimeout": "0"})
+
+	exitC := make(chan error, 1)
+	g.OnExit(func(code int, err error) { exitC <- err })
+	a.ServerConnected(false)
+	select {
+	case err := <-exitC:
+		if err == nil || !strings.Contains(err.Error(), "XDS Server disconnected") {
+			t.Errorf("Unexpected exit error: %v", err)
+		}
+	case <-time.After(fakeAgentTimeout):
+		t.Fatal("Timeout while waiting exit")
+	}
+}
+
+func TestForwardStdin(t *testing.T) {
+	a := newFakeAgent(t)
+	defer a.Close()
+	g := startTestGdbXds(t, a, nil)
+
+	rules, _ := ParseMIRewriteLegacy("-exec-run:-exec-continue")
+	rules = append(rules, MIRewriteRule{Operation: "-gdb-set", Drop: true})
+	rw, err := NewMIRewriterFromRules(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in, inW := io.Pipe()
+	defer inW.Close()
+	outR, out := io.Pipe()
+	outRd := bufio.NewReader(outR)
+	go forwardStdin(g, rw, in, out, make(chan exitResult, 1))
+
+	inW.Write([]byte("12-exec-run\n"))
+	if s := a.WaitStdin(); s != "12-exec-continue\n" {
+		t.Errorf("Command not rewritten: %q", s)
+	}
+
+	// Dropped command is directly acknowledged
+	inW.Write([]byte("13-gdb-set confirm off\n14-exec-next\n"))
+	for _, exp := range []string{"13^done\n", "(gdb) \n"} {
+		if s, _ := outRd.ReadString('\n'); s != exp {
+			t.Errorf("Unexpected reply of dropped command: %q", s)
+		}
+	}
+	if s := a.WaitStdin(); s != "14-exec-next\n" {
+		t.Errorf("Unexpected command: %q", s)
+	}
+
+	// -gdb-exit is preceded by a SIGINT
+	inW.Write([]byte("15-gdb-exit\n"))
+	if sig := a.WaitSignal(); sig.Signal != syscall.SIGINT.String() {
+		t.Errorf("Unexpected signal: %+v", sig)
+	}
+	if s := a.WaitStdin(); s != "15-gdb-exit\n" {
+		t.Errorf("Unexpected command: %q", s)
+	}
+}
@@ -23,21 +23,20 @@ package main
 import (
 	"bufio"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"os/signal"
-	"os/user"
 	"syscall"
 	"time"
 
 	"strings"
 
 	"path"
+	"path/filepath"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
 	common "github.com/iotbzh/xds-common/golib"
-	"github.com/joho/godotenv"
 )
 
 var appAuthors = []cli.Author{
@@ -77,6 +76,8 @@ type EnvVar struct {
 	Name        string
 	Usage       string
 	Destination *string
+	Validate    func(value string) error
+	Secret      bool // value is never logged, printed or forwarded to gdb
 }
 
 // exitError terminates this program with the specified error
@@ -90,9 +91,16 @@ func exitError(code syscall.Errno, f string, a ...interface{}) {
 
 // main
 func main() {
-	var agentURL, serverURL string
+	var agentURL, serverURL, serverID string
 	var prjID, rPath, logLevel, logFile, sdkid, confFile, gdbNative string
-	var listProject bool
+	var overwriteRules, overwritePreset, pathMap, reconnectTmo string
+	var gdbTemplate, gdbTemplateGdb, profile string
+	var listProject, dapMode bool
+	var listFormat string
+	var agentToken, agentTokenFile, agentTokenHelper string
+	var tlsCAFile, tlsCertFile, tlsKeyFile, tlsInsecure string
+	var proxy, connectTmo, requestTmo, retryTmo, transport string
+	var heartbeatInterval, heartbeatMaxMissed, latencyThreshold string
 	var err error
 
 	// Init Logger and set temporary file and level for the 1st part
@@ -129,18 +137,35 @@ func main() {
 			Usage:       "list existing xds projects",
 			Destination: &listProject,
 		},
+		cli.StringFlag{
+			Name:        "format",
+			Usage:       "output format of list option (table, json or yaml)",
+			Value:       "table",
+			Destination: &listFormat,
+		},
+		cli.BoolFlag{
+			Name:        "dap",
+			Usage:       "run as a Debug Adapter Protocol server on stdin/stdout",
+			Destination: &dapMode,
+		},
 	}
 
 	appEnvVars := []EnvVar{
 		EnvVar{
 			Name:        "XDS_CONFIG",
-			Usage:       "env config file to source on startup",
+			Usage:       "env config file to source on startup, or profiles config file (*.toml)",
 			Destination: &confFile,
 		},
+		EnvVar{
+			Name:        "XDS_PROFILE",
+			Usage:       "profile of " + xdsProfilesFile + " config file to use (default: profile set by default key)",
+			Destination: &profile,
+		},
 		EnvVar{
 			Name:        "XDS_LOGLEVEL",
 			Usage:       "logging level (supported levels: panic, fatal, error, warn, info, debug)",
 			Destination: &logLevel,
+			Validate:    validateLogLevel,
 		},
 		EnvVar{
 			Name:        "XDS_LOGFILE",
@@ -149,14 +174,58 @@ func main() {
 		},
 		EnvVar{
 			Name:        "XDS_NATIVE_GDB",
-			Usage:       "use native gdb instead of remote XDS server",
+			Usage:       "use native gdb instead of remote XDS server (value may be the path of gdb to use)",
 			Destination: &gdbNative,
 		},
+		EnvVar{
+			Name:        "XDS_GDB_TEMPLATE",
+			Usage:       "command template used to run gdb instead of remote XDS server (placeholders: {gdb}, {args}, {env}, {cwd})",
+			Destination: &gdbTemplate,
+		},
+		EnvVar{
+			Name:        "XDS_GDB_TEMPLATE_GDB",
+			Usage:       "gdb binary used by XDS_GDB_TEMPLATE command (default: gdb)",
+			Destination: &gdbTemplateGdb,
+		},
+		EnvVar{
+			Name:        "XDS_OVERWRITE_RULES",
+			Usage:       "json file defining presets of gdb commands overwrite rules",
+			Destination: &overwriteRules,
+		},
+		EnvVar{
+			Name:        "XDS_OVERWRITE_PRESET",
+			Usage:       "gdb commands overwrite preset to use: " + miDefaultPreset + " (default with XDS backend), vscode, eclipse, qtcreator, clion or " + miNonePreset + " to disable (default with native and template backends)",
+			Destination: &overwritePreset,
+		},
+		EnvVar{
+			Name:  "XDS_OVERWRITE_COMMANDS",
+			Usage: "legacy gdb commands overwrite rules (takes precedence over XDS_OVERWRITE_PRESET)",
+		},
+		EnvVar{
+			Name:  "XDS_GDBSERVER_OUTPUT_NOFIX",
+			Usage: "disable workaround about inferior output with gdbserver connection (when defined, whatever its value)",
+		},
+		EnvVar{
+			Name:  "XDS_GDBSERVER_EXIT_NOFIX",
+			Usage: "disable workaround to correctly close gdbserver connection on exit (when defined, whatever its value)",
+		},
+		EnvVar{
+			Name:        "XDS_PATH_MAP",
+			Usage:       "additional client/server paths mapping (syntax: clientPath=serverPath,...)",
+			Destination: &pathMap,
+			Validate:    validatePathMap,
+		},
 		EnvVar{
 			Name:        "XDS_PROJECT_ID",
-			Usage:       "project ID you want to build (mandatory variable)",
+			Usage:       "project you want to build: ID, unique ID prefix or label (mandatory variable)",
 			Destination: &prjID,
 		},
+		EnvVar{
+			Name:        "XDS_RECONNECT_TIMEOUT",
+			Usage:       "max time in seconds to wait XDS agent/server reconnection (default 120, 0 to disable)",
+			Destination: &reconnectTmo,
+			Validate:    validateUint,
+		},
 		EnvVar{
 			Name:        "XDS_RPATH",
 			Usage:       "relative path into project",
@@ -164,18 +233,110 @@ func main() {
 		},
 		EnvVar{
 			Name:        "XDS_SDK_ID",
-			Usage:       "Cross Sdk ID to use to build project",
+			Usage:       "Cross Sdk to use to build project: ID, unique ID prefix, name or version/arch (eg. \"aarch64 4.0.1\")",
 			Destination: &sdkid,
 		},
 		EnvVar{
 			Name:        "XDS_AGENT_URL",
-			Usage:       "local XDS agent url",
+			Usage:       "local XDS agent url: port, host:port, url (http, https, ws or wss scheme) or unix socket (eg. unix:///run/user/1000/xds-agent.sock)",
 			Destination: &agentURL,
+			Validate:    validateAgentURL,
+		},
+		EnvVar{
+			Name:        "XDS_TLS_CA_FILE",
+			Usage:       "CA bundle (PEM) used to verify certificate of https XDS agent (default: system CAs)",
+			Destination: &tlsCAFile,
+		},
+		EnvVar{
+			Name:        "XDS_TLS_CERT_FILE",
+			Usage:       "client certificate (PEM) used to connect https XDS agent",
+			Destination: &tlsCertFile,
+		},
+		EnvVar{
+			Name:        "XDS_TLS_KEY_FILE",
+			Usage:       "key (PEM) of client certificate",
+			Destination: &tlsKeyFile,
+		},
+		EnvVar{
+			Name:        "XDS_TLS_INSECURE_SKIP_VERIFY",
+			Usage:       "do not verify certificate of https XDS agent, only for lab setups (boolean)",
+			Destination: &tlsInsecure,
+			Validate:    validateBool,
+		},
+		EnvVar{
+			Name:        "XDS_HEARTBEAT_INTERVAL",
+			Usage:       "interval in seconds (or duration, eg. 500ms) of heartbeats measuring XDS agent latency (default 10, 0 to disable)",
+			Destination: &heartbeatInterval,
+			Validate:    validateTimeout,
+		},
+		EnvVar{
+			Name:        "XDS_HEARTBEAT_MAX_MISSED",
+			Usage:       "number of consecutive missed heartbeats after which link to XDS agent is lost (default 3)",
+			Destination: &heartbeatMaxMissed,
+			Validate:    validateNonZeroUint,
+		},
+		EnvVar{
+			Name:        "XDS_LATENCY_THRESHOLD",
+			Usage:       "XDS agent latency in milliseconds above which a warning is reported (default 1000, 0 to disable)",
+			Destination: &latencyThreshold,
+			Validate:    validateUint,
+		},
+		EnvVar{
+			Name:        "XDS_TRANSPORT",
+			Usage:       "transport used to reach XDS agent: " + strings.Join(transportNames(), " or ") + " (default " + defaultXdsTransport + ")",
+			Destination: &transport,
+			Validate:    validateTransport,
+		},
+		EnvVar{
+			Name:        "XDS_PROXY",
+			Usage:       "proxy url used to reach XDS agent, or none (default: HTTP_PROXY, HTTPS_PROXY and NO_PROXY env vars)",
+			Destination: &proxy,
+			Validate:    validateProxy,
+		},
+		EnvVar{
+			Name:        "XDS_CONNECT_TIMEOUT",
+			Usage:       "timeout in seconds of connection to XDS agent (default 30)",
+			Destination: &connectTmo,
+			Validate:    validateTimeout,
+		},
+		EnvVar{
+			Name:        "XDS_REQUEST_TIMEOUT",
+			Usage:       "max time in seconds to wait XDS agent response (default 0: no timeout)",
+			Destination: &requestTmo,
+			Validate:    validateTimeout,
+		},
+		EnvVar{
+			Name:        "XDS_RETRY_TIMEOUT",
+			Usage:       "max time in seconds to retry connection to XDS agent while it is not ready (default 0: no retry)",
+			Destination: &retryTmo,
+			Validate:    validateTimeout,
+		},
+		EnvVar{
+			Name:        "XDS_AGENT_TOKEN",
+			Usage:       "token used to authenticate on XDS agent",
+			Destination: &agentToken,
+			Secret:      true,
+		},
+		EnvVar{
+			Name:        "XDS_AGENT_TOKEN_FILE",
+			Usage:       "file including token used to authenticate on XDS agent, only accessible by owner (default: ~/.config/xds/" + xdsAgentTokenFile + ")",
+			Destination: &agentTokenFile,
+		},
+		EnvVar{
+			Name:        "XDS_AGENT_TOKEN_HELPER",
+			Usage:       "credential helper command printing token used to authenticate on XDS agent",
+			Destination: &agentTokenHelper,
+		},
+		EnvVar{
+			Name:        "XDS_SERVER_ID",
+			Usage:       "ID of XDS server to use (default: server of project)",
+			Destination: &serverID,
 		},
 		EnvVar{
 			Name:        "XDS_SERVER_URL",
-			Usage:       "overwrite remote XDS server url (default value set in xds-agent-config.json file)",
+			Usage:       "url of XDS server to use, added to xds-agent config when unknown (default value set in xds-agent-config.json file)",
 			Destination: &serverURL,
+			Validate:    validateURL,
 		},
 	}
 
@@ -185,14 +346,31 @@ func main() {
 	args[0] = os.Args[0]
 	gdbArgs := make([]string, len(os.Args))
 
+	// config sub-command (see runConfigCommand)
+	configCmd := len(os.Args) > 1 && os.Args[1] == "config"
+
 	// Split xds-xxx options from gdb options
 	copy(gdbArgs, os.Args[1:])
 	for idx, a := range os.Args[1:] {
 		// Specific case to print help or version of xds-gdb
 		switch a {
-		case "--help", "-h", "--version", "-v", "--list", "-ls":
+		case "--help", "-h", "--version", "-v":
 			args[1] = a
 			goto endloop
+		case "--list", "-ls":
+			// only keep list options (IOW --format)
+			args = []string{os.Args[0], a}
+			for i, o := range os.Args[1:] {
+				if strings.HasPrefix(o, "--format=") {
+					args = append(args, o)
+				} else if o == "--format" && i+2 < len(os.Args) {
+					args = append(args, o, os.Args[i+2])
+				}
+			}
+			goto endloop
+		case "--dap":
+			dapMode = true
+			gdbArgs[idx] = ""
 		case "--":
 			// Detect skip option (IOW '--') to split arguments
 			copy(args, os.Args[0:idx+1])
@@ -202,6 +380,11 @@ func main() {
 	}
 endloop:
 
+	// DAP mode: gdb is driven using MI interpreter
+	if dapMode {
+		gdbArgs = append([]string{"--interpreter=mi2"}, gdbArgs...)
+	}
+
 	// Parse gdb arguments to detect:
 	//  --tty option: used for inferior/ tty of debugged program
 	//  -x/--command option: XDS env vars may be set within gdb command file
@@ -230,11 +413,31 @@ endloop:
 
 	// Source config env file
 	// (we cannot use confFile var because env variables setting is just after)
-	envMap, confFile, err := loadConfigEnvFile(os.Getenv("XDS_CONFIG"), gdbCmdFile)
-	log.Infof("Load env config: envMap=%v, confFile=%v, err=%v", envMap, confFile, err)
+	envConfFile, profFile := os.Getenv("XDS_CONFIG"), ""
+	if isProfilesFile(envConfFile) {
+		envConfFile, profFile = "", envConfFile
+	}
+	envMap, confFile, origins, err := loadConfigEnvFile(envConfFile, gdbCmdFile)
+	log.Infof("Load env config: envMap=%v, confFile=%v, err=%v", maskSecrets(envMap, appEnvVars), confFile, err)
+
+	// Then apply profile (that may be selected by env config file)
+	if err == nil {
+		var profMap map[string]string
+		var profOrigins map[string]ConfigOrigin
+		profMap, profFile, profile, profOrigins, err = loadConfigProfile(profFile, appEnvVars)
+		log.Infof("Load profile config: profile=%v, envMap=%v, profFile=%v, err=%v", profile, maskSecrets(profMap, appEnvVars), profFile, err)
+		for k, v := range profMap {
+			envMap[k] = v
+		}
+		for k, o := range profOrigins {
+			if _, exist := origins[k]; !exist || o.File != "" {
+				origins[k] = o
+			}
+		}
+	}
 
 	// Only rise an error when args is not set (IOW when --help or --version is not set)
-	if len(args) == 1 {
+	if len(args) == 1 && !configCmd {
 		if err != nil {
 			exitError(syscall.ENOENT, err.Error())
 		}
@@ -248,6 +451,18 @@ endloop:
 			*ev.Destination = evVal
 		}
 	}
+
+	// config sub-command: show or edit xds-gdb settings
+	if configCmd {
+		if err != nil {
+			log.Warnf("Config file error: %v", err)
+		}
+		code, err := runConfigCommand(os.Args[2:], appEnvVars, origins, os.Stdout)
+		if err != nil {
+			exitError(syscall.Errno(code), err.Error())
+		}
+		os.Exit(code)
+	}
 	app.Description = "gdb wrapper for X(cross) Development System\n"
 	app.Description += "\n"
 	app.Description += " Two debugging models are supported:\n"
@@ -255,14 +470,73 @@ endloop:
 	app.Description += "  - native debugging\n"
 	app.Description += " By default xds remote debug is used and you need to define XDS_NATIVE_GDB to\n"
 	app.Description += " use native gdb debug mode instead.\n"
+	app.Description += " gdb can also be run through any command (eg. ssh, container or chroot) by\n"
+	app.Description += " defining a command template in XDS_GDB_TEMPLATE, for example:\n"
+	app.Description += "     XDS_GDB_TEMPLATE='ssh board \"cd {cwd} && env {env} {gdb} {args}\"'\n"
+	app.Description += "\n"
+	app.Description += " Use --dap option to run xds-gdb as a Debug Adapter Protocol server (on\n"
+	app.Description += " stdin/stdout) that can be used directly by DAP clients (eg. VS Code).\n"
 	app.Description += "\n"
 	app.Description += " xds-gdb configuration (see variables list below) can be set using:\n"
 	app.Description += "  - a config file (XDS_CONFIG)\n"
+	app.Description += "  - or a named profile (XDS_PROFILE) of " + xdsProfilesFile + " profiles config file, where\n"
+	app.Description += "    settings are variables names without XDS_ prefix, for example:\n"
+	app.Description += "     [profiles.m3ulcb]\n"
+	app.Description += "     project_id = \"IW7B4EE-DBY4Z74_myProject\"\n"
+	app.Description += "     sdk_id = \"poky-agl_aarch64_3.99.1+snapshot\"\n"
 	app.Description += "  - or environment variables\n"
 	app.Description += "  - or by setting variables within gdb ini file (commented line including :XDS-ENV: tag)\n"
 	app.Description += "    Example of gdb ini file where we define project and sdk ID:\n"
 	app.Description += "     # :XDS-ENV: XDS_PROJECT_ID=IW7B4EE-DBY4Z74_myProject\n"
 	app.Description += "     # :XDS-ENV: XDS_SDK_ID=poky-agl_aarch64_3.99.1+snapshot\n"
+	app.Description += " Values of env config files and :XDS-ENV: tags may reference variables (${VAR},\n"
+	app.Description += " ${VAR:-default}, ${CWD}, ${XDS_PROJECT_ROOT} set to first parent directory including .git)\n"
+	app.Description += " and other env files may be included, for example:\n"
+	app.Description += "     # :XDS-ENV: include ${HOME}/.config/xds/common.env\n"
+	app.Description += "     # :XDS-ENV: XDS_RPATH=${XDS_PROJECT_ROOT:-${CWD}}/build\n"
+	app.Description += "\n"
+	app.Description += " When SDK is not set, the default SDK of project is used, else the SDK matching the\n"
+	app.Description += " ELF header (machine, class, ABI) of debugged program (newest version first).\n"
+	app.Description += " When project or SDK is not set and xds-gdb is run from a terminal, they can be\n"
+	app.Description += " interactively selected and saved into env config file or gdb command file.\n"
+	app.Description += "\n"
+	app.Description += " " + xdsEnvFile + " env config files of several levels are merged, from lowest to\n"
+	app.Description += " highest precedence:\n"
+	app.Description += "  1. system file: " + path.Join(configSystemDir, xdsEnvFile) + "\n"
+	app.Description += "  2. user file: $XDG_CONFIG_HOME/xds/" + xdsEnvFile + " (default ~/.config/xds)\n"
+	app.Description += "  3. files of directories from project root (directory including .git, else home\n"
+	app.Description += "     directory or mount point) down to current directory, then ./target/" + xdsEnvFile + "\n"
+	app.Description += "  4. XDS_CONFIG file\n"
+	app.Description += "  5. :XDS-ENV: tags of gdb command file\n"
+	app.Description += " Environment variables take precedence over all config files. Files of levels 1 to 3\n"
+	app.Description += " and included files not owned by user (or root) or writable by group or others are\n"
+	app.Description += " ignored.\n"
+	app.Description += "\n"
+	app.Description += " XDS agent may be reached through a unix socket, where access is controlled by\n"
+	app.Description += " filesystem permissions, eg. XDS_AGENT_URL=unix:///run/user/1000/xds-agent.sock\n"
+	app.Description += " XDS agent url may use https (or wss) scheme, XDS_TLS_* variables define the CA\n"
+	app.Description += " bundle, client certificate or disable certificate verification for lab setups.\n"
+	app.Description += " XDS_TRANSPORT selects protocol of events channel: socketio (default) or websocket\n"
+	app.Description += " (JSON messages {\"event\": name, \"data\": data} on /api/v1" + wsEventsPath + "), REST requests use HTTP.\n"
+	app.Description += " Heartbeats measure XDS agent latency: a warning is reported (on stderr, or as a log\n"
+	app.Description += " stream record with MI interpreter) above XDS_LATENCY_THRESHOLD and link is lost\n"
+	app.Description += " after XDS_HEARTBEAT_MAX_MISSED missed heartbeats. Latency is reported at exit.\n"
+	app.Description += " Heartbeats are websocket pings with websocket transport. With socketio transport they\n"
+	app.Description += " are " + sioPingEvent + " events when XDS agent advertises " + capEventPing + " in " + capabilitiesHeader + "\n"
+	app.Description += " header of version response, GET /version requests otherwise.\n"
+	app.Description += " When link to XDS agent is lost, reconnection is tried during XDS_RECONNECT_TIMEOUT. The\n"
+	app.Description += " running command is then reattached (IOW output emitted meanwhile is received) when XDS\n"
+	app.Description += " agent advertises " + capReattach + " (POST " + reattachURL + " request), else this output is lost.\n"
+	app.Description += " When XDS agent is started at the same time (eg. by an IDE), set XDS_RETRY_TIMEOUT\n"
+	app.Description += " to retry connection with an exponential backoff while it is not ready.\n"
+	app.Description += " HTTP_PROXY, HTTPS_PROXY and NO_PROXY env vars are honoured (except for localhost),\n"
+	app.Description += " XDS_PROXY overwrites them (set it to none to disable proxy).\n"
+	app.Description += " When XDS agent requires authentication, token is read from XDS_AGENT_TOKEN, else\n"
+	app.Description += " from XDS_AGENT_TOKEN_FILE file (only accessible by owner), else from output of\n"
+	app.Description += " XDS_AGENT_TOKEN_HELPER command, else from ~/.config/xds/" + xdsAgentTokenFile + " file.\n"
+	app.Description += "\n"
+	app.Description += " Use '" + AppName + " config show' to print settings and where they come from, and\n"
+	app.Description += " '" + AppName + " config get|set|unset <NAME> [VALUE]' to edit config file.\n"
 	app.Description += "\n"
 	app.Description += dynDesc + "\n"
 
@@ -271,10 +545,17 @@ endloop:
 		var err error
 		curDir, _ := os.Getwd()
 
+		// Check all settings before any connection
+		if err := validateConfig(appEnvVars, envMap, origins); err != nil {
+			return cli.NewExitError(err.Error(), int(syscall.EINVAL))
+		}
+
 		// Build env variables
 		env := []string{}
 		for k, v := range envMap {
-			env = append(env, k+"="+v)
+			if ev := findEnvVar(appEnvVars, k); ev == nil || !ev.Secret {
+				env = append(env, k+"="+v)
+			}
 		}
 
 		// Now set logger level and log file to correct/env var settings
@@ -296,24 +577,79 @@ endloop:
 			log.Out = fdL
 		}
 
-		// Create cross or native gdb interface
+		// Create cross, native or command template gdb interface
 		var gdb IGDB
 		if gdbNative != "" {
 			gdb = NewGdbNative(log, gdbArgs, env)
+			if strings.Contains(gdbNative, "/") {
+				gdb.SetConfig("gdb", gdbNative)
+			}
+		} else if gdbTemplate != "" {
+			gdb = NewGdbTemplate(log, gdbArgs, env)
+			gdb.SetConfig("template", gdbTemplate)
+			gdb.SetConfig("gdb", gdbTemplateGdb)
 		} else {
 			gdb = NewGdbXds(log, gdbArgs, env)
 			gdb.SetConfig("agentURL", agentURL)
+			gdb.SetConfig("tlsCAFile", tlsCAFile)
+			gdb.SetConfig("tlsCertFile", tlsCertFile)
+			gdb.SetConfig("tlsKeyFile", tlsKeyFile)
+			if err := gdb.SetConfig("tlsInsecure", tlsInsecure); err != nil {
+				return cli.NewExitError(err.Error(), int(syscall.EINVAL))
+			}
+			for name, val := range map[string]string{
+				"transport":          transport,
+				"proxy":              proxy,
+				"connectTimeout":     connectTmo,
+				"requestTimeout":     requestTmo,
+				"retryTimeout":       retryTmo,
+				"heartbeatInterval":  heartbeatInterval,
+				"heartbeatMaxMissed": heartbeatMaxMissed,
+				"latencyThreshold":   latencyThreshold,
+			} {
+				if err := gdb.SetConfig(name, val); err != nil {
+					return cli.NewExitError(err.Error(), int(syscall.EINVAL))
+				}
+			}
+			gdb.SetConfig("agentToken", agentToken)
+			gdb.SetConfig("agentTokenFile", agentTokenFile)
+			gdb.SetConfig("agentTokenHelper", agentTokenHelper)
 			gdb.SetConfig("serverURL", serverURL)
+			gdb.SetConfig("serverID", serverID)
 			gdb.SetConfig("prjID", prjID)
 			gdb.SetConfig("sdkID", sdkid)
 			gdb.SetConfig("rPath", rPath)
 			gdb.SetConfig("listProject", listProject)
+			if err := gdb.SetConfig("listFormat", listFormat); err != nil {
+				return cli.NewExitError(err.Error(), int(syscall.EINVAL))
+			}
+
+			// Interactively select project and SDK when not set and when
+			// run from a terminal (IOW not by an IDE using MI interpreter)
+			if isTerminal(os.Stdin.Fd()) && isTerminal(os.Stdout.Fd()) && !listProject && !dapMode &&
+				!strings.Contains(strings.Join(gdbArgs, " "), "--interpreter") {
+				saveFiles := []PickerSaveFile{}
+				if gdbCmdFile != "" && common.Exists(gdbCmdFile) {
+					saveFiles = append(saveFiles, PickerSaveFile{File: gdbCmdFile, CmdFile: true})
+				}
+				if cf := configTargetFile(); !isProfilesFile(cf) && cf != gdbCmdFile {
+					saveFiles = append(saveFiles, PickerSaveFile{File: cf})
+				}
+				gdb.SetConfig("picker", NewPicker(os.Stdin, os.Stdout, saveFiles))
+			}
+			if err := gdb.SetConfig("pathMap", pathMap); err != nil {
+				return cli.NewExitError(err.Error(), int(syscall.EINVAL))
+			}
+			if err := gdb.SetConfig("reconnectTimeout", reconnectTmo); err != nil {
+				return cli.NewExitError(err.Error(), int(syscall.EINVAL))
+			}
 		}
 
 		// Log useful info
 		log.Infof("Original arguments: %v", os.Args)
 		log.Infof("Current directory : %v", curDir)
 		log.Infof("Use confFile      : '%s'", confFile)
+		log.Infof("Use profile       : '%s' (%s)", profile, profFile)
 		log.Infof("Execute           : /exec %v %v", gdb.Cmd(), gdb.Args())
 
 		// Properly report invalid init file error
@@ -329,10 +665,50 @@ endloop:
 		}
 		log.Infof("Add detection of error: <%s>", gdbCommandFileError)
 
+		// Allow to overwrite some gdb commands
+		// (XDS_OVERWRITE_COMMANDS is still supported and takes precedence over presets)
+		var rewriter *MIRewriter
+		if overEnv, exist := os.LookupEnv("XDS_OVERWRITE_COMMANDS"); exist {
+			rules, err := ParseMIRewriteLegacy(overEnv)
+			if err == nil {
+				rewriter, err = NewMIRewriterFromRules(rules)
+			}
+			if err != nil {
+				return cli.NewExitError(err.Error(), int(syscall.EINVAL))
+			}
+		} else {
+			// XDS presets are only applied by default to XDS backend (IOW
+			// gdb already connected to gdbserver), commands sent to native
+			// and template backends are kept as is (eg. -exec-run of DAP launch)
+			preset := overwritePreset
+			if preset == "" && overwriteRules == "" && (gdbNative != "" || gdbTemplate != "") {
+				preset = miNonePreset
+			}
+			if rewriter, err = NewMIRewriter(overwriteRules, preset); err != nil {
+				return cli.NewExitError(err.Error(), int(syscall.EINVAL))
+			}
+		}
+		log.Debugf("Overwrite rules = %v", rewriter)
+
+		// Debug Adapter Protocol mode: init and start are driven by DAP requests
+		if dapMode {
+			if gdbNative == "" && gdbTemplate == "" && (prjID == "" || listProject) {
+				return cli.NewExitError("XDS_PROJECT_ID must be set in DAP mode", int(syscall.EINVAL))
+			}
+			code, err := runDapServer(log, gdb, rewriter)
+			if err != nil {
+				return cli.NewExitError(err.Error(), code)
+			}
+			return cli.NewExitError("", code)
+		}
+
 		// Init gdb subprocess management
 		if code, err := gdb.Init(); err != nil {
 			return cli.NewExitError(err.Error(), code)
 		}
+		if listProject {
+			return nil
+		}
 
 		exitChan := make(chan exitResult, 1)
 
@@ -366,9 +742,18 @@ endloop:
 					log.Debugf("Recv ERR (FILTERED OUT): <%s>", stderr)
 				}
 			}
+		})
+
+		gdb.OnMIRecord(func(rec *MIRecord) {
+			log.Debugf("Recv MI record: type=%s token=%s class=%s <%s>", rec.Type, rec.Token, rec.Class, rec.Raw)
 
 			// Correctly report error about init file
-			if gdbCommandFileError != "" && strings.Contains(stdout, gdbCommandFileError) {
+			// (check complete lines, message may be split into several chunks)
+			text := rec.Raw
+			if rec.IsStream() {
+				text = rec.Stream
+			}
+			if gdbCommandFileError != "" && strings.Contains(text, gdbCommandFileError) {
 				fmt.Fprintf(os.Stderr, "ERROR: "+gdbCommandFileError)
 				log.Errorf("ERROR: " + gdbCommandFileError)
 				if err := gdb.SendSignal(syscall.SIGTERM); err != nil {
@@ -384,7 +769,7 @@ endloop:
 
 		// Handle client tty / pts
 		if clientPty != "" {
-			log.Infoln("Client tty detected: %v", clientPty)
+			log.Infof("Client tty detected: %v", clientPty)
 
 			cpFd, err := os.OpenFile(clientPty, os.O_RDWR, 0)
 			if err != nil {
@@ -393,20 +778,19 @@ endloop:
 			defer cpFd.Close()
 
 			// client tty stdin
-			/* XXX TODO - implement stdin to send data to debugged program
 			go func() {
-				reader := bufio.NewReader(cpFd)
-				sc := bufio.NewScanner(reader)
+				sc := bufio.NewScanner(cpFd)
 				for sc.Scan() {
 					data := sc.Text()
-					iosk.Emit(xaapiv1.ExecInferiorInEvent, data+"\n")
+					if err := gdb.InferiorWrite(data + "\n"); err != nil {
+						log.Errorf("Error while sending inferior input: %s", err.Error())
+					}
 					log.Debugf("Inferior IN: <%v>", data)
 				}
 				if sc.Err() != nil {
 					log.Warnf("Inferior Stdin scanner exit, close stdin (err=%v)", sc.Err())
 				}
 			}()
-			*/
 
 			// client tty stdout
 			gdb.InferiorRead(func(timestamp, stdout, stderr string) {
@@ -421,76 +805,8 @@ endloop:
 			})
 		}
 
-		// Allow to overwrite some gdb commands
-		var overwriteMap = make(map[string]string)
-		if overEnv, exist := os.LookupEnv("XDS_OVERWRITE_COMMANDS"); exist {
-			overEnvS := strings.TrimSpace(overEnv)
-			if len(overEnvS) > 0 {
-				// Extract overwrite commands from env variable
-				for _, def := range strings.Split(overEnvS, ",") {
-					if kv := strings.Split(def, ":"); len(kv) == 2 {
-						overwriteMap[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
-					} else {
-						return cli.NewExitError(
-							fmt.Errorf("Invalid definition in XDS_OVERWRITE_COMMANDS (%s)", def),
-							int(syscall.EINVAL))
-					}
-				}
-			}
-		} else {
-			overwriteMap["-exec-run"] = "-exec-continue"
-			overwriteMap["-file-exec-and-symbols"] = "-file-exec-file"
-		}
-		log.Debugf("overwriteMap = %v", overwriteMap)
-
 		// Send stdin though WS
-		go func() {
-			paranoia := 600
-			reader := bufio.NewReader(os.Stdin)
-
-			// Enable workaround to correctly close connection
-			// except if XDS_GDBSERVER_EXIT_NOFIX is defined
-			_, gdbExitNoFix := os.LookupEnv("XDS_GDBSERVER_EXIT_NOFIX")
-
-			for {
-				sc := bufio.NewScanner(reader)
-				for sc.Scan() {
-					command := sc.Text()
-
-					// overwrite some commands
-					for key, value := range overwriteMap {
-						if strings.Contains(command, key) {
-							command = strings.Replace(command, key, value, 1)
-							log.Debugf("OVERWRITE %s -> %s", key, value)
-						}
-					}
-
-					// Send SIGINT to stop debugged process execution before sending -gdb-exit command
-					if !gdbExitNoFix && strings.Contains(command, "-gdb-exit") {
-						log.Infof("Detection of -gdb-exit, exiting...")
-						if err := gdb.SendSignal(syscall.SIGINT); err != nil {
-							log.Errorf("Error while sending signal SIGINT : %s", err.Error())
-						}
-						time.Sleep(time.Millisecond * 200)
-					}
-
-					log.Debugf("Send: <%v>", command)
-					gdb.Write(command + "\n")
-				}
-				log.Infof("Stdin scanner exit, close stdin (err=%v)", sc.Err())
-
-				// CTRL-D exited scanner, so send it explicitly
-				gdb.Write("\x04")
-				time.Sleep(time.Millisecond * 100)
-
-				if paranoia--; paranoia <= 0 {
-					msg := "Abnormal loop detected on stdin"
-					log.Errorf("Abnormal loop detected on stdin")
-					gdb.SendSignal(syscall.SIGTERM)
-					exitChan <- exitResult{fmt.Errorf(msg), int(syscall.ELOOP)}
-				}
-			}
-		}()
+		go forwardStdin(gdb, rewriter, os.Stdin, os.Stdout, exitChan)
 
 		// Handling all Signals
 		sigs := make(chan os.Signal, 1)
@@ -533,60 +849,133 @@ endloop:
 	app.Run(args)
 }
 
-// loadConfigEnvFile
-func loadConfigEnvFile(confFile, gdbCmdFile string) (map[string]string, string, error) {
-	var err error
+// forwardStdin reads gdb commands from in, applies overwrite rules and sends
+// them to gdb (replies of dropped commands are written into out)
+func forwardStdin(gdb IGDB, rewriter *MIRewriter, in io.Reader, out io.Writer, exitChan chan exitResult) {
+	paranoia := 600
+	reader := bufio.NewReader(in)
+
+	// Enable workaround to correctly close connection
+	// except if XDS_GDBSERVER_EXIT_NOFIX is defined (whatever its value)
+	_, gdbExitNoFix := os.LookupEnv("XDS_GDBSERVER_EXIT_NOFIX")
+
+	for {
+		sc := bufio.NewScanner(reader)
+		for sc.Scan() {
+			miCmd := ParseMICommand(sc.Text())
+
+			// overwrite some commands
+			if !rewriter.Rewrite(miCmd) {
+				log.Debugf("OVERWRITE drop <%v>", sc.Text())
+				fmt.Fprintf(out, "%s^done\n(gdb) \n", miCmd.Token)
+				continue
+			}
+			command := miCmd.String()
+			if command != sc.Text() {
+				log.Debugf("OVERWRITE <%v> -> <%v>", sc.Text(), command)
+			}
+
+			// Send SIGINT to stop debugged process execution before sending -gdb-exit command
+			if !gdbExitNoFix && !miCmd.IsCLI && miCmd.Operation == "gdb-exit" {
+				log.Infof("Detection of -gdb-exit, exiting...")
+				if err := gdb.SendSignal(syscall.SIGINT); err != nil {
+					log.Errorf("Error while sending signal SIGINT : %s", err.Error())
+				}
+				time.Sleep(time.Millisecond * 200)
+			}
+
+			log.Debugf("Send: <%v>", command)
+			gdb.Write(command + "\n")
+		}
+		log.Infof("Stdin scanner exit, close stdin (err=%v)", sc.Err())
+
+		// CTRL-D exited scanner, so send it explicitly
+		gdb.Write("\x04")
+		time.Sleep(time.Millisecond * 100)
+
+		if paranoia--; paranoia <= 0 {
+			msg := "Abnormal loop detected on stdin"
+			log.Errorf("Abnormal loop detected on stdin")
+			gdb.SendSignal(syscall.SIGTERM)
+			exitChan <- exitResult{fmt.Errorf(msg), int(syscall.ELOOP)}
+		}
+	}
+}
+
+// loadConfigEnvFile loads and merges config env files of all levels, XDS_CONFIG
+// file and :XDS-ENV: tags of gdb command file (see config.go for precedence).
+// Returns defined variables, config file of highest precedence and variables
+// origin (IOW file and line, or env when overridden by environment)
+func loadConfigEnvFile(confFile, gdbCmdFile string) (map[string]string, string, map[string]ConfigOrigin, error) {
 	envMap := make(map[string]string)
+	origins := make(map[string]ConfigOrigin)
+
+	// 1- discovered xds-gdb.env files (system, user and directories levels)
+	layers := []configLayer{}
+	absConfFile, _ := filepath.Abs(confFile)
+	for _, f := range configLayerFiles(xdsEnvFile) {
+		if absF, _ := filepath.Abs(f); confFile != "" && absF == absConfFile {
+			continue
+		}
+		lines, err := readConfigLines(f)
+		if err != nil {
+			return envMap, f, origins, fmt.Errorf("Error reading env config file " + f)
+		}
+		layers = append(layers, configLayer{File: f, Lines: lines})
+	}
 
-	// 1- if no confFile set, use setting from gdb command file is option
-	//    --command/-x is set
-	if confFile == "" && gdbCmdFile != "" {
-		log.Infof("Try extract config from gdbCmdFile: %s", gdbCmdFile)
-		confFile, err = extractEnvFromCmdFile(gdbCmdFile)
-		if confFile != "" {
-			defer os.Remove(confFile)
+	// 2- file set by XDS_CONFIG
+	if confFile != "" {
+		if !common.Exists(confFile) {
+			return envMap, confFile, origins, fmt.Errorf("Error env config file %s not found", confFile)
 		}
+		lines, err := readConfigLines(confFile)
 		if err != nil {
-			log.Infof("Extraction from gdbCmdFile failed: %v", err.Error())
+			return envMap, confFile, origins, fmt.Errorf("Error reading env config file " + confFile)
 		}
+		layers = append(layers, configLayer{File: confFile, Lines: lines})
 	}
-	// 2- search xds-gdb.env file in various locations
-	if confFile == "" {
-		curDir, _ := os.Getwd()
-		if u, err := user.Current(); err == nil {
-			xdsEnvFile := "xds-gdb.env"
-			for _, d := range []string{
-				path.Join(curDir),
-				path.Join(curDir, ".."),
-				path.Join(curDir, "target"),
-				path.Join(u.HomeDir, ".config", "xds"),
-			} {
-				cf := path.Join(d, xdsEnvFile)
-				log.Infof("Search config in %s", cf)
-				if common.Exists(cf) {
-					confFile = cf
-					break
-				}
-			}
+
+	// 3- settings of gdb command file when option --command/-x is set
+	if gdbCmdFile != "" {
+		log.Infof("Try extract config from gdbCmdFile: %s", gdbCmdFile)
+		lines, err := extractEnvFromCmdFile(gdbCmdFile)
+		if err != nil {
+			log.Infof("Extraction from gdbCmdFile failed: %v", err.Error())
+		}
+		if len(lines) > 0 {
+			layers = append(layers, configLayer{File: gdbCmdFile, Lines: lines})
 		}
 	}
 
-	if confFile == "" {
+	if len(layers) == 0 {
 		log.Infof("NO valid conf file found!")
-		return envMap, "", nil
+		return envMap, "", origins, nil
+	}
+	for _, l := range layers {
+		log.Infof("Load env config file %s", l.File)
 	}
+	confFile = layers[len(layers)-1].File
 
-	if !common.Exists(confFile) {
-		return envMap, confFile, fmt.Errorf("Error no env config file not found")
+	entries, err := readConfigLayers(layers)
+	if err != nil {
+		return envMap, confFile, origins, fmt.Errorf("Error reading env config file %v", err)
 	}
-	if err = godotenv.Load(confFile); err != nil {
-		return envMap, confFile, fmt.Errorf("Error loading env config file " + confFile)
+
+	// Last definition wins and environment variables take precedence over
+	// config file
+	for _, e := range entries {
+		envMap[e.Name] = e.Value
+		origins[e.Name] = e.Origin
 	}
-	if envMap, err = godotenv.Read(confFile); err != nil {
-		return envMap, confFile, fmt.Errorf("Error reading env config file " + confFile)
+	for k, v := range envMap {
+		if _, exist := os.LookupEnv(k); exist {
+			origins[k] = ConfigOrigin{Overrides: origins[k].String()}
+			continue
+		}
+		os.Setenv(k, v)
 	}
-
-	return envMap, confFile, nil
+	return envMap, confFile, origins, nil
 }
 
 /*
@@ -597,55 +986,32 @@ func loadConfigEnvFile(confFile, gdbCmdFile string) (map[string]string, string,
   # :XDS-ENV: XDS_PROJECT_ID=4021617e-ced0-11e7-acd2-3c970e49ad9b
   #:XDS-ENV:XDS_SDK_ID=06c0e95a-e215-3a5a-b373-f677c0dabd3b
   # :XDS-ENV:  export XDS_AGENT_URL=localhost:8800
+  Variables references and include directive are also supported, for example:
+  # :XDS-ENV: include ../common/xds-gdb.env
+  # :XDS-ENV: XDS_RPATH=${CWD}/build
 */
-func extractEnvFromCmdFile(cmdFile string) (string, error) {
+func extractEnvFromCmdFile(cmdFile string) ([]configLine, error) {
 	if !common.Exists(cmdFile) {
-		return "", nil
+		return nil, nil
 	}
-	cFd, err := os.Open(cmdFile)
+	lines, err := readConfigLines(cmdFile)
 	if err != nil {
-		return "", fmt.Errorf("Cannot open %s : %s", cmdFile, err.Error())
-	}
-	defer cFd.Close()
-
-	var lines []string
-	scanner := bufio.NewScanner(cFd)
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
-	}
-	if err = scanner.Err(); err != nil {
-		return "", fmt.Errorf("Cannot parse %s : %s", cmdFile, err.Error())
+		return nil, fmt.Errorf("Cannot parse %s : %s", cmdFile, err.Error())
 	}
 
-	envFile, err := ioutil.TempFile("", "xds-gdb_env.ini")
-	if err != nil {
-		return "", fmt.Errorf("Error while creating temporary env file: %s", err.Error())
-	}
-	envFileName := envFile.Name()
-	defer envFile.Close()
-
-	envFound := false
+	// keep line number in gdb command file of each extracted line
+	envLines := []configLine{}
 	for _, ln := range lines {
-		ln = strings.TrimSpace(ln)
-		if strings.HasPrefix(ln, "#") && strings.Contains(ln, ":XDS-ENV:") {
-			env := strings.SplitAfterN(ln, ":XDS-ENV:", 2)
+		text := strings.TrimSpace(ln.Text)
+		if strings.HasPrefix(text, "#") && strings.Contains(text, ":XDS-ENV:") {
+			env := strings.SplitAfterN(text, ":XDS-ENV:", 2)
 			if len(env) == 2 {
-				envFound = true
-				if _, err := envFile.WriteString(strings.TrimSpace(env[1]) + "\n"); err != nil {
-					return "", fmt.Errorf("Error write into temporary env file: %s", err.Error())
-				}
+				envLines = append(envLines, configLine{Text: strings.TrimSpace(env[1]), Num: ln.Num})
 			} else {
-				log.Warnf("Error while decoding line %s", ln)
+				log.Warnf("Error while decoding line %s", text)
 			}
 		}
 	}
 
-	if !envFound {
-		ff := envFileName
-		defer os.Remove(ff)
-		envFileName = ""
-
-	}
-
-	return envFileName, nil
+	return envLines, nil
 }
@@ -0,0 +1,170 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Picker - Interactive selector used to choose project and SDK when they are
+// not set and xds-gdb is run from a terminal
+type Picker struct {
+	in        *bufio.Reader
+	out       io.Writer
+	saveFiles []PickerSaveFile
+}
+
+// PickerSaveFile - File where selected settings may be saved
+type PickerSaveFile struct {
+	File    string
+	CmdFile bool // gdb command file (settings saved as :XDS-ENV: tags)
+}
+
+// NewPicker creates a new instance of Picker
+func NewPicker(in io.Reader, out io.Writer, saveFiles []PickerSaveFile) *Picker {
+	return &Picker{
+		in:        bufio.NewReader(in),
+		out:       out,
+		saveFiles: saveFiles,
+	}
+}
+
+// Pick displays rows and returns index of the selected one.
+// Typing a number selects a row, typing a text only keeps rows containing
+// this text and an empty line resets filter or aborts selection.
+func (p *Picker) Pick(title string, header []string, rows [][]string) (int, error) {
+	if len(rows) == 0 {
+		return -1, fmt.Errorf("No %s to select", title)
+	}
+
+	filter := ""
+	for {
+		idx := []int{}
+		for i, r := range rows {
+			if filter == "" || strings.Contains(strings.ToLower(strings.Join(r, " ")), strings.ToLower(filter)) {
+				idx = append(idx, i)
+			}
+		}
+		if len(idx) == 0 {
+			fmt.Fprintf(p.out, "No %s matching '%s'\n", title, filter)
+			filter = ""
+			continue
+		}
+
+		if filter == "" {
+			fmt.Fprintf(p.out, "\nSelect %s:\n", title)
+		} else {
+			fmt.Fprintf(p.out, "\nSelect %s (matching '%s'):\n", title, filter)
+		}
+		writer := new(tabwriter.Writer)
+		writer.Init(p.out, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(writer, "    \t%s\n", strings.Join(header, "\t"))
+		for n, i := range idx {
+			fmt.Fprintf(writer, "[%d]\t%s\n", n+1, strings.Join(rows[i], "\t"))
+		}
+		writer.Flush()
+
+		fmt.Fprint(p.out, "Enter number, text to filter or empty line to ")
+		if filter == "" {
+			fmt.Fprint(p.out, "abort: ")
+		} else {
+			fmt.Fprint(p.out, "reset filter: ")
+		}
+		line, err := p.readLine()
+		if err != nil {
+			return -1, fmt.Errorf("Selection of %s aborted", title)
+		}
+		switch n, errN := strconv.Atoi(line); {
+		case line == "" && filter == "":
+			return -1, fmt.Errorf("Selection of %s aborted", title)
+		case line == "":
+			filter = ""
+		case errN == nil && n >= 1 && n <= len(idx):
+			return idx[n-1], nil
+		case errN == nil:
+			fmt.Fprintf(p.out, "Invalid number %d\n", n)
+		default:
+			filter = line
+		}
+	}
+}
+
+// Save offers to save settings into one of save files
+func (p *Picker) Save(settings map[string]string) error {
+	if len(p.saveFiles) == 0 || len(settings) == 0 {
+		return nil
+	}
+
+	names := []string{}
+	for n := range settings {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	desc := []string{}
+	for _, n := range names {
+		desc = append(desc, n+"="+settings[n])
+	}
+
+	fmt.Fprintf(p.out, "\nSave selection (%s):\n", strings.Join(desc, " "))
+	for i, f := range p.saveFiles {
+		if f.CmdFile {
+			fmt.Fprintf(p.out, "  [%d] as :XDS-ENV: tags in gdb command file %s\n", i+1, f.File)
+		} else {
+			fmt.Fprintf(p.out, "  [%d] into env config file %s\n", i+1, f.File)
+		}
+	}
+	fmt.Fprint(p.out, "  [n] do not save\nChoice [n]: ")
+	line, _ := p.readLine()
+	n, err := strconv.Atoi(line)
+	if err != nil || n < 1 || n > len(p.saveFiles) {
+		return nil
+	}
+
+	f := p.saveFiles[n-1]
+	for _, name := range names {
+		val := settings[name]
+		if f.CmdFile {
+			err = configEditCmdFile(f.File, name, val)
+		} else {
+			err = configEditFile(f.File, name, &val)
+		}
+		if err != nil {
+			fmt.Fprintf(p.out, "Cannot save selection: %v\n", err)
+			return err
+		}
+	}
+	fmt.Fprintf(p.out, "Selection saved into %s\n\n", f.File)
+	return nil
+}
+
+//***** Private functions *****
+
+func (p *Picker) readLine() (string, error) {
+	line, err := p.in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+var testPickerRows = [][]string{
+	{"0a", "helloworld", "fake-server"},
+	{"1a", "another-prj", "fake-server"},
+	{"2b", "hello-other", "other-server"},
+}
+
+func TestPickerPick(t *testing.T) {
+	for input, exp := range map[string]int{
+		"2\n":                 1,
+		"hello\n2\n":          2,
+		"OTHER\n2\n":          2,
+		"zzz\n3\n":            2,
+		"9\nhello\n\n1\n":     0,
+		"\n":                  -1,
+		"hello\n\n\n":         -1,
+		"another":             -1,
+		"":                    -1,
+		"another\n1 \n":       1,
+		"fake-server\n0\n2\n": 1,
+	} {
+		var out bytes.Buffer
+		p := NewPicker(strings.NewReader(input), &out, nil)
+		n, err := p.Pick("project", []string{"ID", "Label", "Server"}, testPickerRows)
+		if n != exp || (exp == -1) != (err != nil) {
+			t.Errorf("Unexpected result for input %q: %d %v (expected %d)\n%s", input, n, err, exp, out.String())
+		}
+	}
+
+	var out bytes.Buffer
+	p := NewPicker(strings.NewReader("hello\n"), &out, nil)
+	p.Pick("project", []string{"ID", "Label", "Server"}, testPickerRows)
+	filtered := out.String()[strings.Index(out.String(), "Select project (matching 'hello'):"):]
+	if !strings.Contains(filtered, "[2]   2b  hello-other") || strings.Contains(filtered, "another-prj") {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+
+	if _, err := p.Pick("SDK", nil, nil); err == nil {
+		t.Errorf("Pick without rows must fail")
+	}
+}
+
+func TestPickerSave(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "xds-gdb-test")
+	defer os.RemoveAll(dir)
+	cmdFile := writeTestFile(t, dir, "gdb.ini", "# my gdb file\n#:XDS-ENV: XDS_RPATH=build\n# :XDS-ENV: XDS_PROJECT_ID=old\nbreak main\n")
+	envFile := path.Join(dir, "xds-gdb.env")
+	saveFiles := []PickerSaveFile{{File: cmdFile, CmdFile: true}, {File: envFile}}
+	settings := map[string]string{"XDS_PROJECT_ID": "0a1b", "XDS_SDK_ID": "ef 23"}
+
+	for _, input := range []string{"\n", "n\n", "3\n", ""} {
+		var out bytes.Buffer
+		if err := NewPicker(strings.NewReader(input), &out, saveFiles).Save(settings); err != nil {
+			t.Errorf("Save failed: %v", err)
+		}
+		if _, err := os.Stat(envFile); err == nil {
+			t.Errorf("Selection must not be saved for input %q", input)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := NewPicker(strings.NewReader("1\n"), &out, saveFiles).Save(settings); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	exp := "# my gdb file\n#:XDS-ENV: XDS_RPATH=build\n# :XDS-ENV: XDS_PROJECT_ID=0a1b\n# :XDS-ENV: XDS_SDK_ID='ef 23'\nbreak main\n"
+	if data, _ := ioutil.ReadFile(cmdFile); string(data) != exp {
+		t.Errorf("Invalid gdb command file:\n%s", data)
+	}
+	lines, _ := extractEnvFromCmdFile(cmdFile)
+	entries, err := readConfigEnv(cmdFile, lines)
+	if err != nil || len(entries) != 3 || entries[2].Value != "ef 23" {
+		t.Errorf("Saved settings cannot be read back: %v %v", entries, err)
+	}
+
+	out.Reset()
+	if err := NewPicker(strings.NewReader("2\n"), &out, saveFiles).Save(settings); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(envFile); string(data) != "XDS_PROJECT_ID=0a1b\nXDS_SDK_ID='ef 23'\n" {
+		t.Errorf("Invalid env file:\n%s", data)
+	}
+	if !strings.Contains(out.String(), "Selection saved into "+envFile) {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+}
+
+func TestConfigEditCmdFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "xds-gdb-test")
+	defer os.RemoveAll(dir)
+	cmdFile := writeTestFile(t, dir, "gdb.ini", "file main\nbreak main\n")
+	if err := configEditCmdFile(cmdFile, "XDS_SDK_ID", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(cmdFile); string(data) != "# :XDS-ENV: XDS_SDK_ID=abc\nfile main\nbreak main\n" {
+		t.Errorf("Invalid gdb command file:\n%s", data)
+	}
+	if err := configEditCmdFile(path.Join(dir, "missing.ini"), "XDS_SDK_ID", "abc"); err == nil {
+		t.Errorf("Missing gdb command file must be rejected")
+	}
+}